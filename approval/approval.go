@@ -0,0 +1,306 @@
+// Package approval detects token approvals in transaction input. An approval
+// lets a spender move the owner's tokens later on, so an unexpected one is
+// worth catching while it is still pending.
+//
+// These calls are recognized:
+//
+//   - ERC-20 approve and increaseAllowance, and ERC-721 approve of a single token
+//   - setApprovalForAll, shared by ERC-721 and ERC-1155
+//   - ERC-2612 permit, submitted by anyone on behalf of the signing owner
+//   - Permit2 approve, and permit of single and batched allowances
+package approval
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/bonedaddy/go-blocknative/calldata"
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+)
+
+// Standards an approval is made under
+const (
+	ERC20   = "erc20"
+	ERC721  = "erc721"
+	ERC2612 = "erc2612"
+	// setApprovalForAll has the same signature under ERC-721 and ERC-1155
+	ERC721Or1155 = "erc721/erc1155"
+	Permit2      = "permit2"
+)
+
+// Reasons an approval is reported for
+const (
+	Unlimited      = "unlimited allowance"
+	NotAllowlisted = "spender not allowlisted"
+)
+
+// Permit2Address is where Uniswap's Permit2 contract is deployed on every chain
+const Permit2Address = "0x000000000022d473030f116ddee9f6b43ac78ba3"
+
+// abiJSON declares the approval methods with their argument names
+const abiJSON = `[
+	{"type": "function", "name": "approve", "inputs": [
+		{"name": "spender", "type": "address"}, {"name": "amount", "type": "uint256"}]},
+	{"type": "function", "name": "increaseAllowance", "inputs": [
+		{"name": "spender", "type": "address"}, {"name": "addedValue", "type": "uint256"}]},
+	{"type": "function", "name": "setApprovalForAll", "inputs": [
+		{"name": "operator", "type": "address"}, {"name": "approved", "type": "bool"}]},
+	{"type": "function", "name": "permit", "inputs": [
+		{"name": "owner", "type": "address"}, {"name": "spender", "type": "address"}, {"name": "value", "type": "uint256"},
+		{"name": "deadline", "type": "uint256"}, {"name": "v", "type": "uint8"}, {"name": "r", "type": "bytes32"}, {"name": "s", "type": "bytes32"}]},
+	{"type": "function", "name": "approve", "inputs": [
+		{"name": "token", "type": "address"}, {"name": "spender", "type": "address"},
+		{"name": "amount", "type": "uint160"}, {"name": "expiration", "type": "uint48"}]},
+	{"type": "function", "name": "permit", "inputs": [
+		{"name": "owner", "type": "address"},
+		{"name": "permitSingle", "type": "tuple", "components": [
+			{"name": "details", "type": "tuple", "components": [
+				{"name": "token", "type": "address"}, {"name": "amount", "type": "uint160"},
+				{"name": "expiration", "type": "uint48"}, {"name": "nonce", "type": "uint48"}]},
+			{"name": "spender", "type": "address"}, {"name": "sigDeadline", "type": "uint256"}]},
+		{"name": "signature", "type": "bytes"}]},
+	{"type": "function", "name": "permit", "inputs": [
+		{"name": "owner", "type": "address"},
+		{"name": "permitBatch", "type": "tuple", "components": [
+			{"name": "details", "type": "tuple[]", "components": [
+				{"name": "token", "type": "address"}, {"name": "amount", "type": "uint160"},
+				{"name": "expiration", "type": "uint48"}, {"name": "nonce", "type": "uint48"}]},
+			{"name": "spender", "type": "address"}, {"name": "sigDeadline", "type": "uint256"}]},
+		{"name": "signature", "type": "bytes"}]}
+]`
+
+// selectors of the approval methods
+const (
+	approveSelector           = "0x095ea7b3"
+	increaseAllowanceSelector = "0x39509351"
+	setApprovalForAllSelector = "0xa22cb465"
+	permitSelector            = "0xd505accf"
+	permit2ApproveSelector    = "0x87517c45"
+	permit2SingleSelector     = "0x2b67b570"
+	permit2BatchSelector      = "0x2a2d80d1"
+)
+
+// Approval is a single allowance granted by a transaction
+type Approval struct {
+	Hash string `json:"hash"`
+	// account whose tokens can be spent
+	Owner string `json:"owner"`
+	// token contract the allowance is for
+	Token    string `json:"token"`
+	Standard string `json:"standard"`
+	Method   string `json:"method"`
+	Spender  string `json:"spender"`
+	// allowance in the token's base unit, unset for setApprovalForAll and ERC-721 approvals
+	Amount string `json:"amount,omitempty"`
+	// approved token of an ERC-721 approval
+	TokenID string `json:"tokenId,omitempty"`
+	// unix time at which a Permit2 allowance expires
+	Expiration string `json:"expiration,omitempty"`
+	// set for allowances of at least half the maximum of the amount's type and approvals for all tokens
+	Unlimited bool `json:"unlimited"`
+	// set for approvals which remove an allowance, a zero amount or setApprovalForAll(false)
+	Revoke bool `json:"revoke"`
+}
+
+// Finding is an approval worth alerting about
+type Finding struct {
+	Approval
+	// Unlimited and or NotAllowlisted
+	Reasons []string `json:"reasons"`
+}
+
+// Opts configures a detector
+type Opts struct {
+	// owners whose approvals are reported, every owner's if empty
+	Owners []string `json:"owners,omitempty" yaml:"owners"`
+	// spenders expected to be approved, limited approvals to them are not reported
+	Allowlist []string `json:"allowlist,omitempty" yaml:"allowlist"`
+	// token contracts whose approve takes a token id rather than an amount
+	NFTs []string `json:"nfts,omitempty" yaml:"nfts"`
+}
+
+// Detector finds approvals in events
+type Detector struct {
+	owners, allowed, nfts map[string]bool
+	decoder               *calldata.Decoder
+}
+
+// New returns a detector, addresses are validated and compared case insensitively
+func New(opts Opts) (*Detector, error) {
+	d := &Detector{decoder: calldata.New()}
+	if err := d.decoder.AddABI(strings.NewReader(abiJSON)); err != nil {
+		return nil, err
+	}
+	var err error
+	if d.owners, err = addressSet(opts.Owners); err != nil {
+		return nil, errors.Wrap(err, "owners")
+	}
+	if d.allowed, err = addressSet(opts.Allowlist); err != nil {
+		return nil, errors.Wrap(err, "allowlist")
+	}
+	if d.nfts, err = addressSet(opts.NFTs); err != nil {
+		return nil, errors.Wrap(err, "nfts")
+	}
+	return d, nil
+}
+
+func addressSet(addrs []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		addr, err := client.NormalizeAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		set[addr] = true
+	}
+	return set, nil
+}
+
+// Detect returns the approvals of ev by the owners which are unlimited or to spenders outside the allowlist
+func (d *Detector) Detect(ev *client.Event) ([]Finding, error) {
+	approvals, err := d.Decode(ev)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, a := range approvals {
+		if a.Revoke || len(d.owners) > 0 && !d.owners[a.Owner] {
+			continue
+		}
+		var reasons []string
+		if a.Unlimited {
+			reasons = append(reasons, Unlimited)
+		}
+		if !d.allowed[a.Spender] {
+			reasons = append(reasons, NotAllowlisted)
+		}
+		if len(reasons) > 0 {
+			findings = append(findings, Finding{Approval: a, Reasons: reasons})
+		}
+	}
+	return findings, nil
+}
+
+// Decode returns every approval made by the transaction of ev, none if it
+// makes no approval
+func (d *Detector) Decode(ev *client.Event) ([]Approval, error) {
+	tx := ev.Payload.Event.Transaction
+	data, err := tx.InputData()
+	if err != nil || len(data) < 4 {
+		return nil, err
+	}
+	call, err := d.decoder.Decode(data)
+	if err != nil {
+		// calls other than approvals are not decoded by the approval abi
+		return nil, nil
+	}
+	base := Approval{
+		Hash:   tx.Hash,
+		Owner:  strings.ToLower(tx.From),
+		Token:  strings.ToLower(tx.To),
+		Method: call.Method,
+	}
+	arg := func(name string) string {
+		a, _ := call.Arg(name)
+		s, _ := a.Value.(string)
+		return s
+	}
+	switch call.Selector {
+	case permit2ApproveSelector, permit2SingleSelector, permit2BatchSelector:
+		// any contract can expose these selectors, only calls to Permit2 itself approve anything
+		if !strings.EqualFold(tx.To, Permit2Address) {
+			return nil, nil
+		}
+	}
+	switch call.Selector {
+	case approveSelector, increaseAllowanceSelector:
+		a := base
+		a.Standard, a.Spender = ERC20, arg("spender")
+		amount := arg("amount")
+		if call.Selector == increaseAllowanceSelector {
+			amount = arg("addedValue")
+		}
+		if call.Selector == approveSelector && d.isNFT(ev, a.Token) {
+			a.Standard, a.TokenID = ERC721, amount
+			// approving the zero address clears the token's approval
+			a.Revoke = a.Spender == zeroAddress
+			return []Approval{a}, nil
+		}
+		a.Amount = amount
+		a.Unlimited, a.Revoke = unlimited(amount, 256), call.Selector == approveSelector && isZero(amount)
+		return []Approval{a}, nil
+	case setApprovalForAllSelector:
+		a := base
+		a.Standard, a.Spender = ERC721Or1155, arg("operator")
+		approved, _ := call.Arg("approved")
+		a.Unlimited = approved.Value == true
+		a.Revoke = !a.Unlimited
+		return []Approval{a}, nil
+	case permitSelector:
+		a := base
+		a.Standard, a.Owner, a.Spender, a.Amount = ERC2612, arg("owner"), arg("spender"), arg("value")
+		a.Unlimited, a.Revoke = unlimited(a.Amount, 256), isZero(a.Amount)
+		return []Approval{a}, nil
+	case permit2ApproveSelector:
+		a := base
+		a.Standard, a.Token, a.Spender, a.Amount, a.Expiration = Permit2, arg("token"), arg("spender"), arg("amount"), arg("expiration")
+		a.Unlimited, a.Revoke = unlimited(a.Amount, 160), isZero(a.Amount)
+		return []Approval{a}, nil
+	case permit2SingleSelector, permit2BatchSelector:
+		name := "permitSingle"
+		if call.Selector == permit2BatchSelector {
+			name = "permitBatch"
+		}
+		permit, _ := call.Arg(name)
+		fields, _ := permit.Value.(map[string]interface{})
+		details := []interface{}{fields["details"]}
+		if batch, ok := fields["details"].([]interface{}); ok {
+			details = batch
+		}
+		spender, _ := fields["spender"].(string)
+		var approvals []Approval
+		for _, detail := range details {
+			detail, _ := detail.(map[string]interface{})
+			a := base
+			a.Standard, a.Owner, a.Spender = Permit2, arg("owner"), spender
+			a.Token, _ = detail["token"].(string)
+			a.Amount, _ = detail["amount"].(string)
+			a.Expiration, _ = detail["expiration"].(string)
+			a.Unlimited, a.Revoke = unlimited(a.Amount, 160), isZero(a.Amount)
+			approvals = append(approvals, a)
+		}
+		return approvals, nil
+	}
+	return nil, nil
+}
+
+const zeroAddress = "0x0000000000000000000000000000000000000000"
+
+// isNFT reports whether the approved token is known to be an ERC-721 token,
+// either from the options or from blocknative decoding the call with a token id
+func (d *Detector) isNFT(ev *client.Event, token string) bool {
+	if d.nfts[token] {
+		return true
+	}
+	if cc := ev.Payload.Event.ContractCall; cc != nil {
+		for _, name := range []string{"tokenId", "_tokenId"} {
+			if _, ok := cc.Params[name]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unlimited reports whether amount is at least half of the maximum of a uint of the given size,
+// which catches the maximum itself as well as allowances that have been spent from
+func unlimited(amount string, bits uint) bool {
+	v, ok := new(big.Int).SetString(amount, 10)
+	return ok && v.BitLen() >= int(bits)
+}
+
+func isZero(amount string) bool {
+	v, ok := new(big.Int).SetString(amount, 10)
+	return ok && v.Sign() == 0
+}
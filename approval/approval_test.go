@@ -0,0 +1,143 @@
+package approval
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/client/clienttest"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	treasury = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	router   = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+	drainer  = "0x00000000000000000000000000000000000000aa"
+	dai      = "0x6b175474e89094c44da98b954eedeac495271d0f"
+	nft      = "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+)
+
+type permitDetails struct {
+	Token      common.Address
+	Amount     *big.Int
+	Expiration *big.Int
+	Nonce      *big.Int
+}
+
+// call returns a pending event of a transaction from treasury to to calling selector with args
+func call(t *testing.T, to, selector string, args ...interface{}) *client.Event {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	require.NoError(t, err)
+	method, err := parsed.MethodById(hexutil.MustDecode(selector))
+	require.NoError(t, err)
+	data, err := method.Inputs.Pack(args...)
+	require.NoError(t, err)
+	input := hexutil.Encode(append(method.ID, data...))
+	return clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x01", From: treasury, To: to, Input: input})
+}
+
+func TestDecode(t *testing.T) {
+	d, err := New(Opts{NFTs: []string{nft}})
+	require.NoError(t, err)
+	expiration := big.NewInt(1700000000)
+	details := permitDetails{Token: common.HexToAddress(dai), Amount: big.NewInt(5), Expiration: expiration, Nonce: big.NewInt(0)}
+	maxUint160 := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 160), big.NewInt(1))
+	tests := []struct {
+		name string
+		ev   *client.Event
+		want []Approval
+	}{
+		{"approve", call(t, dai, approveSelector, common.HexToAddress(router), math.MaxBig256), []Approval{
+			{Owner: treasury, Token: dai, Standard: ERC20, Method: "approve", Spender: router, Amount: math.MaxBig256.String(), Unlimited: true},
+		}},
+		{"revoke", call(t, dai, approveSelector, common.HexToAddress(router), big.NewInt(0)), []Approval{
+			{Owner: treasury, Token: dai, Standard: ERC20, Method: "approve", Spender: router, Amount: "0", Revoke: true},
+		}},
+		{"increaseAllowance", call(t, dai, increaseAllowanceSelector, common.HexToAddress(drainer), big.NewInt(100)), []Approval{
+			{Owner: treasury, Token: dai, Standard: ERC20, Method: "increaseAllowance", Spender: drainer, Amount: "100"},
+		}},
+		{"erc721 approve", call(t, nft, approveSelector, common.HexToAddress(drainer), big.NewInt(7)), []Approval{
+			{Owner: treasury, Token: nft, Standard: ERC721, Method: "approve", Spender: drainer, TokenID: "7"},
+		}},
+		{"setApprovalForAll", call(t, nft, setApprovalForAllSelector, common.HexToAddress(drainer), true), []Approval{
+			{Owner: treasury, Token: nft, Standard: ERC721Or1155, Method: "setApprovalForAll", Spender: drainer, Unlimited: true},
+		}},
+		{"erc2612 permit", call(t, dai, permitSelector, common.HexToAddress(treasury), common.HexToAddress(drainer), big.NewInt(9),
+			big.NewInt(1), uint8(27), [32]byte{}, [32]byte{}), []Approval{
+			{Owner: treasury, Token: dai, Standard: ERC2612, Method: "permit", Spender: drainer, Amount: "9"},
+		}},
+		{"permit2 approve", call(t, Permit2Address, permit2ApproveSelector, common.HexToAddress(dai), common.HexToAddress(router), maxUint160, expiration), []Approval{
+			{Owner: treasury, Token: dai, Standard: Permit2, Method: "approve", Spender: router, Amount: maxUint160.String(), Expiration: "1700000000", Unlimited: true},
+		}},
+		{"permit2 single", call(t, Permit2Address, permit2SingleSelector, common.HexToAddress(treasury), struct {
+			Details     permitDetails
+			Spender     common.Address
+			SigDeadline *big.Int
+		}{details, common.HexToAddress(drainer), big.NewInt(1)}, []byte{1}), []Approval{
+			{Owner: treasury, Token: dai, Standard: Permit2, Method: "permit", Spender: drainer, Amount: "5", Expiration: "1700000000"},
+		}},
+		{"permit2 batch", call(t, Permit2Address, permit2BatchSelector, common.HexToAddress(treasury), struct {
+			Details     []permitDetails
+			Spender     common.Address
+			SigDeadline *big.Int
+		}{[]permitDetails{details, {Token: common.HexToAddress(nft), Amount: maxUint160, Expiration: expiration, Nonce: big.NewInt(1)}},
+			common.HexToAddress(drainer), big.NewInt(1)}, []byte{1}), []Approval{
+			{Owner: treasury, Token: dai, Standard: Permit2, Method: "permit", Spender: drainer, Amount: "5", Expiration: "1700000000"},
+			{Owner: treasury, Token: nft, Standard: Permit2, Method: "permit", Spender: drainer, Amount: maxUint160.String(), Expiration: "1700000000", Unlimited: true},
+		}},
+		{"permit2 selector on another contract", call(t, drainer, permit2ApproveSelector, common.HexToAddress(dai), common.HexToAddress(drainer), maxUint160, expiration), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			approvals, err := d.Decode(tt.ev)
+			require.NoError(t, err)
+			for i := range tt.want {
+				tt.want[i].Hash = "0x01"
+			}
+			require.Equal(t, tt.want, approvals)
+		})
+	}
+
+	// other calls and plain transfers make no approvals
+	ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x02","input":"0xa9059cbb"}}}`))
+	require.NoError(t, err)
+	approvals, err := d.Decode(ev)
+	require.NoError(t, err)
+	require.Empty(t, approvals)
+	ev, err = client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x02","input":"0x"}}}`))
+	require.NoError(t, err)
+	approvals, err = d.Decode(ev)
+	require.NoError(t, err)
+	require.Empty(t, approvals)
+}
+
+func TestDetect(t *testing.T) {
+	d, err := New(Opts{Owners: []string{treasury}, Allowlist: []string{"0x7a250d5630B4cF539739dF2C5dAcb4c659F2488D"}})
+	require.NoError(t, err)
+	detect := func(ev *client.Event) [][]string {
+		findings, err := d.Detect(ev)
+		require.NoError(t, err)
+		var reasons [][]string
+		for _, f := range findings {
+			reasons = append(reasons, f.Reasons)
+		}
+		return reasons
+	}
+	require.Empty(t, detect(call(t, dai, approveSelector, common.HexToAddress(router), big.NewInt(100))))
+	require.Equal(t, [][]string{{Unlimited}}, detect(call(t, dai, approveSelector, common.HexToAddress(router), math.MaxBig256)))
+	require.Equal(t, [][]string{{NotAllowlisted}}, detect(call(t, dai, approveSelector, common.HexToAddress(drainer), big.NewInt(100))))
+	require.Equal(t, [][]string{{Unlimited, NotAllowlisted}}, detect(call(t, nft, setApprovalForAllSelector, common.HexToAddress(drainer), true)))
+	require.Empty(t, detect(call(t, nft, setApprovalForAllSelector, common.HexToAddress(drainer), false)))
+	// permits signed by other owners are not reported
+	require.Empty(t, detect(call(t, dai, permitSelector, common.HexToAddress(drainer), common.HexToAddress(drainer), big.NewInt(9),
+		big.NewInt(1), uint8(27), [32]byte{}, [32]byte{})))
+
+	_, err = New(Opts{Allowlist: []string{"0x1234"}})
+	require.Error(t, err)
+}
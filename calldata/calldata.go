@@ -0,0 +1,445 @@
+// Package calldata decodes transaction input into the called method and its
+// arguments. Methods come from contract abis, which are trusted, and from a
+// selector database of text signatures such as transfer(address,uint256),
+// which can collide so a signature is only used if it re-encodes the input.
+package calldata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// Common are the signatures every decoder starts with, covering tokens, nfts, permit2 and routers
+var Common = []string{
+	"transfer(address,uint256)",
+	"transferFrom(address,address,uint256)",
+	"approve(address,uint256)",
+	"increaseAllowance(address,uint256)",
+	"decreaseAllowance(address,uint256)",
+	"permit(address,address,uint256,uint256,uint8,bytes32,bytes32)",
+	"setApprovalForAll(address,bool)",
+	"approve(address,address,uint160,uint48)",
+	"permit(address,((address,uint160,uint48,uint48),address,uint256),bytes)",
+	"permit(address,((address,uint160,uint48,uint48)[],address,uint256),bytes)",
+	"safeTransferFrom(address,address,uint256)",
+	"safeTransferFrom(address,address,uint256,bytes)",
+	"safeTransferFrom(address,address,uint256,uint256,bytes)",
+	"safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)",
+	"deposit()",
+	"withdraw(uint256)",
+	"multicall(bytes[])",
+	"multicall(uint256,bytes[])",
+	"swapExactETHForTokens(uint256,address[],address,uint256)",
+	"swapETHForExactTokens(uint256,address[],address,uint256)",
+	"swapExactTokensForETH(uint256,uint256,address[],address,uint256)",
+	"swapTokensForExactETH(uint256,uint256,address[],address,uint256)",
+	"swapExactTokensForTokens(uint256,uint256,address[],address,uint256)",
+	"swapTokensForExactTokens(uint256,uint256,address[],address,uint256)",
+	"addLiquidity(address,address,uint256,uint256,uint256,uint256,address,uint256)",
+	"addLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidity(address,address,uint256,uint256,uint256,address,uint256)",
+	"removeLiquidityETH(address,uint256,uint256,uint256,address,uint256)",
+}
+
+// Arg is a decoded argument
+type Arg struct {
+	// Name is empty for arguments of text signatures
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Value holds strings for integers, addresses and bytes, bools, and
+	// slices and maps of those for arrays and tuples, so it encodes to json as is
+	Value interface{} `json:"value"`
+}
+
+// Call is a decoded method call
+type Call struct {
+	Selector  string `json:"selector"`
+	Method    string `json:"method"`
+	Signature string `json:"signature"`
+	Args      []Arg  `json:"args"`
+}
+
+// Arg returns the argument with the given name, or at the given position if name is a number
+func (c *Call) Arg(name string) (Arg, bool) {
+	for i, arg := range c.Args {
+		if arg.Name == name || (arg.Name == "" && fmt.Sprint(i) == name) {
+			return arg, true
+		}
+	}
+	return Arg{}, false
+}
+
+// Decoder decodes input using the methods it was given, it is safe for concurrent use
+type Decoder struct {
+	mx sync.RWMutex
+	// methods from abis, which take precedence over signatures
+	methods map[[4]byte]abi.Method
+	// candidates from text signatures, by selector
+	signatures map[[4]byte][]abi.Method
+}
+
+// New returns a decoder knowing the Common signatures
+func New() *Decoder {
+	d := &Decoder{
+		methods:    make(map[[4]byte]abi.Method),
+		signatures: make(map[[4]byte][]abi.Method),
+	}
+	for _, sig := range Common {
+		if err := d.AddSignature(sig); err != nil {
+			panic(err)
+		}
+	}
+	return d
+}
+
+// AddABI adds the methods of a json abi, either an array of entries or an
+// object with an abi field as written by hardhat and truffle
+func (d *Decoder) AddABI(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "reading abi")
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var artifact struct {
+			ABI json.RawMessage `json:"abi"`
+		}
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return errors.Wrap(err, "decoding abi")
+		}
+		if len(artifact.ABI) == 0 {
+			return errors.New("decoding abi:no abi field")
+		}
+		data = artifact.ABI
+	}
+	parsed, err := abi.JSON(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "decoding abi")
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for _, method := range parsed.Methods {
+		d.methods[selector(method.ID)] = method
+	}
+	return nil
+}
+
+// AddABIFile adds the methods of the json abi at path
+func (d *Decoder) AddABIFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return errors.Wrapf(d.AddABI(f), "abi %v", path)
+}
+
+// AddSignature adds a text signature such as transfer(address,uint256)
+func (d *Decoder) AddSignature(sig string) error {
+	method, err := ParseSignature(sig)
+	if err != nil {
+		return err
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	id := selector(method.ID)
+	for _, known := range d.signatures[id] {
+		if known.Sig == method.Sig {
+			return nil
+		}
+	}
+	d.signatures[id] = append(d.signatures[id], method)
+	return nil
+}
+
+// LoadSelectors adds the signatures of a selector database, a text file of
+// one signature per line optionally preceded by its selector, with blank
+// lines and lines starting with # ignored
+func (d *Decoder) LoadSelectors(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var want string
+		if i := strings.IndexAny(line, " \t"); i > 0 && strings.HasPrefix(line, "0x") {
+			want, line = strings.ToLower(line[:i]), line[i:]
+		}
+		method, err := ParseSignature(line)
+		if err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+		if got := hexutil.Encode(method.ID); want != "" && want != got {
+			return errors.Errorf("line %d:selector of %v is %v not %v", n, method.Sig, got, want)
+		}
+		if err := d.AddSignature(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// LoadSelectorsFile adds the signatures of the selector database at path
+func (d *Decoder) LoadSelectorsFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return errors.Wrapf(d.LoadSelectors(f), "selectors %v", path)
+}
+
+// Decode decodes input, the selector followed by the abi encoded arguments
+func (d *Decoder) Decode(input []byte) (*Call, error) {
+	if len(input) < 4 {
+		return nil, errors.Errorf("input too short:%d bytes", len(input))
+	}
+	var id [4]byte
+	copy(id[:], input)
+	d.mx.RLock()
+	method, ok := d.methods[id]
+	candidates := d.signatures[id]
+	d.mx.RUnlock()
+	if ok {
+		values, err := method.Inputs.Unpack(input[4:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "unpacking %v", method.Sig)
+		}
+		return newCall(method, values), nil
+	}
+	for _, method := range candidates {
+		// colliding signatures are told apart by re-encoding
+		values, err := method.Inputs.Unpack(input[4:])
+		if err != nil {
+			continue
+		}
+		packed, err := method.Inputs.Pack(values...)
+		if err != nil || !bytes.Equal(packed, input[4:]) {
+			continue
+		}
+		return newCall(method, values), nil
+	}
+	if len(candidates) > 0 {
+		return nil, errors.Errorf("input does not match any signature of selector:%v", hexutil.Encode(id[:]))
+	}
+	return nil, errors.Errorf("unknown selector:%v", hexutil.Encode(id[:]))
+}
+
+// DecodeHex decodes 0x prefixed hex input
+func (d *Decoder) DecodeHex(input string) (*Call, error) {
+	data, err := hexutil.Decode(strings.TrimSpace(input))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding input")
+	}
+	return d.Decode(data)
+}
+
+// Annotate returns ev with a contract call decoded from its input, in the
+// shape blocknative uses, when blocknative did not decode one. ev is returned
+// as is when it already has a contract call or carries no input.
+func (d *Decoder) Annotate(ev *client.Event) (*client.Event, error) {
+	tx := ev.Payload.Event.Transaction
+	if ev.Payload.Event.ContractCall != nil || len(tx.Input) <= 2 {
+		return ev, nil
+	}
+	call, err := d.DecodeHex(tx.Input)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]interface{}, len(call.Args))
+	for i, arg := range call.Args {
+		name := arg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		params[name] = arg.Value
+	}
+	var payload, event map[string]json.RawMessage
+	if err := json.Unmarshal(ev.Raw, &payload); err != nil {
+		return nil, errors.Wrap(err, "decoding event")
+	}
+	if err := json.Unmarshal(payload["event"], &event); err != nil {
+		return nil, errors.Wrap(err, "decoding event")
+	}
+	if event["contractCall"], err = json.Marshal(client.ContractCall{
+		ContractAddress: strings.ToLower(tx.To),
+		MethodName:      call.Method,
+		Params:          params,
+	}); err != nil {
+		return nil, err
+	}
+	if payload["event"], err = json.Marshal(event); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewEvent(ev.ReceivedAt, raw)
+}
+
+func newCall(method abi.Method, values []interface{}) *Call {
+	call := &Call{
+		Selector:  hexutil.Encode(method.ID),
+		Method:    method.RawName,
+		Signature: method.Sig,
+		Args:      make([]Arg, len(method.Inputs)),
+	}
+	for i, input := range method.Inputs {
+		call.Args[i] = Arg{Name: input.Name, Type: input.Type.String(), Value: normalize(values[i])}
+	}
+	return call
+}
+
+// ParseSignature parses a text signature such as transfer(address,uint256)
+// into a method with unnamed inputs, tuples are written as (type,...)
+func ParseSignature(sig string) (abi.Method, error) {
+	sig = strings.Join(strings.Fields(sig), "")
+	open := strings.Index(sig, "(")
+	if open <= 0 || !strings.HasSuffix(sig, ")") {
+		return abi.Method{}, errors.Errorf("invalid signature:%v", sig)
+	}
+	name := sig[:open]
+	types, err := splitTypes(sig[open+1 : len(sig)-1])
+	if err != nil {
+		return abi.Method{}, errors.Wrapf(err, "invalid signature %v", sig)
+	}
+	inputs := make(abi.Arguments, len(types))
+	for i, t := range types {
+		marshaling, err := argument(t)
+		if err != nil {
+			return abi.Method{}, errors.Wrapf(err, "invalid signature %v", sig)
+		}
+		typ, err := abi.NewType(marshaling.Type, "", marshaling.Components)
+		if err != nil {
+			return abi.Method{}, errors.Wrapf(err, "invalid signature %v", sig)
+		}
+		inputs[i] = abi.Argument{Type: typ}
+	}
+	return abi.NewMethod(name, name, abi.Function, "", false, false, inputs, nil), nil
+}
+
+// argument describes a type of a text signature, naming tuple components by position
+func argument(t string) (abi.ArgumentMarshaling, error) {
+	if !strings.HasPrefix(t, "(") {
+		return abi.ArgumentMarshaling{Type: t}, nil
+	}
+	end := strings.LastIndex(t, ")")
+	types, err := splitTypes(t[1:end])
+	if err != nil {
+		return abi.ArgumentMarshaling{}, err
+	}
+	out := abi.ArgumentMarshaling{Type: "tuple" + t[end+1:]}
+	for i, component := range types {
+		c, err := argument(component)
+		if err != nil {
+			return abi.ArgumentMarshaling{}, err
+		}
+		c.Name = fmt.Sprintf("field%d", i)
+		out.Components = append(out.Components, c)
+	}
+	return out, nil
+}
+
+// splitTypes splits a list of types on the commas outside of tuples
+func splitTypes(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var (
+		out   []string
+		depth int
+		start int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	out = append(out, s[start:])
+	for _, t := range out {
+		if t == "" {
+			return nil, errors.New("empty type")
+		}
+	}
+	return out, nil
+}
+
+// normalize converts unpacked values to strings, bools, slices and maps
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *big.Int:
+		return v.String()
+	case common.Address:
+		return strings.ToLower(v.Hex())
+	case []byte:
+		return hexutil.Encode(v)
+	case string, bool:
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(data), rv)
+			return hexutil.Encode(data)
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Struct:
+		out := make(map[string]interface{}, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			name := field.Tag.Get("json")
+			if name == "" {
+				name = field.Name
+			}
+			out[name] = normalize(rv.Field(i).Interface())
+		}
+		return out
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+func selector(id []byte) [4]byte {
+	var out [4]byte
+	copy(out[:], id)
+	return out
+}
@@ -0,0 +1,167 @@
+package calldata
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sender = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	router = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+)
+
+const tellorABI = `[{
+	"inputs": [
+		{"internalType": "string", "name": "_nonce", "type": "string"},
+		{"internalType": "uint256[5]", "name": "_requestId", "type": "uint256[5]"},
+		{"internalType": "uint256[5]", "name": "_value", "type": "uint256[5]"},
+		{"internalType": "uint256", "name": "_pass", "type": "uint256"}
+	],
+	"name": "submitMiningSolution",
+	"outputs": [],
+	"stateMutability": "nonpayable",
+	"type": "function"
+}]`
+
+// pack encodes a call of sig with args
+func pack(t *testing.T, sig string, args ...interface{}) []byte {
+	method, err := ParseSignature(sig)
+	require.NoError(t, err)
+	data, err := method.Inputs.Pack(args...)
+	require.NoError(t, err)
+	return append(method.ID, data...)
+}
+
+func TestDecodeSignature(t *testing.T) {
+	d := New()
+	amount := new(big.Int).Lsh(big.NewInt(1), 255)
+	input := pack(t, "approve(address,uint256)", common.HexToAddress(router), amount)
+	call, err := d.DecodeHex(hexutil.Encode(input))
+	require.NoError(t, err)
+	require.Equal(t, &Call{
+		Selector:  "0x095ea7b3",
+		Method:    "approve",
+		Signature: "approve(address,uint256)",
+		Args: []Arg{
+			{Type: "address", Value: router},
+			{Type: "uint256", Value: amount.String()},
+		},
+	}, call)
+	arg, ok := call.Arg("0")
+	require.True(t, ok)
+	require.Equal(t, router, arg.Value)
+
+	_, err = d.Decode([]byte{1, 2})
+	require.Error(t, err)
+	_, err = d.Decode([]byte{1, 2, 3, 4})
+	require.EqualError(t, err, "unknown selector:0x01020304")
+	// a known selector with arguments of other types is not decoded
+	_, err = d.Decode(append(input[:4:4], make([]byte, 31)...))
+	require.Error(t, err)
+}
+
+func TestDecodeABI(t *testing.T) {
+	d := New()
+	require.NoError(t, d.AddABI(strings.NewReader(tellorABI)))
+	values := [5]*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5)}
+	input := pack(t, "submitMiningSolution(string,uint256[5],uint256[5],uint256)", "nonce", values, values, big.NewInt(7))
+	call, err := d.Decode(input)
+	require.NoError(t, err)
+	require.Equal(t, "submitMiningSolution", call.Method)
+	require.Equal(t, []Arg{
+		{Name: "_nonce", Type: "string", Value: "nonce"},
+		{Name: "_requestId", Type: "uint256[5]", Value: []interface{}{"1", "2", "3", "4", "5"}},
+		{Name: "_value", Type: "uint256[5]", Value: []interface{}{"1", "2", "3", "4", "5"}},
+		{Name: "_pass", Type: "uint256", Value: "7"},
+	}, call.Args)
+	arg, ok := call.Arg("_pass")
+	require.True(t, ok)
+	require.Equal(t, "7", arg.Value)
+
+	// hardhat artifacts keep the abi in a field
+	d = New()
+	require.NoError(t, d.AddABI(strings.NewReader(`{"contractName":"Tellor","abi":`+tellorABI+`}`)))
+	_, err = d.Decode(input)
+	require.NoError(t, err)
+	require.Error(t, d.AddABI(strings.NewReader(`{"contractName":"Tellor"}`)))
+}
+
+func TestSelectors(t *testing.T) {
+	d := New()
+	require.NoError(t, d.LoadSelectors(strings.NewReader(`
+# uniswap v3
+0x414bf389 exactInputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))
+0x3593564c execute(bytes, bytes[], uint256)
+`)))
+	type params struct {
+		Field0 common.Address
+		Field1 common.Address
+		Field2 *big.Int
+		Field3 common.Address
+		Field4 *big.Int
+		Field5 *big.Int
+		Field6 *big.Int
+		Field7 *big.Int
+	}
+	input := pack(t, "exactInputSingle((address,address,uint24,address,uint256,uint256,uint256,uint160))", params{
+		Field0: common.HexToAddress(sender), Field1: common.HexToAddress(router), Field2: big.NewInt(3000),
+		Field3: common.HexToAddress(sender), Field4: big.NewInt(1), Field5: big.NewInt(2), Field6: big.NewInt(3), Field7: big.NewInt(0),
+	})
+	call, err := d.Decode(input)
+	require.NoError(t, err)
+	require.Equal(t, "0x414bf389", call.Selector)
+	require.Equal(t, []Arg{{
+		Type: "(address,address,uint24,address,uint256,uint256,uint256,uint160)",
+		Value: map[string]interface{}{
+			"field0": sender, "field1": router, "field2": "3000", "field3": sender,
+			"field4": "1", "field5": "2", "field6": "3", "field7": "0",
+		},
+	}}, call.Args)
+
+	call, err = d.Decode(pack(t, "execute(bytes,bytes[],uint256)", []byte{0xab}, [][]byte{{1}, {2, 3}}, big.NewInt(9)))
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"0xab", []interface{}{"0x01", "0x0203"}, "9"}, []interface{}{call.Args[0].Value, call.Args[1].Value, call.Args[2].Value})
+
+	require.Error(t, d.LoadSelectors(strings.NewReader("0x12345678 transfer(address,uint256)")))
+	require.Error(t, d.LoadSelectors(strings.NewReader("transfer(address,uint256")))
+	require.Error(t, d.LoadSelectors(strings.NewReader("transfer(address,,uint256)")))
+	require.Error(t, d.LoadSelectors(strings.NewReader("transfer(address,uint)")))
+}
+
+func TestAnnotate(t *testing.T) {
+	d := New()
+	input := hexutil.Encode(pack(t, "transfer(address,uint256)", common.HexToAddress(sender), big.NewInt(5)))
+	ev, err := client.NewEvent(time.Now(), []byte(`{"status":"ok","event":{"eventCode":"txPool","transaction":{"hash":"0x01","to":"`+router+`","input":"`+input+`"}}}`))
+	require.NoError(t, err)
+	annotated, err := d.Annotate(ev)
+	require.NoError(t, err)
+	require.Equal(t, &client.ContractCall{
+		ContractAddress: router,
+		MethodName:      "transfer",
+		Params:          map[string]interface{}{"0": sender, "1": "5"},
+	}, annotated.Payload.Event.ContractCall)
+	require.Equal(t, "txPool", annotated.Payload.Event.EventCode)
+	require.Equal(t, input, annotated.Payload.Event.Transaction.Input)
+	require.Contains(t, string(annotated.Raw), `"methodName":"transfer"`)
+
+	// decoded calls are kept and transfers without input are left alone
+	same, err := d.Annotate(annotated)
+	require.NoError(t, err)
+	require.True(t, same == annotated)
+	ev, err = client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x02","input":"0x"}}}`))
+	require.NoError(t, err)
+	same, err = d.Annotate(ev)
+	require.NoError(t, err)
+	require.True(t, same == ev)
+	ev, err = client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x03","input":"0x01020304"}}}`))
+	require.NoError(t, err)
+	_, err = d.Annotate(ev)
+	require.Error(t, err)
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Policy determines what a subscriber buffer does when it is full
+type Policy int
+
+const (
+	// Block makes the publisher wait until the subscriber has room, applying back-pressure to the connection
+	Block Policy = iota
+	// DropOldest discards the oldest buffered event to make room for the new one
+	DropOldest
+	// DropNewest discards the incoming event
+	DropNewest
+	// Spill writes events that do not fit in memory to a file on disk
+	Spill
+)
+
+// DefaultBufferSize is the number of events held in memory when BufferOpts.Size is unset
+const DefaultBufferSize = 1024
+
+// ErrClosed is returned when reading from or writing to a closed buffer
+var ErrClosed = errors.New("buffer closed")
+
+// String returns the policy name
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Spill:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePolicy returns the policy matching name as returned by Policy::String
+func ParsePolicy(name string) (Policy, error) {
+	for _, p := range []Policy{Block, DropOldest, DropNewest, Spill} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, errors.Errorf("unknown buffer policy:%v", name)
+}
+
+// BufferOpts configures a subscriber buffer
+type BufferOpts struct {
+	// maximum number of events held in memory, defaults to DefaultBufferSize
+	Size int
+	// what to do once Size events are buffered
+	Policy Policy
+	// directory spill files are created in when using the Spill policy, defaults to os.TempDir
+	SpillDir string
+}
+
+// BufferStats reports counters for a subscriber buffer
+type BufferStats struct {
+	Received  uint64 // events pushed into the buffer
+	Delivered uint64 // events returned by Next
+	Dropped   uint64 // events discarded by DropOldest or DropNewest
+	Spilled   uint64 // events written to disk by Spill
+	Pending   int    // events currently waiting to be read, including those on disk
+}
+
+// EventBuffer is a bounded queue of events for a single subscriber
+type EventBuffer struct {
+	opts  BufferOpts
+	mx    sync.Mutex
+	queue []*Event
+	stats BufferStats
+
+	// signalled when an event is pushed or space is freed
+	readable chan struct{}
+	writable chan struct{}
+	done     chan struct{}
+	closed   bool
+
+	// spill file state, only used with the Spill policy
+	spillW       *os.File
+	spillFile    *os.File
+	spillR       *bufio.Reader
+	spillPending int
+}
+
+// NewEventBuffer returns a bounded event buffer using the given options
+func NewEventBuffer(opts BufferOpts) (*EventBuffer, error) {
+	if opts.Size <= 0 {
+		opts.Size = DefaultBufferSize
+	}
+	buf := &EventBuffer{
+		opts:     opts,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if opts.Policy == Spill {
+		fh, err := ioutil.TempFile(opts.SpillDir, "blocknative-spill-*.ndjson")
+		if err != nil {
+			return nil, errors.Wrap(err, "creating spill file")
+		}
+		rd, err := os.Open(fh.Name())
+		if err != nil {
+			fh.Close()
+			os.Remove(fh.Name())
+			return nil, errors.Wrap(err, "opening spill file")
+		}
+		buf.spillW = fh
+		buf.spillFile = rd
+		buf.spillR = bufio.NewReader(rd)
+	}
+	return buf, nil
+}
+
+// Push adds an event to the buffer according to its policy. With the Block
+// policy it waits until there is room or ctx is done.
+func (buf *EventBuffer) Push(ctx context.Context, ev *Event) error {
+	for {
+		buf.mx.Lock()
+		if buf.closed {
+			buf.mx.Unlock()
+			return ErrClosed
+		}
+		full := len(buf.queue) >= buf.opts.Size
+		if buf.opts.Policy == Spill && (full || buf.spillPending > 0) {
+			// once anything is on disk everything goes to disk so ordering is preserved
+			err := buf.spill(ev)
+			buf.mx.Unlock()
+			if err == nil {
+				notify(buf.readable)
+			}
+			return err
+		}
+		if !full {
+			buf.queue = append(buf.queue, ev)
+			buf.stats.Received++
+			buf.mx.Unlock()
+			notify(buf.readable)
+			return nil
+		}
+		switch buf.opts.Policy {
+		case DropOldest:
+			buf.queue[0] = nil
+			buf.queue = append(buf.queue[1:], ev)
+			buf.stats.Received++
+			buf.stats.Dropped++
+			buf.mx.Unlock()
+			return nil
+		case DropNewest:
+			buf.stats.Received++
+			buf.stats.Dropped++
+			buf.mx.Unlock()
+			return nil
+		}
+		buf.mx.Unlock()
+		select {
+		case <-buf.writable:
+		case <-buf.done:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Next returns the oldest buffered event, waiting until one is available, the buffer is closed or ctx is done
+func (buf *EventBuffer) Next(ctx context.Context) (*Event, error) {
+	for {
+		buf.mx.Lock()
+		if len(buf.queue) == 0 && buf.spillPending > 0 {
+			if err := buf.unspill(); err != nil {
+				buf.mx.Unlock()
+				return nil, err
+			}
+		}
+		if len(buf.queue) > 0 {
+			ev := buf.queue[0]
+			buf.queue[0] = nil
+			buf.queue = buf.queue[1:]
+			buf.stats.Delivered++
+			buf.mx.Unlock()
+			notify(buf.writable)
+			return ev, nil
+		}
+		if buf.closed {
+			// everything has been drained so the spill file is no longer needed
+			buf.removeSpill()
+			buf.mx.Unlock()
+			return nil, ErrClosed
+		}
+		buf.mx.Unlock()
+		select {
+		case <-buf.readable:
+		case <-buf.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Stats returns a snapshot of the buffer counters
+func (buf *EventBuffer) Stats() BufferStats {
+	buf.mx.Lock()
+	defer buf.mx.Unlock()
+	stats := buf.stats
+	stats.Pending = len(buf.queue) + buf.spillPending
+	return stats
+}
+
+// Close stops the buffer from accepting events. Events still buffered,
+// including those spilled to disk, can be drained with Next until it returns
+// ErrClosed, at which point the spill file is removed.
+func (buf *EventBuffer) Close() error {
+	buf.mx.Lock()
+	defer buf.mx.Unlock()
+	if buf.closed {
+		return nil
+	}
+	buf.closed = true
+	close(buf.done)
+	if buf.spillPending > 0 {
+		return nil
+	}
+	return buf.removeSpill()
+}
+
+// removeSpill closes and deletes the spill file, must be called with the lock held
+func (buf *EventBuffer) removeSpill() error {
+	if buf.spillW == nil {
+		return nil
+	}
+	name := buf.spillW.Name()
+	err := buf.spillW.Close()
+	buf.spillFile.Close()
+	buf.spillW, buf.spillFile, buf.spillR = nil, nil, nil
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// spill appends ev to the spill file, must be called with the lock held
+func (buf *EventBuffer) spill(ev *Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrap(err, "encoding spilled event")
+	}
+	if _, err := buf.spillW.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "writing spill file")
+	}
+	buf.spillPending++
+	buf.stats.Received++
+	buf.stats.Spilled++
+	return nil
+}
+
+// unspill moves up to Size events from disk back into memory, must be called with the lock held
+func (buf *EventBuffer) unspill() error {
+	for buf.spillPending > 0 && len(buf.queue) < buf.opts.Size {
+		line, err := buf.spillR.ReadBytes('\n')
+		if err != nil {
+			return errors.Wrap(err, "reading spill file")
+		}
+		var ev Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return errors.Wrap(err, "decoding spilled event")
+		}
+		buf.queue = append(buf.queue, &ev)
+		buf.spillPending--
+	}
+	if buf.spillPending > 0 {
+		return nil
+	}
+	// the file has been fully consumed so reclaim the space
+	if err := buf.spillW.Truncate(0); err != nil {
+		return errors.Wrap(err, "truncating spill file")
+	}
+	if _, err := buf.spillW.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding spill file")
+	}
+	if _, err := buf.spillFile.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding spill file")
+	}
+	buf.spillR.Reset(buf.spillFile)
+	return nil
+}
+
+// notify performs a non-blocking send on a signal channel
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(t *testing.T, hash string) *Event {
+	ev, err := NewEvent(time.Now(), []byte(fmt.Sprintf(`{"event":{"transaction":{"hash":%q}}}`, hash)))
+	require.NoError(t, err)
+	return ev
+}
+
+func TestEventBuffer(t *testing.T) {
+	ctx := context.Background()
+	drain := func(buf *EventBuffer, count int) []string {
+		var hashes []string
+		for i := 0; i < count; i++ {
+			ev, err := buf.Next(ctx)
+			require.NoError(t, err)
+			hashes = append(hashes, ev.Payload.Event.Transaction.Hash)
+		}
+		return hashes
+	}
+	t.Run("drop-oldest", func(t *testing.T) {
+		buf, err := NewEventBuffer(BufferOpts{Size: 2, Policy: DropOldest})
+		require.NoError(t, err)
+		defer buf.Close()
+		for _, hash := range []string{"0x1", "0x2", "0x3"} {
+			require.NoError(t, buf.Push(ctx, testEvent(t, hash)))
+		}
+		require.Equal(t, uint64(1), buf.Stats().Dropped)
+		require.Equal(t, []string{"0x2", "0x3"}, drain(buf, 2))
+	})
+	t.Run("drop-newest", func(t *testing.T) {
+		buf, err := NewEventBuffer(BufferOpts{Size: 2, Policy: DropNewest})
+		require.NoError(t, err)
+		defer buf.Close()
+		for _, hash := range []string{"0x1", "0x2", "0x3"} {
+			require.NoError(t, buf.Push(ctx, testEvent(t, hash)))
+		}
+		require.Equal(t, uint64(1), buf.Stats().Dropped)
+		require.Equal(t, []string{"0x1", "0x2"}, drain(buf, 2))
+	})
+	t.Run("block", func(t *testing.T) {
+		buf, err := NewEventBuffer(BufferOpts{Size: 1, Policy: Block})
+		require.NoError(t, err)
+		defer buf.Close()
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x1")))
+		// a full buffer blocks until the context expires
+		tctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+		defer cancel()
+		require.Equal(t, context.DeadlineExceeded, buf.Push(tctx, testEvent(t, "0x2")))
+		// and unblocks once the consumer catches up
+		done := make(chan error)
+		go func() { done <- buf.Push(ctx, testEvent(t, "0x3")) }()
+		require.Equal(t, []string{"0x1"}, drain(buf, 1))
+		require.NoError(t, <-done)
+		require.Equal(t, []string{"0x3"}, drain(buf, 1))
+		require.Equal(t, uint64(0), buf.Stats().Dropped)
+	})
+	t.Run("spill", func(t *testing.T) {
+		buf, err := NewEventBuffer(BufferOpts{Size: 2, Policy: Spill, SpillDir: t.TempDir()})
+		require.NoError(t, err)
+		defer buf.Close()
+		for _, hash := range []string{"0x1", "0x2", "0x3", "0x4", "0x5"} {
+			require.NoError(t, buf.Push(ctx, testEvent(t, hash)))
+		}
+		stats := buf.Stats()
+		require.Equal(t, uint64(3), stats.Spilled)
+		require.Equal(t, 5, stats.Pending)
+		require.Equal(t, []string{"0x1", "0x2", "0x3"}, drain(buf, 3))
+		// pushes while events remain on disk keep their order
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x6")))
+		require.Equal(t, []string{"0x4", "0x5", "0x6"}, drain(buf, 3))
+		// the spill file is reused once drained
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x7")))
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x8")))
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x9")))
+		require.Equal(t, []string{"0x7", "0x8", "0x9"}, drain(buf, 3))
+		require.Equal(t, 0, buf.Stats().Pending)
+	})
+	t.Run("close", func(t *testing.T) {
+		buf, err := NewEventBuffer(BufferOpts{})
+		require.NoError(t, err)
+		require.NoError(t, buf.Push(ctx, testEvent(t, "0x1")))
+		require.NoError(t, buf.Close())
+		require.Equal(t, ErrClosed, buf.Push(ctx, testEvent(t, "0x2")))
+		require.Equal(t, []string{"0x1"}, drain(buf, 1))
+		_, err = buf.Next(ctx)
+		require.Equal(t, ErrClosed, err)
+	})
+	t.Run("close spilled", func(t *testing.T) {
+		dir := t.TempDir()
+		buf, err := NewEventBuffer(BufferOpts{Size: 1, Policy: Spill, SpillDir: dir})
+		require.NoError(t, err)
+		for _, hash := range []string{"0x1", "0x2", "0x3"} {
+			require.NoError(t, buf.Push(ctx, testEvent(t, hash)))
+		}
+		// spilled events are still delivered after closing, then the spill file is removed
+		require.NoError(t, buf.Close())
+		require.Equal(t, 3, buf.Stats().Pending)
+		require.Equal(t, []string{"0x1", "0x2", "0x3"}, drain(buf, 3))
+		_, err = buf.Next(ctx)
+		require.Equal(t, ErrClosed, err)
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+}
+
+type sliceSource struct {
+	frames []string
+}
+
+func (s *sliceSource) ReadJSON(out interface{}) error {
+	if len(s.frames) == 0 {
+		return io.EOF
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return json.Unmarshal([]byte(frame), out)
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	d := NewDispatcher()
+	fast, err := d.Subscribe(BufferOpts{Size: 10})
+	require.NoError(t, err)
+	slow, err := d.Subscribe(BufferOpts{Size: 1, Policy: DropNewest})
+	require.NoError(t, err)
+	src := &sliceSource{frames: []string{
+		`{"event":{"transaction":{"hash":"0x1"}}}`,
+		`{"event":{"transaction":{"hash":"0x2"}}}`,
+		`{"event":{"transaction":{"hash":"0x3"}}}`,
+	}}
+	require.Equal(t, io.EOF, d.Run(ctx, src))
+	require.Equal(t, 3, fast.Stats().Pending)
+	require.Equal(t, uint64(2), slow.Stats().Dropped)
+	ev, err := slow.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "0x1", ev.Payload.Event.Transaction.Hash)
+	require.NoError(t, d.Unsubscribe(slow))
+	require.Len(t, d.Stats(), 1)
+}
@@ -2,9 +2,11 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/ethereum/go-ethereum/params"
@@ -19,6 +21,11 @@ type Opts struct {
 	Path                 string
 	APIKey               string
 	PrintConnectResponse bool
+	// if set every inbound and outbound frame is written to the recorder
+	Recorder *Recorder
+	// if set every message sent with WriteJSON or EventSub is pushed to the
+	// history and replayed by Reconnect
+	History *MsgHistory
 }
 
 // ConnectResponse is the message we receive when opening a connection to the API
@@ -38,36 +45,93 @@ type Client struct {
 	cancel  context.CancelFunc
 	initMsg BaseMessage // used to resend the initialization msg if connection drops
 	apiKey  string
-	mtx     sync.RWMutex
+	rec     *Recorder
+	opts    Opts
+	// gorilla websockets supports one concurrent reader and one concurrent writer
+	// so reads and writes are guarded separately, allowing writes while a read blocks
+	mtx  sync.Mutex
+	rmtx sync.Mutex
 }
 
 // New returns a new blocknative websocket client
 func New(ctx context.Context, opts Opts) (*Client, error) {
 	ctx, cancel := context.WithCancel(ctx)
+	c := &Client{ctx: ctx, cancel: cancel, apiKey: opts.APIKey, rec: opts.Recorder, opts: opts}
+	if err := c.dial(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial opens the websocket connection, the locks must be held once the client is in use
+func (c *Client) dial() error {
 	u := url.URL{
-		Scheme: opts.Scheme,
-		Host:   opts.Host,
-		Path:   opts.Path,
+		Scheme: c.opts.Scheme,
+		Host:   c.opts.Host,
+		Path:   c.opts.Path,
 	}
-	c, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, u.String(), nil)
 	if err != nil {
-		cancel()
-		return nil, err
+		return err
 	}
+	c.conn = conn
 	// this checks out connection to blocknative's api and makes sure that we connected properly
 	var out ConnectResponse
-	if err := c.ReadJSON(&out); err != nil {
-		cancel()
-		return nil, err
+	if err := c.readJSON(&out); err != nil {
+		conn.Close()
+		return err
 	}
 	if out.Status != "ok" {
-		cancel()
-		return nil, errors.Errorf("failed to initialize websockets connection reason:", out.Reason)
+		conn.Close()
+		return errors.Errorf("failed to initialize websockets connection reason:%v", out.Reason)
 	}
-	if opts.PrintConnectResponse {
+	if c.opts.PrintConnectResponse {
 		log.Printf("%+v\n", out)
 	}
-	return &Client{conn: c, ctx: ctx, cancel: cancel, apiKey: opts.APIKey}, nil
+	return nil
+}
+
+// Reconnect replaces a dropped connection, initializing the new one if the
+// client was initialized and replaying the message history so subscriptions
+// are re-established. Acknowledgements of replayed messages are left to be read.
+func (c *Client) Reconnect() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
+	c.conn.Close()
+	if err := c.dial(); err != nil {
+		return errors.Wrap(err, "reconnecting")
+	}
+	if c.initMsg.EventCode != "" {
+		msg := c.initMsg
+		if err := c.writeJSON(&msg); err != nil {
+			return err
+		}
+		var out ConnectResponse
+		if err := c.readJSON(&out); err != nil {
+			return err
+		}
+		if out.Status != "ok" {
+			return errors.Errorf("failed to initialize api connection reason:%v", out.Reason)
+		}
+	}
+	if c.opts.History == nil {
+		return nil
+	}
+	msgs := c.opts.History.PopAll()
+	for i, msg := range msgs {
+		if err := c.writeJSON(msg); err != nil {
+			// keep what was not replayed so a later reconnect can
+			for _, msg := range msgs[i:] {
+				c.opts.History.Push(msg)
+			}
+			return err
+		}
+		c.opts.History.Push(msg)
+	}
+	return nil
 }
 
 // Initialize is used to handle blocknative websockets api initialization
@@ -75,15 +139,17 @@ func New(ctx context.Context, opts Opts) (*Client, error) {
 func (c *Client) Initialize(msg BaseMessage) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
 	msg.Version = "1"
 	msg.CategoryCode = "initialize"
 	msg.EventCode = "checkDappId"
 	c.initMsg = msg
-	if err := c.conn.WriteJSON(&msg); err != nil {
+	if err := c.writeJSON(&msg); err != nil {
 		return err
 	}
 	var out ConnectResponse
-	err := c.conn.ReadJSON(&out)
+	err := c.readJSON(&out)
 	if err != nil {
 		return err
 	}
@@ -97,12 +163,17 @@ func (c *Client) Initialize(msg BaseMessage) error {
 func (c *Client) EventSub(msg Configuration) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	if err := c.conn.WriteJSON(&msg); err != nil {
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
+	if err := c.writeJSON(&msg); err != nil {
 		return err
 	}
+	if c.opts.History != nil {
+		c.opts.History.Push(&msg)
+	}
 
 	var out ConnectResponse
-	err := c.conn.ReadJSON(&out)
+	err := c.readJSON(&out)
 	if err != nil {
 		return err
 	}
@@ -115,16 +186,50 @@ func (c *Client) EventSub(msg Configuration) error {
 
 // ReadJSON is a wrapper around Conn:ReadJSON
 func (c *Client) ReadJSON(out interface{}) error {
-	c.mtx.RLock()
-	defer c.mtx.RUnlock()
-	return c.conn.ReadJSON(out)
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
+	return c.readJSON(out)
 }
 
 // WriteJSON is a wrapper around Conn:WriteJSON
 func (c *Client) WriteJSON(out interface{}) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	return c.conn.WriteJSON(out)
+	if err := c.writeJSON(out); err != nil {
+		return err
+	}
+	if c.opts.History != nil {
+		c.opts.History.Push(out)
+	}
+	return nil
+}
+
+// readJSON reads the next frame, recording it before decoding into out
+func (c *Client) readJSON(out interface{}) error {
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if c.rec != nil {
+		if err := c.rec.Record(Inbound, data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// writeJSON encodes v as a text frame, recording it before it is sent
+func (c *Client) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if c.rec != nil {
+		if err := c.rec.Record(Outbound, data); err != nil {
+			return err
+		}
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
 }
 
 // APIKey returns the api key being used by the client
@@ -134,6 +239,8 @@ func (c *Client) APIKey() string {
 
 // Close is used to terminate our websocket client
 func (c *Client) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	err := c.conn.WriteMessage(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
@@ -142,22 +249,42 @@ func (c *Client) Close() error {
 	return err
 }
 
+// networks maps chain ids to blocknative network names
+var networks = map[int64]string{
+	1:     "main",
+	3:     "ropsten",
+	4:     "rinkeby",
+	5:     "goerli",
+	42:    "kovan",
+	56:    "bsc-main",
+	100:   "xdai",
+	137:   "matic-main",
+	250:   "fantom-main",
+	80001: "matic-mumbai",
+}
+
 func NetName(id int64) (string, error) {
-	var netName string
-	switch id {
-	case 1:
-		netName = "main"
-	case 4:
-		netName = "rinkeby"
-	case 5:
-		netName = "goerli"
-
-	default:
+	netName, ok := networks[id]
+	if !ok {
 		return "", errors.Errorf("network not supported id:%v", id)
 	}
 	return netName, nil
 }
 
+// ParseNetwork resolves a network given by name or chain id to the name
+// blocknative expects, names are matched case insensitively
+func ParseNetwork(network string) (string, error) {
+	if id, err := strconv.ParseInt(network, 10, 64); err == nil {
+		return NetName(id)
+	}
+	for _, name := range networks {
+		if strings.EqualFold(name, network) {
+			return name, nil
+		}
+	}
+	return "", errors.Errorf("network not supported name:%v", network)
+}
+
 func ParseGas(msg *EthTxPayload) (gasBaseFeeGwei, gasTipGwei float64, err error) {
 	gasBaseFee, err := strconv.ParseFloat(msg.Event.Transaction.MaxFeePerGas, 64)
 	if err != nil {
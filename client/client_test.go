@@ -32,7 +32,6 @@ func TestClient(t *testing.T) {
 				"0xfa6de2697D59E88Ed7Fc4dFE5A33daC43565ea41",
 				false,
 				[]string{logSwapABI},
-				nil,
 			),
 		)),
 	)
@@ -41,6 +40,22 @@ func TestClient(t *testing.T) {
 	require.NoError(t, client.Close())
 }
 
+func TestParseNetwork(t *testing.T) {
+	for in, want := range map[string]string{"main": "main", "1": "main", "Goerli": "goerli", "137": "matic-main", "bsc-main": "bsc-main"} {
+		got, err := ParseNetwork(in)
+		require.NoError(t, err)
+		require.Equal(t, want, got, in)
+	}
+	_, err := ParseNetwork("mainnet")
+	require.Error(t, err)
+	_, err = ParseNetwork("31337")
+	require.Error(t, err)
+
+	msg := NewBaseMessage("key", "ethereum", "goerli")
+	require.Equal(t, Blockchain{System: "ethereum", Network: "goerli"}, msg.Blockchain)
+	require.Equal(t, "key", msg.DappID)
+}
+
 var (
 	logSwapABI = `{
 		"anonymous": false,
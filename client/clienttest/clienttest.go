@@ -0,0 +1,27 @@
+// Package clienttest builds events for the tests of packages consuming them.
+package clienttest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+// Event returns an event with the given code for tx, received at the given time
+func Event(t testing.TB, at time.Time, code string, tx client.EthTransaction) *client.Event {
+	t.Helper()
+	return TxEvent(t, at, client.TxEvent{BaseMessage: client.BaseMessage{EventCode: code}, Transaction: tx})
+}
+
+// TxEvent returns an event carrying ev, such as one with a contract call, received at the given time
+func TxEvent(t testing.TB, at time.Time, ev client.TxEvent) *client.Event {
+	t.Helper()
+	raw, err := json.Marshal(client.EthTxPayload{Status: "ok", Event: ev})
+	require.NoError(t, err)
+	out, err := client.NewEvent(at, raw)
+	require.NoError(t, err)
+	return out
+}
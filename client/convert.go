@@ -0,0 +1,185 @@
+package client
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// TxHash returns the transaction's hash
+func (tx EthTransaction) TxHash() (common.Hash, error) {
+	return parseHash(tx.Hash)
+}
+
+// ReplaceTxHash returns the hash of the transaction replaced by a speedup or cancel
+func (tx EthTransaction) ReplaceTxHash() (common.Hash, error) {
+	return parseHash(tx.ReplaceHash)
+}
+
+// FromAddress returns the transaction's sender
+func (tx EthTransaction) FromAddress() (common.Address, error) {
+	return parseAddress(tx.From)
+}
+
+// ToAddress returns the transaction's recipient, nil for contract creations
+func (tx EthTransaction) ToAddress() (*common.Address, error) {
+	if tx.To == "" {
+		return nil, nil
+	}
+	addr, err := parseAddress(tx.To)
+	if err != nil {
+		return nil, err
+	}
+	return &addr, nil
+}
+
+// InputData returns the transaction's decoded input
+func (tx EthTransaction) InputData() ([]byte, error) {
+	if tx.Input == "" || tx.Input == "0x" {
+		return nil, nil
+	}
+	data, err := hexutil.Decode(tx.Input)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding input")
+	}
+	return data, nil
+}
+
+// ValueInt returns the wei sent by the transaction
+func (tx EthTransaction) ValueInt() (*big.Int, error) {
+	if tx.Value == "" {
+		return new(big.Int), nil
+	}
+	return parseBig("value", tx.Value)
+}
+
+// Unsigned reconstructs the transaction without its signature as a legacy,
+// access list or dynamic fee transaction depending on its type. The chain id
+// of typed transactions is left for the signer to set.
+func (tx EthTransaction) Unsigned() (*types.Transaction, error) {
+	to, err := tx.ToAddress()
+	if err != nil {
+		return nil, err
+	}
+	value, err := tx.ValueInt()
+	if err != nil {
+		return nil, err
+	}
+	data, err := tx.InputData()
+	if err != nil {
+		return nil, err
+	}
+	switch tx.Type {
+	case types.LegacyTxType:
+		price, err := parseBig("gasPrice", tx.GasPrice)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    uint64(tx.Nonce),
+			GasPrice: price,
+			Gas:      uint64(tx.Gas),
+			To:       to,
+			Value:    value,
+			Data:     data,
+		}), nil
+	case types.AccessListTxType:
+		price, err := parseBig("gasPrice", tx.GasPrice)
+		if err != nil {
+			return nil, err
+		}
+		accessList, err := tx.accessList()
+		if err != nil {
+			return nil, err
+		}
+		return types.NewTx(&types.AccessListTx{
+			Nonce:      uint64(tx.Nonce),
+			GasPrice:   price,
+			Gas:        uint64(tx.Gas),
+			To:         to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		}), nil
+	case types.DynamicFeeTxType:
+		feeCap, err := parseBig("maxFeePerGas", tx.MaxFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		tipCap, err := parseBig("maxPriorityFeePerGas", tx.MaxPriorityFeePerGas)
+		if err != nil {
+			return nil, err
+		}
+		accessList, err := tx.accessList()
+		if err != nil {
+			return nil, err
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			Nonce:      uint64(tx.Nonce),
+			GasTipCap:  tipCap,
+			GasFeeCap:  feeCap,
+			Gas:        uint64(tx.Gas),
+			To:         to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		}), nil
+	default:
+		return nil, errors.Errorf("unsupported transaction type:%v", tx.Type)
+	}
+}
+
+func (tx EthTransaction) accessList() (types.AccessList, error) {
+	var list types.AccessList
+	for _, tuple := range tx.AccessList {
+		addr, err := parseAddress(tuple.Address)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]common.Hash, 0, len(tuple.StorageKeys))
+		for _, key := range tuple.StorageKeys {
+			hash, err := parseHash(key)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, hash)
+		}
+		list = append(list, types.AccessTuple{Address: addr, StorageKeys: keys})
+	}
+	return list, nil
+}
+
+func parseHash(s string) (common.Hash, error) {
+	data, err := hexutil.Decode(s)
+	if err != nil || len(data) != common.HashLength {
+		return common.Hash{}, errors.Errorf("invalid hash:%v", s)
+	}
+	return common.BytesToHash(data), nil
+}
+
+func parseAddress(s string) (common.Address, error) {
+	if !strings.HasPrefix(s, "0x") || !common.IsHexAddress(s) {
+		return common.Address{}, errors.Errorf("invalid address:%v", s)
+	}
+	return common.HexToAddress(s), nil
+}
+
+// parseBig parses a decimal or 0x prefixed hex integer
+func parseBig(name, s string) (*big.Int, error) {
+	if strings.HasPrefix(s, "0x") {
+		v, err := hexutil.DecodeBig(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", name)
+		}
+		return v, nil
+	}
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid %s:%v", name, s)
+	}
+	return v, nil
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvert(t *testing.T) {
+	rp, err := OpenReplay("testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer rp.Close()
+	var swap EthTxPayload
+	for swap.Event.ContractCall == nil {
+		require.NoError(t, rp.ReadJSON(&swap))
+	}
+	tx := swap.Event.Transaction
+
+	hash, err := tx.TxHash()
+	require.NoError(t, err)
+	require.Equal(t, common.HexToHash("0x"+strings.Repeat("b2", 32)), hash)
+	from, err := tx.FromAddress()
+	require.NoError(t, err)
+	require.Equal(t, common.HexToAddress("0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"), from)
+	input, err := tx.InputData()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x7f, 0xf3, 0x6a, 0xb5}, input)
+
+	unsigned, err := tx.Unsigned()
+	require.NoError(t, err)
+	require.Equal(t, uint8(types.DynamicFeeTxType), unsigned.Type())
+	require.Equal(t, uint64(43), unsigned.Nonce())
+	require.Equal(t, uint64(210000), unsigned.Gas())
+	require.Equal(t, big.NewInt(120000000000), unsigned.GasFeeCap())
+	require.Equal(t, big.NewInt(2000000000), unsigned.GasTipCap())
+	require.Equal(t, "1500000000000000000", unsigned.Value().String())
+	require.Equal(t, common.HexToAddress("0x7a250d5630b4cf539739df2c5dacb4c659f2488d"), *unsigned.To())
+	require.Equal(t, input, unsigned.Data())
+
+	var legacy EthTransaction
+	require.NoError(t, json.Unmarshal([]byte(`{"hash":"0x01","from":"0x01","gasPrice":"0x3b9aca00","gas":21000,"value":"1","input":"0x"}`), &legacy))
+	unsigned, err = legacy.Unsigned()
+	require.NoError(t, err)
+	require.Equal(t, uint8(types.LegacyTxType), unsigned.Type())
+	require.Equal(t, big.NewInt(1000000000), unsigned.GasPrice())
+	// contract creations have no recipient
+	require.Nil(t, unsigned.To())
+	require.Empty(t, unsigned.Data())
+
+	// access list transactions keep their type rather than falling back to legacy
+	var accessList EthTransaction
+	require.NoError(t, json.Unmarshal([]byte(`{"type":1,"to":"0x7a250d5630b4cf539739df2c5dacb4c659f2488d","gasPrice":"1000000000","gas":50000,
+		"nonce":7,"value":"0","input":"0x","accessList":[{"address":"0x7a250d5630b4cf539739df2c5dacb4c659f2488d","storageKeys":["0x`+strings.Repeat("01", 32)+`"]}]}`), &accessList))
+	unsigned, err = accessList.Unsigned()
+	require.NoError(t, err)
+	require.Equal(t, uint8(types.AccessListTxType), unsigned.Type())
+	require.Equal(t, big.NewInt(1000000000), unsigned.GasPrice())
+	require.Equal(t, types.AccessList{{
+		Address:     common.HexToAddress("0x7a250d5630b4cf539739df2c5dacb4c659f2488d"),
+		StorageKeys: []common.Hash{common.HexToHash("0x" + strings.Repeat("01", 32))},
+	}}, unsigned.AccessList())
+	accessList.AccessList[0].StorageKeys[0] = "0x01"
+	_, err = accessList.Unsigned()
+	require.Error(t, err)
+	accessList.Type = 3
+	_, err = accessList.Unsigned()
+	require.Error(t, err)
+
+	// malformed values are rejected rather than zeroed
+	_, err = legacy.TxHash()
+	require.Error(t, err)
+	_, err = legacy.FromAddress()
+	require.Error(t, err)
+	_, err = legacy.ReplaceTxHash()
+	require.Error(t, err)
+	legacy.GasPrice = ""
+	_, err = legacy.Unsigned()
+	require.Error(t, err)
+	legacy.Input = "0xzz"
+	_, err = legacy.InputData()
+	require.Error(t, err)
+}
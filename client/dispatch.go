@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Source is anything websocket frames can be read from, such as a Client
+type Source interface {
+	ReadJSON(out interface{}) error
+}
+
+// Dispatcher reads events from a source and delivers them to every
+// subscriber through a bounded per subscriber buffer, such that a slow
+// consumer only affects itself unless it opts into the Block policy.
+type Dispatcher struct {
+	mx   sync.RWMutex
+	subs map[*EventBuffer]struct{}
+}
+
+// NewDispatcher returns a dispatcher with no subscribers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{subs: make(map[*EventBuffer]struct{})}
+}
+
+// Subscribe registers a new subscriber buffer. Events are read from the
+// returned buffer with EventBuffer::Next and it should be released with
+// Dispatcher::Unsubscribe.
+func (d *Dispatcher) Subscribe(opts BufferOpts) (*EventBuffer, error) {
+	buf, err := NewEventBuffer(opts)
+	if err != nil {
+		return nil, err
+	}
+	d.mx.Lock()
+	d.subs[buf] = struct{}{}
+	d.mx.Unlock()
+	return buf, nil
+}
+
+// Unsubscribe removes and closes a subscriber buffer
+func (d *Dispatcher) Unsubscribe(buf *EventBuffer) error {
+	d.mx.Lock()
+	delete(d.subs, buf)
+	d.mx.Unlock()
+	return buf.Close()
+}
+
+// Publish delivers ev to every subscriber. Subscribers using the Block
+// policy may cause this to wait until they have room or ctx is done.
+func (d *Dispatcher) Publish(ctx context.Context, ev *Event) error {
+	d.mx.RLock()
+	subs := make([]*EventBuffer, 0, len(d.subs))
+	for buf := range d.subs {
+		subs = append(subs, buf)
+	}
+	d.mx.RUnlock()
+	for _, buf := range subs {
+		// a subscriber closing concurrently is not a dispatch failure
+		if err := buf.Push(ctx, ev); err != nil && err != ErrClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of every current subscriber
+func (d *Dispatcher) Stats() []BufferStats {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+	stats := make([]BufferStats, 0, len(d.subs))
+	for buf := range d.subs {
+		stats = append(stats, buf.Stats())
+	}
+	return stats
+}
+
+// Run reads frames from src and publishes them until reading fails or ctx is
+// done. Reads are not interruptible, so closing the source is what stops a
+// Run blocked waiting for the next frame.
+func (d *Dispatcher) Run(ctx context.Context, src Source) error {
+	for {
+		var raw json.RawMessage
+		if err := src.ReadJSON(&raw); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ev, err := NewEvent(time.Now(), raw)
+		if err != nil {
+			return err
+		}
+		if err := d.Publish(ctx, ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event is a single frame received from the websockets api along with its decoded payload
+type Event struct {
+	ReceivedAt time.Time       `json:"receivedAt"`
+	Raw        json.RawMessage `json:"raw"`
+	Payload    EthTxPayload    `json:"-"`
+}
+
+// NewEvent decodes raw into an event received at the given time
+func NewEvent(receivedAt time.Time, raw []byte) (*Event, error) {
+	ev := &Event{ReceivedAt: receivedAt, Raw: append(json.RawMessage(nil), raw...)}
+	if err := json.Unmarshal(raw, &ev.Payload); err != nil {
+		return nil, errors.Wrap(err, "decoding event payload")
+	}
+	return ev, nil
+}
+
+// UnmarshalJSON restores an event previously encoded with json.Marshal, decoding the payload from the raw frame
+func (ev *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	var out event
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+	decoded, err := NewEvent(out.ReceivedAt, out.Raw)
+	if err != nil {
+		return err
+	}
+	*ev = *decoded
+	return nil
+}
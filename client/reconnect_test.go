@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnect(t *testing.T) {
+	var (
+		mx       sync.Mutex
+		conns    []*websocket.Conn
+		received [][]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		mx.Lock()
+		conns = append(conns, conn)
+		received = append(received, nil)
+		n := len(received) - 1
+		mx.Unlock()
+		conn.WriteJSON(ConnectResponse{Status: "ok"})
+		for {
+			var msg BaseMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			mx.Lock()
+			received[n] = append(received[n], msg.EventCode)
+			mx.Unlock()
+			conn.WriteJSON(ConnectResponse{Status: "ok"})
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	client, err := New(context.Background(), Opts{Scheme: "ws", Host: u.Host, History: &MsgHistory{}})
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Initialize(NewBaseMessageMainnet("test")))
+	base := NewBaseMessageMainnet("test")
+	require.NoError(t, client.WriteJSON(NewAddressSubscribe(base, "0x01")))
+	require.NoError(t, client.WriteJSON(NewTxSubscribe(base, "0x02")))
+	require.NoError(t, client.WriteJSON(NewAddressUnsubscribe(base, "0x01")))
+	for i := 0; i < 3; i++ {
+		var ack ConnectResponse
+		require.NoError(t, client.ReadJSON(&ack))
+	}
+
+	// drop the connection from the server side
+	mx.Lock()
+	conns[0].Close()
+	mx.Unlock()
+	var out EthTxPayload
+	require.Error(t, client.ReadJSON(&out))
+
+	require.NoError(t, client.Reconnect())
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(received) == 2 && len(received[1]) == 4
+	}, time.Second, time.Millisecond*10)
+	// initialization is resent before the history is replayed in order
+	require.Equal(t, []string{"checkDappId", "watch", "txSent", "unwatch"}, received[1])
+	require.Equal(t, 3, client.opts.History.Len())
+	require.NoError(t, client.ReadJSON(&out))
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Direction indicates whether a recorded frame was sent or received
+type Direction string
+
+const (
+	// Inbound frames were received from the api
+	Inbound Direction = "in"
+	// Outbound frames were sent to the api
+	Outbound Direction = "out"
+)
+
+// Frame is a single recorded websocket frame, stored as one line of NDJSON
+type Frame struct {
+	Time      time.Time       `json:"time"`
+	Direction Direction       `json:"direction"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Recorder writes websocket frames to an NDJSON stream so that a session can be replayed later
+type Recorder struct {
+	mx     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewRecorder returns a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	rec := &Recorder{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		rec.closer = c
+	}
+	return rec
+}
+
+// CreateRecording returns a recorder writing to the file at path, truncating it if it exists
+func CreateRecording(path string) (*Recorder, error) {
+	fh, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating recording")
+	}
+	return NewRecorder(fh), nil
+}
+
+// Record writes a single frame with the current time
+func (r *Recorder) Record(dir Direction, data []byte) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if err := r.enc.Encode(Frame{Time: time.Now(), Direction: dir, Data: data}); err != nil {
+		return errors.Wrap(err, "recording frame")
+	}
+	return nil
+}
+
+// Close closes the underlying writer if it is closable
+func (r *Recorder) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Replayer is a Source that feeds the inbound frames of a recording back
+// through the normal decoding path, typically Dispatcher::Run. Speed
+// controls pacing: 1 replays at the recorded rate, 10 ten times faster and
+// 0 as fast as possible. ReadJSON returns io.EOF once the recording ends.
+type Replayer struct {
+	Speed float64
+
+	rd     *bufio.Reader
+	closer io.Closer
+	done   chan struct{}
+	once   sync.Once
+	last   time.Time
+}
+
+// NewReplayer returns a replayer reading a recording from r
+func NewReplayer(r io.Reader, speed float64) *Replayer {
+	rp := &Replayer{Speed: speed, rd: bufio.NewReader(r), done: make(chan struct{})}
+	if c, ok := r.(io.Closer); ok {
+		rp.closer = c
+	}
+	return rp
+}
+
+// OpenReplay returns a replayer reading the recording at path
+func OpenReplay(path string, speed float64) (*Replayer, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening recording")
+	}
+	return NewReplayer(fh, speed), nil
+}
+
+// Next returns the next frame of the recording in either direction without any pacing
+func (rp *Replayer) Next() (*Frame, error) {
+	for {
+		line, err := rp.rd.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(line, &frame); err != nil {
+			return nil, errors.Wrap(err, "decoding recorded frame")
+		}
+		return &frame, nil
+	}
+}
+
+// ReadFrame waits until the next inbound frame is due and returns it
+func (rp *Replayer) ReadFrame() (*Frame, error) {
+	for {
+		frame, err := rp.Next()
+		if err != nil {
+			return nil, err
+		}
+		if frame.Direction != Inbound {
+			continue
+		}
+		if err := rp.wait(frame.Time); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+}
+
+// ReadJSON waits until the next inbound frame is due and decodes it into out
+func (rp *Replayer) ReadJSON(out interface{}) error {
+	frame, err := rp.ReadFrame()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(frame.Data, out)
+}
+
+// Close stops any pending wait and closes the underlying reader if it is closable
+func (rp *Replayer) Close() error {
+	rp.once.Do(func() { close(rp.done) })
+	if rp.closer == nil {
+		return nil
+	}
+	return rp.closer.Close()
+}
+
+// wait sleeps for the recorded gap between the previous frame and one sent at ts, scaled by Speed
+func (rp *Replayer) wait(ts time.Time) error {
+	last := rp.last
+	rp.last = ts
+	if rp.Speed <= 0 || last.IsZero() || !ts.After(last) {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(float64(ts.Sub(last)) / rp.Speed))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-rp.done:
+		return io.EOF
+	}
+}
+
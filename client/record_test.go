@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer starts a websocket server that acknowledges the connection and every message it receives
+func newTestServer(t *testing.T) Opts {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := conn.WriteJSON(ConnectResponse{Status: "ok", ConnectionID: "test"}); err != nil {
+			return
+		}
+		for {
+			var msg BaseMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if err := conn.WriteJSON(ConnectResponse{Status: "ok", ConnectionID: "test"}); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return Opts{Scheme: "ws", Host: u.Host}
+}
+
+func TestRecorder(t *testing.T) {
+	var out bytes.Buffer
+	opts := newTestServer(t)
+	opts.Recorder = NewRecorder(&out)
+	client, err := New(context.Background(), opts)
+	require.NoError(t, err)
+	require.NoError(t, client.Initialize(NewBaseMessageMainnet("test")))
+	require.NoError(t, client.Close())
+
+	rp := NewReplayer(&out, 0)
+	var directions []Direction
+	for {
+		frame, err := rp.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		directions = append(directions, frame.Direction)
+	}
+	// connect response, initialize message, initialize response
+	require.Equal(t, []Direction{Inbound, Outbound, Inbound}, directions)
+}
+
+func TestReplayer(t *testing.T) {
+	ctx := context.Background()
+	rp, err := OpenReplay("testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer rp.Close()
+	d := NewDispatcher()
+	buf, err := d.Subscribe(BufferOpts{})
+	require.NoError(t, err)
+	require.Equal(t, io.EOF, d.Run(ctx, rp))
+	// outbound frames are skipped, inbound frames are dispatched
+	require.Equal(t, 6, buf.Stats().Pending)
+	var hashes []string
+	for buf.Stats().Pending > 0 {
+		ev, err := buf.Next(ctx)
+		require.NoError(t, err)
+		if hash := ev.Payload.Event.Transaction.Hash; hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	require.Len(t, hashes, 3)
+	require.Equal(t, hashes[0], hashes[2])
+
+	// replaying at 1000x speed preserves the relative frame timing
+	rp, err = OpenReplay("testdata/session.ndjson", 1000)
+	require.NoError(t, err)
+	defer rp.Close()
+	start := time.Now()
+	require.Equal(t, io.EOF, d.Run(ctx, rp))
+	require.True(t, time.Since(start) >= time.Millisecond*13)
+}
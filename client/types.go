@@ -50,39 +50,93 @@ type EthTxPayload struct {
 	TimeStamp     time.Time `json:"timeStamp"`
 	ConnectionID  string    `json:"connectionId"`
 	Status        string    `json:"status"`
-	Event         struct {
-		BaseMessage
-		Transaction struct {
-			Type                 int       `json:"type"`
-			MaxFeePerGas         string    `json:"maxFeePerGas"`
-			MaxPriorityFeePerGas string    `json:"maxPriorityFeePerGas"`
-			BaseFeePerGas        string    `json:"baseFeePerGas"`
-			TimeStamp            time.Time `json:"timeStamp"`
-			Status               string    `json:"status"`
-			MonitorID            string    `json:"monitorId"`
-			MonitorVersion       string    `json:"monitorVersion"`
-			TimePending          string    `json:"timePending"`
-			PendingTimeStamp     time.Time `json:"pendingTimeStamp"`
-			BlocksPending        int       `json:"blocksPending"`
-			Hash                 string    `json:"hash"`
-			From                 string    `json:"from"`
-			To                   string    `json:"to"`
-			Value                string    `json:"value"`
-			Gas                  int       `json:"gas"`
-			GasPrice             string    `json:"gasPrice"`
-			GasPriceGwei         int       `json:"gasPriceGwei"`
-			Nonce                int       `json:"nonce"`
-			BlockHash            string    `json:"blockHash"`
-			BlockNumber          int       `json:"blockNumber"`
-			TransactionIndex     int       `json:"transactionIndex"`
-			Input                string    `json:"input"`
-			GasUsed              string    `json:"gasUsed"`
-			Asset                string    `json:"asset"`
-			WatchedAddress       string    `json:"watchedAddress"`
-			Direction            string    `json:"direction"`
-			Counterparty         string    `json:"counterparty"`
-		} `json:"transaction"`
-	} `json:"event"`
+	Event         TxEvent   `json:"event"`
+}
+
+// TxEvent is the event portion of a payload, the embedded base message
+// carries the event code (txPool, txConfirmed, txSpeedUp, etc...)
+type TxEvent struct {
+	BaseMessage
+	Transaction  EthTransaction `json:"transaction"`
+	ContractCall *ContractCall  `json:"contractCall,omitempty"`
+}
+
+// EthTransaction is the transaction an event refers to
+type EthTransaction struct {
+	Type                 int             `json:"type"`
+	MaxFeePerGas         string          `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string          `json:"maxPriorityFeePerGas"`
+	BaseFeePerGas        string          `json:"baseFeePerGas"`
+	TimeStamp            time.Time       `json:"timeStamp"`
+	Status               string          `json:"status"`
+	MonitorID            string          `json:"monitorId"`
+	MonitorVersion       string          `json:"monitorVersion"`
+	TimePending          string          `json:"timePending"`
+	PendingTimeStamp     time.Time       `json:"pendingTimeStamp"`
+	PendingBlockNumber   int             `json:"pendingBlockNumber"`
+	BlocksPending        int             `json:"blocksPending"`
+	Hash                 string          `json:"hash"`
+	ReplaceHash          string          `json:"replaceHash"` // set on speedup and cancel events
+	From                 string          `json:"from"`
+	To                   string          `json:"to"`
+	Value                string          `json:"value"`
+	Gas                  int             `json:"gas"`
+	GasPrice             string          `json:"gasPrice"`
+	GasPriceGwei         int             `json:"gasPriceGwei"`
+	Nonce                int             `json:"nonce"`
+	BlockHash            string          `json:"blockHash"`
+	BlockNumber          int             `json:"blockNumber"`
+	TransactionIndex     int             `json:"transactionIndex"`
+	Input                string          `json:"input"`
+	GasUsed              string          `json:"gasUsed"`
+	Asset                string          `json:"asset"`
+	WatchedAddress       string          `json:"watchedAddress"`
+	Direction            string          `json:"direction"`
+	Counterparty         string          `json:"counterparty"`
+	NetBalanceChanges    []BalanceChange `json:"netBalanceChanges,omitempty"`
+	AccessList           []AccessTuple   `json:"accessList,omitempty"` // set on access list and dynamic fee transactions
+}
+
+// AccessTuple is an address and the storage slots of it a transaction declares it will access
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// ContractCall is the decoded contract call of a transaction, present when
+// blocknative knows the abi of the contract being called
+type ContractCall struct {
+	ContractType    string                 `json:"contractType"`
+	ContractAddress string                 `json:"contractAddress"`
+	ContractName    string                 `json:"contractName"`
+	MethodName      string                 `json:"methodName"`
+	Params          map[string]interface{} `json:"params"`
+}
+
+// BalanceChange is the net change in balances of a single address caused by a transaction
+type BalanceChange struct {
+	Address        string        `json:"address"`
+	BalanceChanges []AssetChange `json:"balanceChanges"`
+}
+
+// AssetChange is the change in balance of a single asset
+type AssetChange struct {
+	Delta     string           `json:"delta"`
+	Asset     Asset            `json:"asset"`
+	Breakdown []AssetBreakdown `json:"breakdown,omitempty"`
+}
+
+// Asset identifies ether or a token
+type Asset struct {
+	Type            string `json:"type"`
+	Symbol          string `json:"symbol"`
+	ContractAddress string `json:"contractAddress,omitempty"`
+}
+
+// AssetBreakdown is a single transfer contributing to an asset change
+type AssetBreakdown struct {
+	Counterparty string `json:"counterparty"`
+	Amount       string `json:"amount"`
 }
 
 // Configuration enables configuration of the blocknative websockets api
@@ -169,6 +223,12 @@ func NewAddressUnsubscribe(msg BaseMessage, address string) AddressSubscribe {
 
 // NewBaseMessageMainnet returns a base message suitable for mainnet usage
 func NewBaseMessageMainnet(apiKey string) BaseMessage {
+	return NewBaseMessage(apiKey, "ethereum", "main")
+}
+
+// NewBaseMessage returns a base message for the given system and network,
+// see ParseNetwork for resolving chain ids to network names
+func NewBaseMessage(apiKey, system, network string) BaseMessage {
 	if apiKey == "" {
 		apiKey = os.Getenv("BLOCKNATIVE_DAPP_ID")
 	}
@@ -176,8 +236,8 @@ func NewBaseMessageMainnet(apiKey string) BaseMessage {
 		Timestamp: time.Now(),
 		DappID:    apiKey,
 		Blockchain: Blockchain{
-			System:  "ethereum",
-			Network: "main",
+			System:  system,
+			Network: network,
 		},
 	}
 }
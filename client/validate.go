@@ -0,0 +1,103 @@
+package client
+
+import (
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// NormalizeAddress validates a 0x prefixed hex address, verifying its EIP-55
+// checksum when it is mixed case, and returns it lowercased to match how
+// events report addresses
+func NormalizeAddress(address string) (string, error) {
+	if !strings.HasPrefix(address, "0x") || !common.IsHexAddress(address) {
+		return "", errors.Errorf("invalid address:%v", address)
+	}
+	digits := address[2:]
+	if strings.ToLower(digits) != digits && strings.ToUpper(digits) != digits {
+		if checksummed := common.HexToAddress(address).Hex(); checksummed != address {
+			return "", errors.Errorf("invalid address checksum:%v, expected %v", address, checksummed)
+		}
+	}
+	return strings.ToLower(address), nil
+}
+
+// NormalizeHash validates a 0x prefixed 32 byte hex hash and returns it lowercased
+func NormalizeHash(hash string) (string, error) {
+	data, err := hexutil.Decode(hash)
+	if err != nil || len(data) != common.HashLength {
+		return "", errors.Errorf("invalid hash:%v", hash)
+	}
+	return strings.ToLower(hash), nil
+}
+
+// NewAddressSubscribeChecked is like NewAddressSubscribe but validates and normalizes the address
+func NewAddressSubscribeChecked(msg BaseMessage, address string) (AddressSubscribe, error) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return AddressSubscribe{}, err
+	}
+	return NewAddressSubscribe(msg, address), nil
+}
+
+// NewAddressUnsubscribeChecked is like NewAddressUnsubscribe but validates and normalizes the address
+func NewAddressUnsubscribeChecked(msg BaseMessage, address string) (AddressSubscribe, error) {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return AddressSubscribe{}, err
+	}
+	return NewAddressUnsubscribe(msg, address), nil
+}
+
+// NewTxSubscribeChecked is like NewTxSubscribe but validates and normalizes the hash
+func NewTxSubscribeChecked(msg BaseMessage, txHash string) (TxSubscribe, error) {
+	txHash, err := NormalizeHash(txHash)
+	if err != nil {
+		return TxSubscribe{}, err
+	}
+	return NewTxSubscribe(msg, txHash), nil
+}
+
+// NewTxUnsubscribeChecked is like NewTxUnsubscribe but validates and normalizes the hash
+func NewTxUnsubscribeChecked(msg BaseMessage, txHash string) (TxSubscribe, error) {
+	txHash, err := NormalizeHash(txHash)
+	if err != nil {
+		return TxSubscribe{}, err
+	}
+	return NewTxUnsubscribe(msg, txHash), nil
+}
+
+// NewConfigChecked is like NewConfig but validates that scope is "global" or
+// an address, normalizing addresses
+func NewConfigChecked(scope string, watchAddress bool, abis interface{}) (Config, error) {
+	if scope != "global" {
+		address, err := NormalizeAddress(scope)
+		if err != nil {
+			return Config{}, errors.Wrap(err, "scope must be global or an address")
+		}
+		scope = address
+	}
+	return NewConfig(scope, watchAddress, abis), nil
+}
+
+// NewEthAddressSubscribe constructs an address subscription message for a go-ethereum address
+func NewEthAddressSubscribe(msg BaseMessage, address common.Address) AddressSubscribe {
+	return NewAddressSubscribe(msg, strings.ToLower(address.Hex()))
+}
+
+// NewEthAddressUnsubscribe constructs an address unsubscribe message for a go-ethereum address
+func NewEthAddressUnsubscribe(msg BaseMessage, address common.Address) AddressSubscribe {
+	return NewAddressUnsubscribe(msg, strings.ToLower(address.Hex()))
+}
+
+// NewEthTxSubscribe constructs a transaction subscription message for a go-ethereum hash
+func NewEthTxSubscribe(msg BaseMessage, txHash common.Hash) TxSubscribe {
+	return NewTxSubscribe(msg, txHash.Hex())
+}
+
+// NewEthTxUnsubscribe constructs a transaction unsubscribe message for a go-ethereum hash
+func NewEthTxUnsubscribe(msg BaseMessage, txHash common.Hash) TxSubscribe {
+	return NewTxUnsubscribe(msg, txHash.Hex())
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	const (
+		checksummed = "0xfa6de2697D59E88Ed7Fc4dFE5A33daC43565ea41"
+		lower       = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	)
+	for _, addr := range []string{checksummed, lower, "0x" + strings.ToUpper(lower[2:])} {
+		got, err := NormalizeAddress(addr)
+		require.NoError(t, err, addr)
+		require.Equal(t, lower, got)
+	}
+	for _, addr := range []string{
+		"0xfa6de2697D59E88Ed7Fc4dFE5A33daC43565eA41", // bad checksum
+		lower[2:],    // missing prefix
+		lower[:40],   // too short
+		lower + "00", // too long
+		"0xzz6de2697d59e88ed7fc4dfe5a33dac43565ea41",
+	} {
+		_, err := NormalizeAddress(addr)
+		require.Error(t, err, addr)
+	}
+
+	msg := NewBaseMessageMainnet("key")
+	sub, err := NewAddressSubscribeChecked(msg, checksummed)
+	require.NoError(t, err)
+	require.Equal(t, lower, sub.Account.Address)
+	require.Equal(t, NewAddressSubscribe(msg, lower), sub)
+	require.Equal(t, sub, NewEthAddressSubscribe(msg, common.HexToAddress(checksummed)))
+	_, err = NewAddressUnsubscribeChecked(msg, "0x01")
+	require.Error(t, err)
+
+	hash := "0x" + strings.Repeat("A1", 32)
+	tx, err := NewTxSubscribeChecked(msg, hash)
+	require.NoError(t, err)
+	require.Equal(t, strings.ToLower(hash), tx.Transaction.Hash)
+	require.Equal(t, tx, NewEthTxSubscribe(msg, common.HexToHash(hash)))
+	_, err = NewTxUnsubscribeChecked(msg, "0x01")
+	require.Error(t, err)
+
+	cfg, err := NewConfigChecked(checksummed, true, nil)
+	require.NoError(t, err)
+	require.Equal(t, lower, cfg.Scope)
+	_, err = NewConfigChecked("global", false, nil)
+	require.NoError(t, err)
+	_, err = NewConfigChecked("everything", false, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bonedaddy/go-blocknative/daemon"
+	"github.com/urfave/cli/v2"
+)
+
+func daemonCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "daemon",
+		Usage: "keep the subscriptions and sinks declared in a config file running",
+		Description: "the config file declares networks with their addresses, transactions and contract configs, " +
+			"along with file, webhook and sqlite sinks. it is reloaded whenever it changes, only sending the difference, " +
+			"and GET /healthz reports the state of every network and sink",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "config",
+				Usage:    "yaml file declaring what to watch",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve the health endpoint on",
+				Value: "127.0.0.1:8083",
+			},
+			&cli.DurationFlag{
+				Name:  "reload.interval",
+				Usage: "how often the config file is checked for changes",
+				Value: daemon.DefaultReloadInterval,
+			},
+			&cli.StringFlag{
+				Name:  "state.dir",
+				Usage: "directory holding webhook queues, defaults to the config file's directory",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			opts, err := clientOpts(c)
+			if err != nil {
+				return err
+			}
+			d := daemon.New(daemon.Opts{
+				Path:           c.String("config"),
+				Dial:           daemon.DialClient(opts),
+				APIKey:         opts.APIKey,
+				ReloadInterval: c.Duration("reload.interval"),
+				StateDir:       c.String("state.dir"),
+			})
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", d)
+			// stop the daemon if the health endpoint can not be served and vice versa
+			ctx, cancel := context.WithCancel(c.Context)
+			defer cancel()
+			serveErr, runErr := make(chan error, 1), make(chan error, 1)
+			go func() { serveErr <- listenAndServe(ctx, c.String("listen"), mux) }()
+			go func() { runErr <- d.Run(ctx) }()
+			select {
+			case err := <-serveErr:
+				cancel()
+				<-runErr
+				return err
+			case err := <-runErr:
+				cancel()
+				<-serveErr
+				return err
+			}
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/calldata"
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/urfave/cli/v2"
+)
+
+func decodeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "decode",
+		Usage: "decode transaction input into the called method and its arguments",
+		Description: "decodes --input, or else every line of stdin, which can be hex input, events as printed by " +
+			"subscribe and written by sinks, or frames of a recording. methods come from --abi files first and then " +
+			"from the selector database, a text file of one signature such as transfer(address,uint256) per line " +
+			"added to a set of common token and router signatures",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "abi",
+				Usage: "json abi files of the called contracts",
+			},
+			&cli.StringFlag{
+				Name:  "selectors",
+				Usage: "selector database file",
+				Value: defaultSelectorsFile(),
+			},
+			&cli.StringFlag{
+				Name:  "input",
+				Usage: "hex encoded input to decode instead of reading stdin",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print a json object per decoded input",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			d, err := newDecoder(c)
+			if err != nil {
+				return err
+			}
+			if input := c.String("input"); input != "" {
+				call, err := d.DecodeHex(input)
+				if err != nil {
+					return err
+				}
+				return printCall(os.Stdout, c.Bool("json"), decoded{Call: call})
+			}
+			return decodeStream(c, d, os.Stdin, os.Stdout)
+		},
+	}
+}
+
+// defaultSelectorsFile returns the selector database next to the profiles file
+func defaultSelectorsFile() string {
+	if path := defaultProfilesFile(); path != "" {
+		return filepath.Join(filepath.Dir(path), "selectors.txt")
+	}
+	return ""
+}
+
+// newDecoder returns a decoder knowing the --abi files and the selector database,
+// which may only be missing if it is the default one
+func newDecoder(c *cli.Context) (*calldata.Decoder, error) {
+	d := calldata.New()
+	for _, path := range c.StringSlice("abi") {
+		if err := d.AddABIFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if path := c.String("selectors"); path != "" {
+		err := d.LoadSelectorsFile(path)
+		if err != nil && !(os.IsNotExist(err) && !c.IsSet("selectors")) {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// decoded is a decoded input along with the transaction it came from
+type decoded struct {
+	Hash  string         `json:"hash,omitempty"`
+	From  string         `json:"from,omitempty"`
+	To    string         `json:"to,omitempty"`
+	Call  *calldata.Call `json:"call,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// decodeStream decodes every line of r, skipping transactions without input
+func decodeStream(c *cli.Context, d *calldata.Decoder, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var out decoded
+		if bytes.HasPrefix(line, []byte("0x")) {
+			call, err := d.DecodeHex(string(line))
+			out.Call = call
+			if err != nil {
+				out.Error = err.Error()
+			}
+		} else {
+			tx, err := lineTransaction(line)
+			if err != nil {
+				log.Println("skipping malformed line: ", err)
+				continue
+			}
+			if tx == nil {
+				continue
+			}
+			data, err := tx.InputData()
+			if len(data) == 0 && err == nil {
+				continue
+			}
+			out.Hash, out.From, out.To = tx.Hash, tx.From, tx.To
+			if err == nil {
+				out.Call, err = d.Decode(data)
+			}
+			if err != nil {
+				out.Error = err.Error()
+			}
+		}
+		if err := printCall(w, c.Bool("json"), out); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// lineTransaction returns the transaction of an event or of an incoming
+// recorded frame, nil for other frames
+func lineTransaction(line []byte) (*client.EthTransaction, error) {
+	var frame client.Frame
+	if err := json.Unmarshal(line, &frame); err != nil {
+		return nil, err
+	}
+	if frame.Direction != "" && len(frame.Data) > 0 {
+		if frame.Direction != client.Inbound {
+			return nil, nil
+		}
+		line = frame.Data
+	}
+	ev, err := client.NewEvent(time.Now(), line)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Payload.Event.Transaction.Hash == "" {
+		return nil, nil
+	}
+	return &ev.Payload.Event.Transaction, nil
+}
+
+// printCall writes a decoded input as a json line or as the signature followed by the arguments
+func printCall(w io.Writer, asJSON bool, out decoded) error {
+	if asJSON {
+		data, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	if out.Hash != "" {
+		fmt.Fprintf(w, "%s %s -> %s\n", out.Hash, out.From, out.To)
+	}
+	if out.Error != "" {
+		_, err := fmt.Fprintf(w, "error: %s\n\n", out.Error)
+		return err
+	}
+	fmt.Fprintf(w, "%s %s\n", out.Call.Signature, out.Call.Selector)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, arg := range out.Call.Args {
+		name := arg.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		value, ok := arg.Value.(string)
+		if !ok {
+			data, err := json.Marshal(arg.Value)
+			if err != nil {
+				return err
+			}
+			value = string(data)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", name, arg.Type, strings.TrimSpace(value))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
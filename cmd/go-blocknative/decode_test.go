@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bonedaddy/go-blocknative/calldata"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// transfer(0x7a250d5630b4cf539739df2c5dacb4c659f2488d, 1000)
+const transferInput = "0xa9059cbb" +
+	"0000000000000000000000007a250d5630b4cf539739df2c5dacb4c659f2488d" +
+	"00000000000000000000000000000000000000000000000000000000000003e8"
+
+func TestDecodeStream(t *testing.T) {
+	tx := func(hash, input string) string {
+		return `{"event":{"eventCode":"txPool","transaction":{"hash":"` + hash +
+			`","from":"0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41","to":"0xdac17f958d2ee523a2206206994597c13d831ec7","input":"` + input + `"}}}`
+	}
+	for _, tc := range []struct {
+		name string
+		line string
+		// decoded output, empty if the line is skipped
+		want decoded
+	}{
+		{name: "hex", line: transferInput, want: decoded{Call: &calldata.Call{Method: "transfer"}}},
+		{name: "hex error", line: "0x0102", want: decoded{Error: "input too short:2 bytes"}},
+		{name: "event", line: tx("0x01", transferInput), want: decoded{Hash: "0x01", Call: &calldata.Call{Method: "transfer"}}},
+		{name: "event unknown selector", line: tx("0x02", "0x01020304"), want: decoded{Hash: "0x02", Error: "unknown selector:0x01020304"}},
+		{name: "event without input", line: tx("0x03", "0x")},
+		{name: "inbound frame", line: `{"time":"2021-09-14T10:00:01Z","direction":"in","data":` + tx("0x04", transferInput) + `}`,
+			want: decoded{Hash: "0x04", Call: &calldata.Call{Method: "transfer"}}},
+		{name: "outbound frame", line: `{"time":"2021-09-14T10:00:01Z","direction":"out","data":` + tx("0x05", transferInput) + `}`},
+		{name: "acknowledgement", line: `{"status":"ok","event":{"eventCode":"watch"}}`},
+		{name: "malformed", line: `{"event":`},
+		{name: "blank", line: "  "},
+	} {
+		var out bytes.Buffer
+		c := newContext(t, []cli.Flag{&cli.BoolFlag{Name: "json"}}, "--json")
+		require.NoError(t, decodeStream(c, calldata.New(), strings.NewReader(tc.line+"\n"), &out), tc.name)
+		if tc.want.Call == nil && tc.want.Error == "" {
+			require.Empty(t, out.String(), tc.name)
+			continue
+		}
+		var got decoded
+		require.NoError(t, json.Unmarshal(out.Bytes(), &got), tc.name)
+		require.Equal(t, tc.want.Hash, got.Hash, tc.name)
+		require.Equal(t, tc.want.Error, got.Error, tc.name)
+		if tc.want.Call != nil {
+			require.NotNil(t, got.Call, tc.name)
+			require.Equal(t, tc.want.Call.Method, got.Call.Method, tc.name)
+			require.Len(t, got.Call.Args, 2, tc.name)
+			require.Equal(t, "0x7a250d5630b4cf539739df2c5dacb4c659f2488d", got.Call.Args[0].Value, tc.name)
+		}
+	}
+}
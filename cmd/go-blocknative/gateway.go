@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	"github.com/urfave/cli/v2"
+)
+
+func gatewayCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gateway",
+		Usage: "serve subscriptions over http with server-sent events and long-polling",
+		Description: "POST /subscriptions with {\"address\":...}, {\"hash\":...} or {\"config\":...} to subscribe, " +
+			"then stream events from GET /subscriptions/{id}/events or long-poll GET /subscriptions/{id}/poll?timeout=30s",
+		Before: connect,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve the gateway on",
+				Value: "127.0.0.1:8081",
+			},
+			&cli.IntFlag{
+				Name:  "buffer.size",
+				Usage: "number of events buffered for each subscription",
+				Value: client.DefaultBufferSize,
+			},
+			&cli.DurationFlag{
+				Name:  "idle.timeout",
+				Usage: "remove subscriptions that nobody streamed or polled for this long, 0 keeps them until deleted",
+				Value: gateway.DefaultIdleTimeout,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			defer apiClient.Close()
+			gw := gateway.New(apiClient, func() client.BaseMessage {
+				return baseMessage(c)
+			}, client.BufferOpts{Size: c.Int("buffer.size"), Policy: client.DropOldest})
+			defer gw.Close()
+			if idle := c.Duration("idle.timeout"); idle > 0 {
+				go func() {
+					ticker := time.NewTicker(idle / 2)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-c.Context.Done():
+							return
+						case now := <-ticker.C:
+							if n := gw.Prune(now.Add(-idle)); n > 0 {
+								log.Printf("removed %d idle subscriptions", n)
+							}
+						}
+					}
+				}()
+			}
+			return serveWhile(c, func() error {
+				return runGateway(c, gw)
+			}, func(ctx context.Context) error {
+				return listenAndServe(ctx, c.String("listen"), gw)
+			})
+		},
+	}
+}
+
+// runGateway routes events to gw until the command exits, reconnecting and
+// sending every subscription held by gw again whenever the connection drops
+func runGateway(c *cli.Context, gw *gateway.Gateway) error {
+	return relay(c, func() error {
+		return gw.Run(c.Context, apiClient)
+	}, gw.Resubscribe)
+}
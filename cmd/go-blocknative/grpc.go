@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	"github.com/bonedaddy/go-blocknative/grpcapi"
+	"github.com/bonedaddy/go-blocknative/grpcapi/pb"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+)
+
+func grpcCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "grpc",
+		Usage:       "serve subscriptions as streaming grpc calls",
+		Description: "the service is defined in grpcapi/pb/blocknative.proto",
+		Before:      connect,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve grpc on",
+				Value: "127.0.0.1:9090",
+			},
+			&cli.IntFlag{
+				Name:  "buffer.size",
+				Usage: "number of events buffered for each call",
+				Value: client.DefaultBufferSize,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			defer apiClient.Close()
+			gw := gateway.New(apiClient, func() client.BaseMessage {
+				return baseMessage(c)
+			}, client.BufferOpts{Size: c.Int("buffer.size"), Policy: client.DropOldest})
+			defer gw.Close()
+			lis, err := net.Listen("tcp", c.String("listen"))
+			if err != nil {
+				return err
+			}
+			srv := grpc.NewServer()
+			pb.RegisterBlocknativeServer(srv, grpcapi.New(gw))
+			return serveWhile(c, func() error {
+				return runGateway(c, gw)
+			}, func(ctx context.Context) error {
+				go func() {
+					<-ctx.Done()
+					srv.GracefulStop()
+				}()
+				log.Println("listening on", lis.Addr())
+				return srv.Serve(lis)
+			})
+		},
+	}
+}
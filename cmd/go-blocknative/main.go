@@ -5,7 +5,6 @@ import (
 	"os"
 
 	"github.com/bonedaddy/go-blocknative/client"
-	"github.com/gorilla/websocket"
 	"github.com/urfave/cli/v2"
 )
 
@@ -13,93 +12,115 @@ var (
 	apiClient *client.Client
 )
 
+// connect opens and initializes the api connection used by commands talking to blocknative directly
+func connect(c *cli.Context) error {
+	opts, err := clientOpts(c)
+	if err != nil {
+		return err
+	}
+	return dial(c, opts)
+}
+
+// dial opens and initializes the api connection with opts
+func dial(c *cli.Context, opts client.Opts) (err error) {
+	apiClient, err = client.New(c.Context, opts)
+	if err != nil {
+		return
+	}
+	err = apiClient.Initialize(baseMessage(c))
+	return
+}
+
+// clientOpts returns the connection options set by the global flags, resolving the profile's api key
+func clientOpts(c *cli.Context) (client.Opts, error) {
+	if err := resolveAPIKey(c); err != nil {
+		return client.Opts{}, err
+	}
+	return client.Opts{
+		Scheme: c.String("scheme"),
+		Host:   c.String("host"),
+		Path:   c.String("api.path"),
+		APIKey: c.String("api.key"),
+	}, nil
+}
+
+// baseMessage returns a base message for the selected system and network
+func baseMessage(c *cli.Context) client.BaseMessage {
+	return client.NewBaseMessage(c.String("api.key"), c.String("system"), c.String("network"))
+}
+
+// globalFlags select the connection and network of every command
+var globalFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "api.key",
+		EnvVars: []string{"BLOCKNATIVE_DAPP_ID"},
+		Usage:   "blocknative api key",
+	},
+	&cli.StringFlag{
+		Name:  "address",
+		Usage: "address to use when subscribing to events",
+		Value: "0xfa6de2697D59E88Ed7Fc4dFE5A33daC43565ea41",
+	},
+	&cli.StringFlag{
+		Name:  "tx.hash",
+		Usage: "transaction hash to use when subscribing to events",
+	},
+	&cli.StringFlag{
+		Name:  "scheme",
+		Usage: "connection scheme to use",
+		Value: "wss",
+	},
+	&cli.StringFlag{
+		Name:  "host",
+		Usage: "host to connect to",
+		Value: "api.blocknative.com",
+	},
+	&cli.StringFlag{
+		Name:  "api.path",
+		Usage: "api path to use",
+		Value: "/v0",
+	},
+	&cli.StringFlag{
+		Name:  "system",
+		Usage: "blockchain system to monitor",
+		Value: "ethereum",
+	},
+	&cli.StringFlag{
+		Name:  "network",
+		Usage: "network to monitor, by name such as goerli or matic-main, or by chain id",
+		Value: "main",
+	},
+	&cli.StringFlag{
+		Name:    "profile",
+		EnvVars: []string{"BLOCKNATIVE_PROFILE"},
+		Usage:   "named environment from the profiles file, defaulting to the file's default profile",
+	},
+	&cli.StringFlag{
+		Name:  "profiles.file",
+		Usage: "yaml file of named environments",
+		Value: defaultProfilesFile(),
+	},
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "go-blocknative"
 	app.Usage = "cli for interacting with blocknative api"
-	app.Before = func(c *cli.Context) (err error) {
-		apiClient, err = client.New(c.Context, client.Opts{
-			Scheme: c.String("scheme"),
-			Host:   c.String("host"),
-			Path:   c.String("api.path"),
-			APIKey: c.String("api.key"),
-		})
-		if err != nil {
-			return
-		}
-		err = apiClient.Initialize(client.NewBaseMessageMainnet(c.String("api.key")))
-		return
-	}
-	app.Flags = []cli.Flag{
-		&cli.StringFlag{
-			Name:    "api.key",
-			EnvVars: []string{"BLOCKNATIVE_DAPP_ID"},
-			Usage:   "blocknative api key",
-		},
-		&cli.StringFlag{
-			Name:  "address",
-			Usage: "address to use when subscribing to events",
-			Value: "0xfa6de2697D59E88Ed7Fc4dFE5A33daC43565ea41",
-		},
-		&cli.StringFlag{
-			Name:  "tx.hash",
-			Usage: "transaction hash to use when subscribing to events",
-		},
-		&cli.StringFlag{
-			Name:  "scheme",
-			Usage: "connection scheme to use",
-			Value: "wss",
-		},
-		&cli.StringFlag{
-			Name:  "host",
-			Usage: "host to connect to",
-			Value: "api.blocknative.com",
-		},
-		&cli.StringFlag{
-			Name:  "api.path",
-			Usage: "api path to use",
-			Value: "/v0",
-		},
-	}
+	app.Flags = globalFlags
+	app.Before = applyProfile
 	app.Commands = cli.Commands{
-		&cli.Command{
-			Name:    "subscribe",
-			Aliases: []string{"sub"},
-			Usage:   "event subscription commands",
-			Subcommands: cli.Commands{
-				&cli.Command{
-					Name:  "address",
-					Usage: "subscribe to events based on addresse",
-					Action: func(c *cli.Context) error {
-						if err := apiClient.WriteJSON(client.NewAddressSubscribe(
-							client.NewBaseMessageMainnet(
-								c.String("api.key"),
-							),
-							c.String("address"),
-						)); err != nil {
-							return err
-						}
-						for {
-							var out client.EthTxPayload
-							if err := apiClient.ReadJSON(&out); err != nil {
-								// used to ignore the following event
-								// websocket: close 1005 (no status)
-								if websocket.IsUnexpectedCloseError(err, 1005) {
-									log.Println("receive unexpected close, exiting: ", err)
-									break
-								} else {
-									log.Println("receive expected close message: ", err)
-									continue
-								}
-							}
-							log.Printf("receive message:\n%+v\n", out)
-						}
-						defer apiClient.Close()
-						return nil
-					},
-				},
-			},
-		},
+		subscribeCommand(),
+		unsubscribeCommand(),
+		serveCommand(),
+		gatewayCommand(),
+		grpcCommand(),
+		mempoolCommand(),
+		daemonCommand(),
+		shellCommand(),
+		tuiCommand(),
+		decodeCommand(),
+		replayCommand(),
+		rulesCommand(),
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// newContext returns a context with flags parsed from args
+func newContext(t *testing.T, flags []cli.Flag, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	applied := make([]cli.Flag, len(flags))
+	for i, f := range flags {
+		// values from the environment are stored in the flag, so flags shared between tests are copied
+		if sf, ok := f.(*cli.StringFlag); ok {
+			copied := *sf
+			f = &copied
+		}
+		require.NoError(t, f.Apply(set))
+		applied[i] = f
+	}
+	require.NoError(t, set.Parse(args))
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	c.Command = &cli.Command{Flags: applied}
+	return c
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/mempool"
+	"github.com/urfave/cli/v2"
+)
+
+func mempoolCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "mempool",
+		Usage: "mirror pending transactions of watched addresses and serve them as read-only json",
+		Description: "GET /pending?from=, /tx/{hash}, /address/{addr}/nonces and /stats " +
+			"to inspect what blocknative currently reports as pending",
+		Before: connect,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve the api on",
+				Value: "127.0.0.1:8082",
+			},
+			&cli.StringSliceFlag{
+				Name:  "watch",
+				Usage: "addresses to mirror, defaults to --address",
+			},
+			&cli.DurationFlag{
+				Name:  "prune.after",
+				Usage: "evict transactions without events for this long, 0 disables pruning",
+				Value: time.Hour,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			defer apiClient.Close()
+			addresses := c.StringSlice("watch")
+			if len(addresses) == 0 {
+				addresses = []string{c.String("address")}
+			}
+			subscribe := func() error {
+				for _, addr := range addresses {
+					msg, err := client.NewAddressSubscribeChecked(baseMessage(c), addr)
+					if err != nil {
+						return err
+					}
+					if err := apiClient.WriteJSON(msg); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if err := subscribe(); err != nil {
+				return err
+			}
+			pool := mempool.New()
+			if after := c.Duration("prune.after"); after > 0 {
+				go func() {
+					ticker := time.NewTicker(time.Minute)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-c.Context.Done():
+							return
+						case now := <-ticker.C:
+							pool.Prune(now.Add(-after))
+						}
+					}
+				}()
+			}
+			// the mirror is only served while it is kept up to date
+			return serveWhile(c, func() error {
+				return relay(c, func() error {
+					return pool.Run(c.Context, apiClient)
+				}, subscribe)
+			}, func(ctx context.Context) error {
+				return listenAndServe(ctx, c.String("listen"), pool)
+			})
+		},
+	}
+}
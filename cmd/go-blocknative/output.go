@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultTableFields are the columns printed by --output table without --fields
+var defaultTableFields = []string{"time", "status", "hash", "from", "to", "value", "gas"}
+
+var outputFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "output",
+		Usage: "output format, one of ndjson, table or template",
+		Value: "ndjson",
+	},
+	&cli.StringFlag{
+		Name:  "fields",
+		Usage: "comma separated fields to print, either " + strings.Join(fieldNames(), ", ") + " or a dotted json path such as event.transaction.input",
+	},
+	&cli.StringFlag{
+		Name:  "template",
+		Usage: "go text/template executed for every event with --output template, eg '{{.Event.Transaction.Hash}} {{eth .Event.Transaction.Value}}'",
+	},
+}
+
+// field is a named value extracted from an event
+type field struct {
+	path   string
+	get    func(ev *client.Event) interface{}
+	format func(v interface{}) string
+	width  int
+}
+
+var fields = map[string]field{
+	"time":    {path: "timeStamp", format: formatTime, width: 8},
+	"event":   {path: "event.eventCode", width: 12},
+	"status":  {path: "event.transaction.status", width: 10},
+	"hash":    {path: "event.transaction.hash", width: 66},
+	"from":    {path: "event.transaction.from", width: 42},
+	"to":      {path: "event.transaction.to", width: 42},
+	"nonce":   {path: "event.transaction.nonce", width: 6},
+	"value":   {path: "event.transaction.value", format: formatEth, width: 12},
+	"watched": {path: "event.transaction.watchedAddress", width: 42},
+	"gas": {
+		// the fee cap of dynamic fee transactions and the gas price of legacy ones
+		get: func(ev *client.Event) interface{} {
+			if tx := ev.Payload.Event.Transaction; tx.MaxFeePerGas != "" {
+				return tx.MaxFeePerGas
+			}
+			return ev.Payload.Event.Transaction.GasPrice
+		},
+		format: formatGwei,
+		width:  10,
+	},
+}
+
+// fieldNames returns the names of fields, sorted
+func fieldNames() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printer writes events to the output
+type printer interface {
+	Print(ev *client.Event) error
+}
+
+// newPrinter returns the printer selected by the output flags
+func newPrinter(c *cli.Context, w io.Writer) (printer, error) {
+	var names []string
+	if v := c.String("fields"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	switch c.String("output") {
+	case "ndjson":
+		return &ndjsonPrinter{w: w, fields: names}, nil
+	case "table":
+		if len(names) == 0 {
+			names = defaultTableFields
+		}
+		return &tablePrinter{w: w, fields: names}, nil
+	case "template":
+		text := c.String("template")
+		if text == "" {
+			return nil, errors.New("--template must be set with --output template")
+		}
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		tmpl, err := template.New("output").Funcs(template.FuncMap{
+			"eth":  formatEth,
+			"gwei": formatGwei,
+			"json": func(v interface{}) (string, error) {
+				data, err := json.Marshal(v)
+				return string(data), err
+			},
+		}).Parse(text)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing template")
+		}
+		return &templatePrinter{w: w, tmpl: tmpl}, nil
+	}
+	return nil, errors.Errorf("unknown output format:%v", c.String("output"))
+}
+
+// ndjsonPrinter writes each event as a line of json, projected to fields if any are set
+type ndjsonPrinter struct {
+	w      io.Writer
+	fields []string
+}
+
+func (p *ndjsonPrinter) Print(ev *client.Event) error {
+	if len(p.fields) == 0 {
+		_, err := fmt.Fprintf(p.w, "%s\n", bytes.TrimSpace(ev.Raw))
+		return err
+	}
+	doc, err := document(ev)
+	if err != nil {
+		return err
+	}
+	out := make(map[string]interface{}, len(p.fields))
+	for _, name := range p.fields {
+		out[name] = value(ev, doc, name)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+// tablePrinter writes each event as a row of fixed width columns under a single header
+type tablePrinter struct {
+	w      io.Writer
+	fields []string
+	header bool
+}
+
+func (p *tablePrinter) Print(ev *client.Event) error {
+	if !p.header {
+		cols := make([]string, len(p.fields))
+		for i, name := range p.fields {
+			cols[i] = pad(strings.ToUpper(name), fields[name].width)
+		}
+		if _, err := fmt.Fprintln(p.w, strings.TrimRight(strings.Join(cols, "  "), " ")); err != nil {
+			return err
+		}
+		p.header = true
+	}
+	doc, err := document(ev)
+	if err != nil {
+		return err
+	}
+	cols := make([]string, len(p.fields))
+	for i, name := range p.fields {
+		f := fields[name]
+		v := value(ev, doc, name)
+		switch {
+		case v == nil:
+			cols[i] = pad("-", f.width)
+		case f.format != nil:
+			cols[i] = pad(f.format(v), f.width)
+		default:
+			cols[i] = pad(fmt.Sprint(v), f.width)
+		}
+	}
+	_, err = fmt.Fprintln(p.w, strings.TrimRight(strings.Join(cols, "  "), " "))
+	return err
+}
+
+// templatePrinter executes a template with each event's payload
+type templatePrinter struct {
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func (p *templatePrinter) Print(ev *client.Event) error {
+	return p.tmpl.Execute(p.w, ev.Payload)
+}
+
+// document decodes an event into a generic json document
+func document(ev *client.Event) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(ev.Raw))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "decoding event")
+	}
+	return doc, nil
+}
+
+// value returns a named field or the value at a dotted json path, nil if it is missing
+func value(ev *client.Event, doc map[string]interface{}, name string) interface{} {
+	path := name
+	if f, ok := fields[name]; ok {
+		if f.get != nil {
+			return f.get(ev)
+		}
+		path = f.path
+	}
+	var cur interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+func pad(s string, width int) string {
+	if width == 0 {
+		width = 20
+	}
+	if len(s) >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+func formatTime(v interface{}) string {
+	t, err := time.Parse(time.RFC3339Nano, fmt.Sprint(v))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return t.Format("15:04:05")
+}
+
+// formatEth formats a wei amount in ether
+func formatEth(v interface{}) string {
+	return formatUnits(v, params.Ether, 6)
+}
+
+// formatGwei formats a wei amount in gwei
+func formatGwei(v interface{}) string {
+	return formatUnits(v, params.GWei, 2)
+}
+
+func formatUnits(v interface{}, unit int64, prec int) string {
+	wei, ok := new(big.Float).SetString(fmt.Sprint(v))
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	return wei.Quo(wei, new(big.Float).SetInt64(unit)).Text('f', prec)
+}
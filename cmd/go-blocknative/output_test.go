@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+const outputEvent = `{"timeStamp":"2021-09-14T10:00:01.000Z","event":{"eventCode":"txPool","transaction":{` +
+	`"hash":"0xa1","from":"0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41","to":"0x7a250d5630b4cf539739df2c5dacb4c659f2488d",` +
+	`"value":"1500000000000000000","nonce":42,"gasPrice":"90000000000","maxFeePerGas":"120000000000","input":"0x"}}}`
+
+func TestFieldNames(t *testing.T) {
+	names := fieldNames()
+	require.True(t, sort.StringsAreSorted(names))
+	require.Len(t, names, len(fields))
+	for _, name := range names {
+		require.Contains(t, fields, name)
+	}
+}
+
+func TestValue(t *testing.T) {
+	ev, err := client.NewEvent(time.Now(), []byte(outputEvent))
+	require.NoError(t, err)
+	doc, err := document(ev)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name string
+		want interface{}
+	}{
+		{name: "hash", want: "0xa1"},
+		{name: "event", want: "txPool"},
+		{name: "nonce", want: json.Number("42")},
+		// the fee cap is preferred over the gas price
+		{name: "gas", want: "120000000000"},
+		{name: "event.transaction.input", want: "0x"},
+		{name: "event.transaction.missing", want: nil},
+		{name: "event.eventCode.deeper", want: nil},
+	} {
+		require.Equal(t, tc.want, value(ev, doc, tc.name), tc.name)
+	}
+}
+
+func TestNewPrinter(t *testing.T) {
+	ev, err := client.NewEvent(time.Now(), []byte(outputEvent))
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name string
+		args []string
+		// events printed, defaults to one
+		prints int
+		want   string
+		err    bool
+	}{
+		{name: "raw", want: outputEvent + "\n"},
+		{
+			name: "projected",
+			args: []string{"--fields", "hash, value,event.transaction.input,watched"},
+			want: `{"event.transaction.input":"0x","hash":"0xa1","value":"1500000000000000000","watched":null}` + "\n",
+		},
+		{
+			name: "table",
+			args: []string{"--output", "table", "--fields", "status,nonce,value,gas"},
+			// the header is only printed once
+			prints: 2,
+			want: "STATUS      NONCE   VALUE         GAS\n" +
+				"-           42      1.500000      120.00\n" +
+				"-           42      1.500000      120.00\n",
+		},
+		{
+			name:   "template",
+			args:   []string{"--output", "template", "--template", "{{.Event.Transaction.Hash}} {{eth .Event.Transaction.Value}}"},
+			prints: 2,
+			want:   "0xa1 1.500000\n0xa1 1.500000\n",
+		},
+		{name: "template missing", args: []string{"--output", "template"}, err: true},
+		{name: "unknown", args: []string{"--output", "xml"}, err: true},
+	} {
+		var buf bytes.Buffer
+		out, err := newPrinter(newContext(t, outputFlags, tc.args...), &buf)
+		if tc.err {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		for i := 0; i < tc.prints || i == 0; i++ {
+			require.NoError(t, out.Print(ev), tc.name)
+		}
+		require.Equal(t, tc.want, buf.String(), tc.name)
+	}
+}
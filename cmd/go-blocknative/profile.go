@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// profiles is the profiles file, holding named environments
+//
+//	default: goerli
+//	profiles:
+//	  goerli:
+//	    network: goerli
+//	    apiKeyEnv: BLOCKNATIVE_GOERLI_KEY
+type profiles struct {
+	// Default is the profile used when --profile is not given
+	Default  string             `yaml:"default"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// profile is a named environment, unset values fall back to the flag defaults
+type profile struct {
+	Scheme  string `yaml:"scheme"`
+	Host    string `yaml:"host"`
+	Path    string `yaml:"path"`
+	System  string `yaml:"system"`
+	Network string `yaml:"network"`
+	// the api key is referenced rather than stored, read from an environment variable or a file
+	APIKeyEnv  string `yaml:"apiKeyEnv"`
+	APIKeyFile string `yaml:"apiKeyFile"`
+}
+
+// defaultProfilesFile returns ~/.config/go-blocknative/config.yaml
+func defaultProfilesFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "go-blocknative", "config.yaml")
+}
+
+// applyProfile sets the connection flags from the selected profile, flags
+// given on the command line take precedence, and resolves --network to the
+// name blocknative expects. The profile's api key is only resolved by
+// resolveAPIKey, so commands which never connect work without it.
+func applyProfile(c *cli.Context) error {
+	_, p, err := selectProfile(c)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		if err := setFlags(c, map[string]string{
+			"scheme":   p.Scheme,
+			"host":     p.Host,
+			"api.path": p.Path,
+			"system":   p.System,
+			"network":  p.Network,
+		}); err != nil {
+			return err
+		}
+	}
+	network, err := client.ParseNetwork(c.String("network"))
+	if err != nil {
+		return err
+	}
+	return c.Set("network", network)
+}
+
+// resolveAPIKey sets --api.key from the selected profile for commands that
+// connect to blocknative. A profile's api key replaces BLOCKNATIVE_DAPP_ID,
+// as the profile names the environment the key belongs to.
+func resolveAPIKey(c *cli.Context) error {
+	name, p, err := selectProfile(c)
+	if err != nil || p == nil {
+		return err
+	}
+	apiKey, err := p.apiKey()
+	if err != nil {
+		return errors.Wrapf(err, "profile %v", name)
+	}
+	return setFlags(c, map[string]string{"api.key": apiKey})
+}
+
+// selectProfile returns the profile named by --profile or the file's default, nil if there is none
+func selectProfile(c *cli.Context) (string, *profile, error) {
+	path := c.String("profiles.file")
+	if path == "" {
+		return "", nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !c.IsSet("profile") && !c.IsSet("profiles.file") {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, errors.Wrap(err, "reading profiles")
+	}
+	var file profiles
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return "", nil, errors.Wrap(err, "decoding profiles")
+	}
+	name := c.String("profile")
+	if name == "" {
+		if name = file.Default; name == "" {
+			return "", nil, nil
+		}
+	}
+	p, ok := file.Profiles[name]
+	if !ok {
+		return "", nil, errors.Errorf("unknown profile:%v", name)
+	}
+	return name, &p, nil
+}
+
+// setFlags sets the global flags to the non-empty values unless they were given on the command line
+func setFlags(c *cli.Context, values map[string]string) error {
+	// flags set through the environment are not visited, so the profile replaces them
+	given := make(map[string]bool)
+	for _, flag := range c.FlagNames() {
+		given[flag] = true
+	}
+	// the global flags belong to the root context, which subcommands can not set through their own
+	lineage := c.Lineage()
+	root := lineage[len(lineage)-1]
+	for flag, value := range values {
+		if value == "" || given[flag] {
+			continue
+		}
+		if err := root.Set(flag, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiKey resolves the profile's api key reference, returning "" if there is none
+func (p profile) apiKey() (string, error) {
+	switch {
+	case p.APIKeyEnv != "":
+		key := os.Getenv(p.APIKeyEnv)
+		if key == "" {
+			return "", errors.Errorf("api key environment variable not set:%v", p.APIKeyEnv)
+		}
+		return key, nil
+	case p.APIKeyFile != "":
+		data, err := ioutil.ReadFile(p.APIKeyFile)
+		if err != nil {
+			return "", errors.Wrap(err, "reading api key")
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestApplyProfile(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "polygon.key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("polygon-key\n"), 0600))
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+default: goerli
+profiles:
+  goerli:
+    network: "5"
+    apiKeyEnv: TEST_GOERLI_KEY
+  polygon:
+    host: polygon.example.com
+    network: matic-main
+    apiKeyFile: `+keyFile+`
+  unset:
+    apiKeyEnv: TEST_UNSET_KEY
+  keyless:
+    network: goerli
+`), 0644))
+	t.Setenv("TEST_GOERLI_KEY", "goerli-key")
+	t.Setenv("BLOCKNATIVE_PROFILE", "")
+
+	for _, tc := range []struct {
+		name string
+		env  string
+		args []string
+		// expected flag values, or err
+		want map[string]string
+		err  bool
+	}{
+		{
+			name: "no profiles file",
+			args: []string{"--profiles.file", "", "--network", "100"},
+			want: map[string]string{"network": "xdai", "host": "api.blocknative.com", "api.key": ""},
+		},
+		{
+			name: "default profile",
+			args: []string{"--profiles.file", file},
+			want: map[string]string{"network": "goerli", "api.key": "goerli-key"},
+		},
+		{
+			name: "selected profile",
+			args: []string{"--profiles.file", file, "--profile", "polygon"},
+			want: map[string]string{"network": "matic-main", "host": "polygon.example.com", "api.key": "polygon-key"},
+		},
+		{
+			name: "command line wins",
+			args: []string{"--profiles.file", file, "--network", "main", "--api.key", "flag-key"},
+			want: map[string]string{"network": "main", "api.key": "flag-key"},
+		},
+		{
+			name: "profile key replaces the environment",
+			env:  "env-key",
+			args: []string{"--profiles.file", file},
+			want: map[string]string{"api.key": "goerli-key"},
+		},
+		{
+			name: "environment without a profile key",
+			env:  "env-key",
+			args: []string{"--profiles.file", file, "--profile", "keyless"},
+			want: map[string]string{"network": "goerli", "api.key": "env-key"},
+		},
+		{name: "missing file given", args: []string{"--profiles.file", filepath.Join(dir, "missing.yaml"), "--profile", "goerli"}, err: true},
+		{name: "unknown profile", args: []string{"--profiles.file", file, "--profile", "nope"}, err: true},
+		{name: "key not exported", args: []string{"--profiles.file", file, "--profile", "unset"}, err: true},
+		{name: "unknown network", args: []string{"--profiles.file", "", "--network", "nowhere"}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("BLOCKNATIVE_DAPP_ID", tc.env)
+			c := newContext(t, globalFlags, tc.args...)
+			err := applyProfile(c)
+			if err == nil {
+				err = resolveAPIKey(c)
+			}
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for flag, want := range tc.want {
+				require.Equal(t, want, c.String(flag), flag)
+			}
+		})
+	}
+
+	// commands that never connect do not need the profile's api key
+	c := newContext(t, globalFlags, "--profiles.file", file, "--profile", "unset")
+	require.NoError(t, applyProfile(c))
+	require.Error(t, resolveAPIKey(c))
+
+	// subcommands resolve the key into the global flag
+	c = newContext(t, globalFlags, "--profiles.file", file)
+	sub := cli.NewContext(c.App, flag.NewFlagSet("address", flag.ContinueOnError), c)
+	require.NoError(t, resolveAPIKey(sub))
+	require.Equal(t, "goerli-key", sub.String("api.key"))
+}
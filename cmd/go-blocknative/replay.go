@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/calldata"
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/daemon"
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/bonedaddy/go-blocknative/sink"
+	"github.com/bonedaddy/go-blocknative/webhook"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+func replayCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "replay",
+		Usage:     "push a recorded session through the filtering, decoding and sink pipeline used live",
+		ArgsUsage: "<recording>",
+		Description: "events of the recording are filtered, decoded and written to the sinks the same way the daemon " +
+			"writes live events, without connecting to blocknative. without any sink they are printed like subscribe does",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "speed",
+				Usage: "pace relative to the recording, such as 10x, or max for as fast as possible",
+				Value: "1x",
+			},
+			&cli.StringSliceFlag{
+				Name:  "sink",
+				Usage: "sink urls: file:<path>, sqlite:<path>, rules:<path>, webhook://<host>/<path> or webhook+https://<host>/<path>",
+			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "daemon config file whose sinks are written to as well",
+			},
+			&cli.StringFlag{
+				Name:  "filter",
+				Usage: "only replay events matching this jsql filter",
+			},
+			&cli.BoolFlag{
+				Name:  "decode",
+				Usage: "add a contract call decoded from the input to events without one, implied by --abi",
+			},
+			&cli.StringSliceFlag{
+				Name:  "abi",
+				Usage: "json abi files used for decoding",
+			},
+			&cli.StringFlag{
+				Name:  "selectors",
+				Usage: "selector database file used for decoding",
+				Value: defaultSelectorsFile(),
+			},
+			&cli.StringFlag{
+				Name:  "state.dir",
+				Usage: "directory holding webhook queues, defaults to a temporary directory removed when done",
+			},
+			&cli.DurationFlag{
+				Name:  "drain.timeout",
+				Usage: "how long queued webhook deliveries are waited for once the recording ends",
+				Value: time.Second * 30,
+			},
+		}, outputFlags...),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return errors.New("usage: replay <recording>")
+			}
+			speed, err := parseSpeed(c.String("speed"))
+			if err != nil {
+				return err
+			}
+			var match *filter.Filter
+			if v := c.String("filter"); v != "" {
+				if match, err = filter.Parse([]byte(v)); err != nil {
+					return err
+				}
+			}
+			var decoder *calldata.Decoder
+			if c.Bool("decode") || len(c.StringSlice("abi")) > 0 {
+				if decoder, err = newDecoder(c); err != nil {
+					return err
+				}
+			}
+			rp, err := client.OpenReplay(c.Args().First(), speed)
+			if err != nil {
+				return err
+			}
+			defer rp.Close()
+			// closing the replayer stops it waiting for the next frame
+			go func() {
+				<-c.Context.Done()
+				rp.Close()
+			}()
+			specs, err := replaySinks(c)
+			if err != nil {
+				return err
+			}
+			var (
+				out    sink.Sink
+				failed uint64
+			)
+			if len(specs) == 0 {
+				p, err := newPrinter(c, os.Stdout)
+				if err != nil {
+					return err
+				}
+				out = printerSink{p}
+			} else {
+				stateDir := c.String("state.dir")
+				if stateDir == "" {
+					if stateDir, err = ioutil.TempDir("", "go-blocknative-replay-"); err != nil {
+						return err
+					}
+					defer os.RemoveAll(stateDir)
+				}
+				fan, err := openReplaySinks(specs, stateDir, c.Duration("drain.timeout"), &failed)
+				if err != nil {
+					return err
+				}
+				out = fan
+			}
+
+			counts, err := replayEvents(c.Context, rp, out, match, decoder)
+			if err != nil {
+				out.Close()
+				return err
+			}
+			err = out.Close()
+			log.Printf("replayed %d events to %d sinks, %d filtered out, %d not decoded, %d deliveries failed",
+				counts.replayed, len(specs), counts.filtered, counts.undecoded, atomic.LoadUint64(&failed))
+			return err
+		},
+	}
+}
+
+// replayCounts tallies what happened to the events of a recording
+type replayCounts struct {
+	replayed, filtered, undecoded int
+}
+
+// replayEvents writes the transaction events of a recording to out at the
+// replayer's pace until it ends or ctx is done. Events carry their recorded
+// time, so windows and debouncing of rules behave as they did live.
+func replayEvents(ctx context.Context, rp *client.Replayer, out sink.Sink, match *filter.Filter, decoder *calldata.Decoder) (replayCounts, error) {
+	var counts replayCounts
+	for ctx.Err() == nil {
+		frame, err := rp.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, err
+		}
+		ev, err := client.NewEvent(frame.Time, frame.Data)
+		if err != nil {
+			log.Println("skipping malformed message: ", err)
+			continue
+		}
+		if ev.Payload.Event.Transaction.Hash == "" {
+			continue
+		}
+		if match != nil && !match.Match(ev) {
+			counts.filtered++
+			continue
+		}
+		if decoder != nil {
+			if annotated, err := decoder.Annotate(ev); err == nil {
+				ev = annotated
+			} else {
+				counts.undecoded++
+			}
+		}
+		if err := out.Write(ctx, ev); err != nil {
+			return counts, err
+		}
+		counts.replayed++
+	}
+	return counts, nil
+}
+
+// parseSpeed parses a replay speed such as 10x, 0.5 or max, max being 0
+func parseSpeed(v string) (float64, error) {
+	if v == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(v, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, errors.Errorf("invalid speed:%v, expected a positive factor such as 10x or max", v)
+	}
+	return speed, nil
+}
+
+// replaySinks returns the sinks given with --sink followed by those of --config,
+// numbering sinks that would share a name
+func replaySinks(c *cli.Context) ([]daemon.SinkSpec, error) {
+	var specs []daemon.SinkSpec
+	for _, raw := range c.StringSlice("sink") {
+		s, err := daemon.ParseSinkURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, s)
+	}
+	if path := c.String("config"); path != "" {
+		spec, err := daemon.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec.Sinks...)
+	}
+	names := make(map[string]bool, len(specs))
+	for i, s := range specs {
+		name := s.Name
+		for n := 2; names[name]; n++ {
+			name = s.Name + "-" + strconv.Itoa(n)
+		}
+		names[name] = true
+		specs[i].Name = name
+	}
+	return specs, nil
+}
+
+// openReplaySinks opens every sink behind a fan-out that blocks rather than
+// drops events, since a replay can outpace the sinks. failed counts the
+// events given up on
+func openReplaySinks(specs []daemon.SinkSpec, stateDir string, drainTimeout time.Duration, failed *uint64) (*sink.FanOut, error) {
+	fan := sink.NewFanOut(func(name string, ev *client.Event, err error) {
+		atomic.AddUint64(failed, 1)
+		log.Printf("sink %s dropped event %s: %v", name, ev.Payload.Event.Transaction.Hash, err)
+	})
+	for _, s := range specs {
+		out, opts, err := daemon.OpenSink(s, stateDir)
+		if err != nil {
+			fan.Close()
+			return nil, errors.Wrapf(err, "opening sink %v", s.Name)
+		}
+		if fw, ok := out.(*webhook.Forwarder); ok {
+			out = drainOnClose{Forwarder: fw, timeout: drainTimeout}
+		}
+		opts.Buffer = client.BufferOpts{Policy: client.Block}
+		if err := fan.Add(s.Name, out, opts); err != nil {
+			fan.Close()
+			return nil, err
+		}
+	}
+	return fan, nil
+}
+
+// drainOnClose lets queued webhook deliveries finish before the forwarder is closed
+type drainOnClose struct {
+	*webhook.Forwarder
+	timeout time.Duration
+}
+
+func (d drainOnClose) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	err := d.Drain(ctx)
+	if closeErr := d.Forwarder.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// printerSink prints events when no sink is given
+type printerSink struct {
+	printer
+}
+
+func (p printerSink) Write(ctx context.Context, ev *client.Event) error {
+	return p.Print(ev)
+}
+
+func (p printerSink) Close() error {
+	return nil
+}
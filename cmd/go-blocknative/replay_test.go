@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/rules"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// evaluator collects the alerts raised by an engine without running actions
+type evaluator struct {
+	e      *rules.Engine
+	alerts []*rules.Alert
+}
+
+func (ev *evaluator) Write(ctx context.Context, event *client.Event) error {
+	alerts, err := ev.e.Evaluate(event)
+	ev.alerts = append(ev.alerts, alerts...)
+	return err
+}
+
+func (ev *evaluator) Close() error { return ev.e.Close() }
+
+func TestReplayEvents(t *testing.T) {
+	spec, err := rules.Parse([]byte(`
+rules:
+  - name: activity
+    when: hash != ""
+    debounce: 10s
+`))
+	require.NoError(t, err)
+	e, err := rules.New(spec, rules.Opts{})
+	require.NoError(t, err)
+	out := &evaluator{e: e}
+	rp, err := client.OpenReplay(filepath.Join("..", "..", "client", "testdata", "session.ndjson"), 0)
+	require.NoError(t, err)
+	defer rp.Close()
+
+	counts, err := replayEvents(context.Background(), rp, out, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, out.Close())
+	require.Equal(t, replayCounts{replayed: 3}, counts)
+	// events keep their recorded times, so the event 12 seconds later fires again
+	require.Len(t, out.alerts, 2)
+	require.Equal(t, 0, out.alerts[0].Suppressed)
+	require.Equal(t, 1, out.alerts[1].Suppressed)
+	require.Equal(t, "2021-09-14T10:00:13Z", out.alerts[1].Time.UTC().Format(time.RFC3339))
+}
+
+func TestParseSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		speed float64
+		err   bool
+	}{
+		{in: "1x", speed: 1},
+		{in: "10x", speed: 10},
+		{in: "0.5", speed: 0.5},
+		{in: "max", speed: 0},
+		{in: "0x", err: true},
+		{in: "-2x", err: true},
+		{in: "fast", err: true},
+	} {
+		speed, err := parseSpeed(tc.in)
+		if tc.err {
+			require.Error(t, err, tc.in)
+			continue
+		}
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.speed, speed, tc.in)
+	}
+}
+
+func TestReplaySinks(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "daemon.yaml")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`
+sinks:
+  - name: file
+    type: file
+    path: c.ndjson
+  - name: file-2
+    type: file
+    path: d.ndjson
+`), 0644))
+	flags := func() []cli.Flag {
+		return []cli.Flag{&cli.StringSliceFlag{Name: "sink"}, &cli.StringFlag{Name: "config"}}
+	}
+	for _, tc := range []struct {
+		args  []string
+		names []string
+	}{
+		{args: nil, names: nil},
+		{args: []string{"--sink", "file:a.ndjson"}, names: []string{"file"}},
+		{args: []string{"--sink", "file:a.ndjson", "--sink", "file:b.ndjson?name=b"}, names: []string{"file", "b"}},
+		// sinks sharing a name are numbered, skipping names already taken
+		{
+			args:  []string{"--sink", "file:a.ndjson", "--sink", "file:b.ndjson", "--config", config},
+			names: []string{"file", "file-2", "file-3", "file-2-2"},
+		},
+	} {
+		specs, err := replaySinks(newContext(t, flags(), tc.args...))
+		require.NoError(t, err)
+		var names []string
+		for _, s := range specs {
+			names = append(names, s.Name)
+		}
+		require.Equal(t, tc.names, names, "%v", tc.args)
+	}
+	_, err := replaySinks(newContext(t, flags(), "--sink", "ftp://host"))
+	require.Error(t, err)
+}
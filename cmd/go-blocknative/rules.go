@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/rules"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+func rulesCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rules",
+		Usage: "check alerting rules and test them against recordings",
+		Description: "rules are run live by declaring a sink of type rules in the daemon config, " +
+			"or against a recording at its own pace with replay --sink rules:<file>",
+		Subcommands: cli.Commands{
+			&cli.Command{
+				Name:      "check",
+				Usage:     "validate a rules file",
+				ArgsUsage: "<rules>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("usage: rules check <rules>")
+					}
+					spec, err := rules.Load(c.Args().First())
+					if err != nil {
+						return err
+					}
+					for _, r := range spec.Rules {
+						fmt.Printf("%s: %s\n", r.Name, ruleKind(r))
+					}
+					return nil
+				},
+			},
+			&cli.Command{
+				Name:      "test",
+				Usage:     "print the alerts rules raise for a recording, using the recorded times",
+				ArgsUsage: "<rules> <recording>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "run",
+						Usage: "run the actions of the alerts as well",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print a json object per alert",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return errors.New("usage: rules test <rules> <recording>")
+					}
+					spec, err := rules.Load(c.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					e, err := rules.New(spec, rules.Opts{})
+					if err != nil {
+						return err
+					}
+					defer e.Close()
+					rp, err := client.OpenReplay(c.Args().Get(1), 0)
+					if err != nil {
+						return err
+					}
+					defer rp.Close()
+					var events, alerts int
+					for c.Context.Err() == nil {
+						frame, err := rp.Next()
+						if err == io.EOF {
+							break
+						}
+						if err != nil {
+							return err
+						}
+						if frame.Direction != client.Inbound {
+							continue
+						}
+						ev, err := client.NewEvent(frame.Time, frame.Data)
+						if err != nil {
+							log.Println("skipping malformed message: ", err)
+							continue
+						}
+						events++
+						fired, err := e.Evaluate(ev)
+						if err != nil {
+							return err
+						}
+						for _, a := range fired {
+							alerts++
+							if err := printAlert(os.Stdout, c.Bool("json"), a); err != nil {
+								return err
+							}
+							if !c.Bool("run") {
+								continue
+							}
+							if err := e.Run(c.Context, a); err != nil {
+								return err
+							}
+						}
+					}
+					log.Printf("%d messages raised %d alerts", events, alerts)
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// ruleKind describes what a rule matches on: its condition, filters and approvals
+func ruleKind(r rules.RuleSpec) string {
+	var kinds []string
+	if r.When != "" {
+		kinds = append(kinds, "when "+r.When)
+	}
+	if n := len(r.Filters); n > 0 {
+		kinds = append(kinds, fmt.Sprintf("%d filters", n))
+	}
+	if r.Approvals != nil {
+		kinds = append(kinds, "approvals")
+	}
+	return strings.Join(kinds, ", ")
+}
+
+// printAlert writes an alert as a json line or as its time, severity, rule and message
+func printAlert(w io.Writer, asJSON bool, a *rules.Alert) error {
+	if asJSON {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s %s %s\n", a.Time.UTC().Format(time.RFC3339), a.Severity, a.Rule, a.Message)
+	return err
+}
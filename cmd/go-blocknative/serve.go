@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/proxy"
+	"github.com/urfave/cli/v2"
+)
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "run a local proxy letting many clients share blocknative connections",
+		Description: "serves blocknative's v0 websocket protocol, point a client's host at the proxy instead of blocknative. " +
+			"subscriptions are shared across clients and events are only sent to the clients that asked for them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve the proxy on",
+				Value: "127.0.0.1:8080",
+			},
+			&cli.StringFlag{
+				Name:  "listen.path",
+				Usage: "path to serve the websocket endpoint on",
+				Value: "/v0",
+			},
+			&cli.IntFlag{
+				Name:  "buffer.size",
+				Usage: "number of events buffered for each client",
+				Value: client.DefaultBufferSize,
+			},
+			&cli.StringFlag{
+				Name:  "buffer.policy",
+				Usage: "what to do when a client's buffer is full (drop-oldest, drop-newest, spill)",
+				Value: client.DropOldest.String(),
+			},
+		},
+		Action: func(c *cli.Context) error {
+			policy, err := client.ParsePolicy(c.String("buffer.policy"))
+			if err != nil {
+				return err
+			}
+			upstream, err := clientOpts(c)
+			if err != nil {
+				return err
+			}
+			px, err := proxy.New(c.Context, proxy.Opts{
+				Upstream: upstream,
+				Buffer:   client.BufferOpts{Size: c.Int("buffer.size"), Policy: policy},
+			})
+			if err != nil {
+				return err
+			}
+			defer px.Close()
+			mux := http.NewServeMux()
+			mux.Handle(c.String("listen.path"), px)
+			return listenAndServe(c.Context, c.String("listen"), mux)
+		},
+	}
+}
+
+// serveWhile runs serve until feed returns, cancelling serve's context and
+// returning feed's error so a server never outlives the events behind it
+func serveWhile(c *cli.Context, feed func() error, serve func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	fed := make(chan error, 1)
+	go func() {
+		fed <- feed()
+		cancel()
+	}()
+	err := serve(ctx)
+	select {
+	case ferr := <-fed:
+		if ferr != nil {
+			return ferr
+		}
+	default:
+	}
+	return err
+}
+
+// listenAndServe serves handler on addr until ctx is done
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Println("listening on", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
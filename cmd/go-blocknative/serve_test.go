@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeWhile(t *testing.T) {
+	serve := func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}
+	// the server is stopped when the events behind it fail
+	c := newContext(t, nil)
+	require.EqualError(t, serveWhile(c, func() error { return errors.New("reconnecting: refused") }, serve), "reconnecting: refused")
+
+	// a feed ending with the command keeps the error of the server
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Context = ctx
+	cancel()
+	require.NoError(t, serveWhile(c, func() error { return nil }, serve))
+	require.EqualError(t, serveWhile(c, func() error {
+		<-ctx.Done()
+		return nil
+	}, func(ctx context.Context) error { return errors.New("listen: address in use") }), "listen: address in use")
+}
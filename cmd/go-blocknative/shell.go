@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/bonedaddy/go-blocknative/mempool"
+	"github.com/peterh/liner"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// maxCompletions bounds how many addresses seen in events are offered for completion
+const maxCompletions = 1000
+
+const shellHelp = `watch <address|hash>...     subscribe to addresses or transactions
+unwatch <address|hash>...   unsubscribe from addresses or transactions
+config put <file>           put the json config in file, eg {"scope":"0x...","filters":[{"status":"pending"}]}
+filter [<jsql>|off]         only show events matching a jsql filter, without arguments print it
+pause                       stop showing events, they are still counted and tracked
+resume                      show events again
+show pending [address]      pending transactions, of every sender or only of address
+stats                       event, filter and mempool counters
+help                        this help
+exit                        leave the shell`
+
+var shellCommands = []string{"watch", "unwatch", "config put", "filter", "pause", "resume", "show pending", "stats", "help", "exit"}
+
+func shellCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "shell",
+		Usage: "interactive shell on a single connection for exploring subscriptions and filters",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "history.file",
+				Usage: "file keeping the command history, empty to disable",
+				Value: defaultHistoryFile(),
+			},
+		}, outputFlags...),
+		// the history lets subscriptions be replayed when reconnecting
+		Before: func(c *cli.Context) error {
+			opts, err := clientOpts(c)
+			if err != nil {
+				return err
+			}
+			opts.History = &client.MsgHistory{}
+			return dial(c, opts)
+		},
+		Action: func(c *cli.Context) error {
+			term := &terminal{redraw: liner.TerminalSupported()}
+			out, err := newPrinter(c, term.writer(os.Stdout))
+			if err != nil {
+				return err
+			}
+			return newShell(c, out, term).run()
+		},
+	}
+}
+
+// defaultHistoryFile returns the shell history file next to the profiles file
+func defaultHistoryFile() string {
+	if path := defaultProfilesFile(); path != "" {
+		return filepath.Join(filepath.Dir(path), "shell_history")
+	}
+	return ""
+}
+
+// shell is a repl over the api connection, it is the printer of the event stream
+type shell struct {
+	c    *cli.Context
+	out  printer
+	pool *mempool.Mempool
+	term *terminal
+
+	mx      sync.Mutex
+	filter  *filter.Filter
+	paused  bool
+	watched map[string]bool
+	seen    map[string]bool
+	stats   shellStats
+}
+
+// shellStats counts events received since the shell started
+type shellStats struct {
+	events   int
+	shown    int
+	filtered int
+	hidden   int
+}
+
+func newShell(c *cli.Context, out printer, term *terminal) *shell {
+	return &shell{
+		c:       c,
+		out:     out,
+		pool:    mempool.New(),
+		term:    term,
+		watched: make(map[string]bool),
+		seen:    make(map[string]bool),
+	}
+}
+
+// run streams events in the background and reads commands until exit, or
+// until the event stream ends
+func (s *shell) run() error {
+	// log lines, such as acknowledgements and reconnects, are kept off the prompt too
+	log.SetOutput(s.term.writer(os.Stderr))
+	defer log.SetOutput(os.Stderr)
+	streamed := make(chan error, 1)
+	go func() {
+		err := stream(s.c, s)
+		if err != nil {
+			log.Println("event stream failed: ", err)
+		} else {
+			log.Println("event stream closed")
+		}
+		log.Println("press enter to leave the shell")
+		streamed <- err
+	}()
+	defer apiClient.Close()
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(s.complete)
+	path := s.c.String("history.file")
+	if path != "" {
+		if f, err := os.Open(path); err == nil {
+			line.ReadHistory(f)
+			f.Close()
+		}
+		defer func() {
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return
+			}
+			if f, err := os.Create(path); err == nil {
+				line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
+	fmt.Println("type help for the list of commands")
+	for {
+		s.term.prompting("> ")
+		input, err := line.Prompt("> ")
+		s.term.prompting("")
+		select {
+		case err := <-streamed:
+			return err
+		default:
+		}
+		switch {
+		case err == liner.ErrPromptAborted:
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		line.AppendHistory(input)
+		if input == "exit" || input == "quit" {
+			return nil
+		}
+		if err := s.exec(input); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+// exec runs a single command
+func (s *shell) exec(input string) error {
+	args := strings.Fields(input)
+	switch cmd := args[0]; {
+	case cmd == "watch" || cmd == "unwatch":
+		if len(args) < 2 {
+			return errors.Errorf("usage: %v <address|hash>...", cmd)
+		}
+		for _, v := range args[1:] {
+			if err := s.watch(v, cmd == "watch"); err != nil {
+				return err
+			}
+		}
+	case cmd == "config" && len(args) == 3 && args[1] == "put":
+		return s.configPut(args[2])
+	case cmd == "filter":
+		return s.setFilter(strings.TrimSpace(strings.TrimPrefix(input, "filter")))
+	case cmd == "pause" || cmd == "resume":
+		s.mx.Lock()
+		s.paused = cmd == "pause"
+		hidden := s.stats.hidden
+		s.stats.hidden = 0
+		s.mx.Unlock()
+		if cmd == "resume" && hidden > 0 {
+			fmt.Printf("%d events were hidden while paused\n", hidden)
+		}
+	case cmd == "show" && len(args) >= 2 && args[1] == "pending":
+		if len(args) > 3 {
+			return errors.New("usage: show pending [address]")
+		}
+		var from string
+		if len(args) == 3 {
+			addr, err := client.NormalizeAddress(args[2])
+			if err != nil {
+				return err
+			}
+			from = addr
+		}
+		s.showPending(from)
+	case cmd == "stats":
+		s.showStats()
+	case cmd == "help":
+		fmt.Println(shellHelp)
+	default:
+		return errors.Errorf("unknown command:%v, type help for the list of commands", input)
+	}
+	return nil
+}
+
+// watch subscribes to or unsubscribes from an address or a transaction hash
+func (s *shell) watch(value string, subscribe bool) error {
+	var (
+		msg interface{}
+		err error
+	)
+	switch {
+	case len(value) == 66 && subscribe:
+		msg, err = client.NewTxSubscribeChecked(baseMessage(s.c), value)
+	case len(value) == 66:
+		msg, err = client.NewTxUnsubscribeChecked(baseMessage(s.c), value)
+	case subscribe:
+		msg, err = client.NewAddressSubscribeChecked(baseMessage(s.c), value)
+	default:
+		msg, err = client.NewAddressUnsubscribeChecked(baseMessage(s.c), value)
+	}
+	if err != nil {
+		return err
+	}
+	if err := apiClient.WriteJSON(msg); err != nil {
+		return err
+	}
+	s.mx.Lock()
+	if subscribe {
+		s.watched[strings.ToLower(value)] = true
+	} else {
+		delete(s.watched, strings.ToLower(value))
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+// configPut sends the json config read from path
+func (s *shell) configPut(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg client.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return errors.Wrap(err, "decoding config")
+	}
+	checked, err := client.NewConfigChecked(cfg.Scope, cfg.WatchAddress, cfg.ABI)
+	if err != nil {
+		return err
+	}
+	checked.Filters = cfg.Filters
+	return apiClient.WriteJSON(client.NewConfiguration(baseMessage(s.c), checked))
+}
+
+// setFilter replaces the display filter, printing it when arg is empty
+func (s *shell) setFilter(arg string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	switch arg {
+	case "":
+		if s.filter == nil {
+			fmt.Println("no filter")
+			return nil
+		}
+		data, err := json.Marshal(s.filter.Terms())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "off":
+		s.filter = nil
+	default:
+		f, err := filter.Parse([]byte(arg))
+		if err != nil {
+			return err
+		}
+		s.filter = f
+	}
+	return nil
+}
+
+// Print tracks every event and shows those matching the filter unless paused
+func (s *shell) Print(ev *client.Event) error {
+	s.pool.Apply(ev)
+	s.mx.Lock()
+	s.stats.events++
+	tx := ev.Payload.Event.Transaction
+	for _, addr := range []string{tx.From, tx.To, tx.WatchedAddress} {
+		if addr != "" && len(s.seen) < maxCompletions {
+			s.seen[strings.ToLower(addr)] = true
+		}
+	}
+	show := true
+	switch {
+	case s.filter != nil && !s.filter.Match(ev):
+		s.stats.filtered++
+		show = false
+	case s.paused:
+		s.stats.hidden++
+		show = false
+	default:
+		s.stats.shown++
+	}
+	s.mx.Unlock()
+	if !show {
+		return nil
+	}
+	return s.out.Print(ev)
+}
+
+// showPending prints the pending transactions of from, or of every sender if from is empty
+func (s *shell) showPending(from string) {
+	senders := []string{from}
+	if from == "" {
+		senders = s.pool.Senders()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FROM\tNONCE\tHASH\tTO\tVALUE\tGAS\tEVENT\tAGE")
+	for _, sender := range senders {
+		for _, tx := range s.pool.Pending(sender) {
+			gas := tx.MaxFeePerGas
+			if gas == "" {
+				gas = tx.GasPrice
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				tx.From, tx.Nonce, tx.Hash, tx.To, formatEth(tx.Value), formatGwei(gas), tx.EventCode,
+				time.Since(tx.FirstSeen).Round(time.Second))
+		}
+	}
+	w.Flush()
+}
+
+func (s *shell) showStats() {
+	s.mx.Lock()
+	st := s.stats
+	paused, watched := s.paused, len(s.watched)
+	s.mx.Unlock()
+	pool := s.pool.Stats()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "watched\t%d\n", watched)
+	fmt.Fprintf(w, "events\t%d\n", st.events)
+	fmt.Fprintf(w, "shown\t%d\n", st.shown)
+	fmt.Fprintf(w, "filtered\t%d\n", st.filtered)
+	fmt.Fprintf(w, "hidden\t%d (paused: %v)\n", st.hidden, paused)
+	fmt.Fprintf(w, "pending\t%d from %d senders\n", pool.Pending, pool.Senders)
+	fmt.Fprintf(w, "applied\t%d\n", pool.Applied)
+	fmt.Fprintf(w, "evicted\t%d\n", pool.Evicted)
+	w.Flush()
+}
+
+// complete offers commands, and watched or seen addresses as their arguments
+func (s *shell) complete(line string) []string {
+	var out []string
+	for _, cmd := range shellCommands {
+		if strings.HasPrefix(cmd, line) {
+			out = append(out, cmd)
+		}
+	}
+	i := strings.LastIndex(line, " ")
+	if i < 0 {
+		return out
+	}
+	prefix, word := line[:i+1], strings.ToLower(line[i+1:])
+	switch {
+	case strings.HasPrefix(prefix, "watch "), strings.HasPrefix(prefix, "unwatch "), prefix == "show pending ":
+	default:
+		return out
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var values []string
+	for v := range s.watched {
+		values = append(values, v)
+	}
+	for v := range s.seen {
+		if !s.watched[v] {
+			values = append(values, v)
+		}
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		if strings.HasPrefix(v, word) {
+			out = append(out, prefix+v)
+		}
+	}
+	return out
+}
+
+// terminal keeps output written while liner shows the prompt from running
+// into the line being edited. The prompt line is cleared before the output
+// and drawn again after it, liner redraws what was typed on the next key.
+type terminal struct {
+	// whether the terminal understands the escape sequence clearing a line
+	redraw bool
+
+	mx     sync.Mutex
+	prompt string
+}
+
+// prompting records the prompt being shown, empty once input was read
+func (t *terminal) prompting(prompt string) {
+	t.mx.Lock()
+	t.prompt = prompt
+	t.mx.Unlock()
+}
+
+// writer returns a writer to w which keeps clear of the prompt
+func (t *terminal) writer(w io.Writer) io.Writer {
+	return &terminalWriter{t: t, w: w}
+}
+
+type terminalWriter struct {
+	t *terminal
+	w io.Writer
+}
+
+func (tw *terminalWriter) Write(p []byte) (int, error) {
+	tw.t.mx.Lock()
+	defer tw.t.mx.Unlock()
+	if !tw.t.redraw || tw.t.prompt == "" {
+		return tw.w.Write(p)
+	}
+	if _, err := io.WriteString(tw.w, "\r\x1b[K"); err != nil {
+		return 0, err
+	}
+	n, err := tw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(tw.w, tw.t.prompt)
+	return n, err
+}
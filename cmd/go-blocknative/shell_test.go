@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+// printed collects the events shown by the shell
+type printed []*client.Event
+
+func (p *printed) Print(ev *client.Event) error {
+	*p = append(*p, ev)
+	return nil
+}
+
+func TestShellExec(t *testing.T) {
+	out := &printed{}
+	s := newShell(newContext(t, nil), out, &terminal{})
+	pending, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x01","status":"pending","from":"0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"}}}`))
+	require.NoError(t, err)
+	confirmed, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x01","status":"confirmed"}}}`))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		input string
+		err   string
+		// events printed, and the stats after printing them
+		print []*client.Event
+		stats shellStats
+	}{
+		{input: "watch", err: "usage: watch <address|hash>..."},
+		{input: "unwatch 0x1", err: "invalid address:0x1"},
+		{input: "watch 0x01", err: "invalid address:0x01"},
+		{input: "config put", err: "unknown command:config put, type help for the list of commands"},
+		{input: "show pending 0x1", err: "invalid address:0x1"},
+		{input: "show pending a b", err: "usage: show pending [address]"},
+		{input: "frobnicate", err: "unknown command:frobnicate, type help for the list of commands"},
+		{input: `filter {"status":`, err: "parsing filter: unexpected end of JSON input"},
+		{
+			input: `filter {"status":"pending"}`,
+			print: []*client.Event{pending, confirmed},
+			stats: shellStats{events: 2, shown: 1, filtered: 1},
+		},
+		{input: "pause", print: []*client.Event{pending}, stats: shellStats{events: 3, shown: 1, filtered: 1, hidden: 1}},
+		// resuming reports and resets the hidden count
+		{input: "resume", stats: shellStats{events: 3, shown: 1, filtered: 1}},
+		{input: "filter off", print: []*client.Event{confirmed}, stats: shellStats{events: 4, shown: 2, filtered: 1}},
+	} {
+		err := s.exec(tc.input)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, tc.input)
+			continue
+		}
+		require.NoError(t, err, tc.input)
+		for _, ev := range tc.print {
+			require.NoError(t, s.Print(ev))
+		}
+		require.Equal(t, tc.stats, s.stats, tc.input)
+	}
+	require.Equal(t, printed{pending, confirmed}, *out)
+}
+
+func TestShellComplete(t *testing.T) {
+	s := newShell(newContext(t, nil), &printed{}, &terminal{})
+	s.watched["0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"] = true
+	s.seen["0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"] = true
+	s.seen["0x7a250d5630b4cf539739df2c5dacb4c659f2488d"] = true
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{line: "", want: shellCommands},
+		{line: "s", want: []string{"show pending", "stats"}},
+		{line: "con", want: []string{"config put"}},
+		{line: "watch 0xF", want: []string{"watch 0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"}},
+		{line: "unwatch 0x1 0x7", want: []string{"unwatch 0x1 0x7a250d5630b4cf539739df2c5dacb4c659f2488d"}},
+		{line: "show pending ", want: []string{
+			"show pending 0x7a250d5630b4cf539739df2c5dacb4c659f2488d",
+			"show pending 0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41",
+		}},
+		// only addresses are completed
+		{line: "filter 0x", want: nil},
+		{line: "exit ", want: nil},
+	} {
+		require.Equal(t, tc.want, s.complete(tc.line), tc.line)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	term := &terminal{redraw: true}
+	w := term.writer(&buf)
+	fmt.Fprintln(w, "before")
+	term.prompting("> ")
+	fmt.Fprintln(w, "while prompting")
+	term.prompting("")
+	fmt.Fprintln(w, "after")
+	require.Equal(t, "before\n\r\x1b[Kwhile prompting\n> after\n", buf.String())
+
+	// terminals without line editing get the output unchanged
+	buf.Reset()
+	term = &terminal{}
+	term.prompting("> ")
+	fmt.Fprintln(term.writer(&buf), "plain")
+	require.Equal(t, "plain\n", buf.String())
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// reconnectBackoff is how long to wait before the first reconnect attempt, doubling up to maxReconnectBackoff
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = time.Second * 30
+)
+
+var fileFlag = &cli.StringFlag{
+	Name:  "file",
+	Usage: "read values from a file with one per line, - for stdin",
+}
+
+func subscribeCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "subscribe",
+		Aliases: []string{"sub"},
+		Usage:   "event subscription commands",
+		// the history lets subscriptions be replayed when reconnecting
+		Before: func(c *cli.Context) error {
+			opts, err := clientOpts(c)
+			if err != nil {
+				return err
+			}
+			opts.History = &client.MsgHistory{}
+			return dial(c, opts)
+		},
+		After: func(c *cli.Context) error {
+			if apiClient == nil {
+				return nil
+			}
+			return apiClient.Close()
+		},
+		Subcommands: cli.Commands{
+			&cli.Command{
+				Name:      "address",
+				Usage:     "subscribe to events based on addresses, defaulting to --address",
+				ArgsUsage: "[address...]",
+				Flags:     append([]cli.Flag{fileFlag}, outputFlags...),
+				Action: func(c *cli.Context) error {
+					out, err := newPrinter(c, os.Stdout)
+					if err != nil {
+						return err
+					}
+					addresses, err := values(c, c.String("address"))
+					if err != nil {
+						return err
+					}
+					for _, addr := range addresses {
+						msg, err := client.NewAddressSubscribeChecked(baseMessage(c), addr)
+						if err != nil {
+							return err
+						}
+						if err := apiClient.WriteJSON(msg); err != nil {
+							return err
+						}
+					}
+					return stream(c, out)
+				},
+			},
+			&cli.Command{
+				Name:      "tx",
+				Usage:     "subscribe to events based on transaction hashes, defaulting to --tx.hash",
+				ArgsUsage: "[hash...]",
+				Flags:     append([]cli.Flag{fileFlag}, outputFlags...),
+				Action: func(c *cli.Context) error {
+					out, err := newPrinter(c, os.Stdout)
+					if err != nil {
+						return err
+					}
+					hashes, err := values(c, c.String("tx.hash"))
+					if err != nil {
+						return err
+					}
+					for _, hash := range hashes {
+						msg, err := client.NewTxSubscribeChecked(baseMessage(c), hash)
+						if err != nil {
+							return err
+						}
+						if err := apiClient.WriteJSON(msg); err != nil {
+							return err
+						}
+					}
+					return stream(c, out)
+				},
+			},
+			&cli.Command{
+				Name:  "config",
+				Usage: "put a config for a scope and subscribe to the events it produces",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "scope",
+						Usage: "address the config applies to, or global",
+						Value: "global",
+					},
+					&cli.StringFlag{
+						Name:  "filters",
+						Usage: `json array of jsql filters, eg [{"status":"pending"}]`,
+					},
+					&cli.StringFlag{
+						Name:  "abi.file",
+						Usage: "json abi of the contract at scope, letting blocknative decode its calls",
+					},
+					&cli.BoolFlag{
+						Name:  "watch.address",
+						Usage: "also watch the scope as an address",
+					},
+				}, outputFlags...),
+				Action: func(c *cli.Context) error {
+					out, err := newPrinter(c, os.Stdout)
+					if err != nil {
+						return err
+					}
+					var abi interface{}
+					if path := c.String("abi.file"); path != "" {
+						data, err := ioutil.ReadFile(path)
+						if err != nil {
+							return err
+						}
+						if err := json.Unmarshal(data, &abi); err != nil {
+							return errors.Wrap(err, "decoding abi")
+						}
+					}
+					cfg, err := client.NewConfigChecked(c.String("scope"), c.Bool("watch.address"), abi)
+					if err != nil {
+						return err
+					}
+					if filters := c.String("filters"); filters != "" {
+						if err := json.Unmarshal([]byte(filters), &cfg.Filters); err != nil {
+							return errors.Wrap(err, "decoding filters")
+						}
+					}
+					if err := apiClient.EventSub(client.NewConfiguration(baseMessage(c), cfg)); err != nil {
+						return err
+					}
+					return stream(c, out)
+				},
+			},
+		},
+	}
+}
+
+func unsubscribeCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "unsubscribe",
+		Aliases: []string{"unsub"},
+		Usage:   "send unsubscribe messages, printing their acknowledgements",
+		Before:  connect,
+		After: func(c *cli.Context) error {
+			if apiClient == nil {
+				return nil
+			}
+			return apiClient.Close()
+		},
+		Subcommands: cli.Commands{
+			&cli.Command{
+				Name:      "address",
+				Usage:     "unsubscribe from addresses, defaulting to --address",
+				ArgsUsage: "[address...]",
+				Flags:     []cli.Flag{fileFlag},
+				Action: func(c *cli.Context) error {
+					addresses, err := values(c, c.String("address"))
+					if err != nil {
+						return err
+					}
+					for _, addr := range addresses {
+						msg, err := client.NewAddressUnsubscribeChecked(baseMessage(c), addr)
+						if err != nil {
+							return err
+						}
+						if err := send(msg); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			&cli.Command{
+				Name:      "tx",
+				Usage:     "unsubscribe from transaction hashes, defaulting to --tx.hash",
+				ArgsUsage: "[hash...]",
+				Flags:     []cli.Flag{fileFlag},
+				Action: func(c *cli.Context) error {
+					hashes, err := values(c, c.String("tx.hash"))
+					if err != nil {
+						return err
+					}
+					for _, hash := range hashes {
+						msg, err := client.NewTxUnsubscribeChecked(baseMessage(c), hash)
+						if err != nil {
+							return err
+						}
+						if err := send(msg); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// values returns the command's arguments and the values in --file, or fallback if neither are given
+func values(c *cli.Context, fallback string) ([]string, error) {
+	vals := c.Args().Slice()
+	if path := c.String("file"); path != "" {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				vals = append(vals, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(vals) == 0 && fallback != "" {
+		vals = append(vals, fallback)
+	}
+	if len(vals) == 0 {
+		return nil, errors.New("no values given")
+	}
+	return vals, nil
+}
+
+// send writes msg and prints its acknowledgement
+func send(msg interface{}) error {
+	if err := apiClient.WriteJSON(msg); err != nil {
+		return err
+	}
+	var out client.ConnectResponse
+	if err := apiClient.ReadJSON(&out); err != nil {
+		return err
+	}
+	if out.Status != "ok" {
+		return errors.Errorf("unsubscribe failed reason:%v", out.Reason)
+	}
+	log.Printf("receive message:\n%+v\n", out)
+	return nil
+}
+
+// stream prints events to out until the command is cancelled, reconnecting and
+// replaying subscriptions whenever the connection drops. Frames that are not
+// transaction events, such as acknowledgements, are logged instead.
+func stream(c *cli.Context, out printer) error {
+	return follow(c, func(ev *client.Event) error {
+		if ev.Payload.Event.Transaction.Hash == "" {
+			log.Printf("receive message:\n%s\n", ev.Raw)
+			return nil
+		}
+		return out.Print(ev)
+	}, nil, nil)
+}
+
+// follow passes every event read to handle until the command is cancelled,
+// the connection is closed normally or handle fails. Malformed messages are
+// skipped, and when the connection drops lost is called before reconnecting
+// and reconnected once it is back, both may be nil.
+func follow(c *cli.Context, handle func(ev *client.Event) error, lost func(err error), reconnected func()) error {
+	for {
+		var raw json.RawMessage
+		err := apiClient.ReadJSON(&raw)
+		switch {
+		case err == nil:
+			ev, err := client.NewEvent(time.Now(), raw)
+			if err != nil {
+				log.Println("skipping malformed message: ", err)
+				continue
+			}
+			if err := handle(ev); err != nil {
+				return err
+			}
+			continue
+		case c.Context.Err() != nil || websocket.IsCloseError(err, websocket.CloseNormalClosure):
+			return nil
+		}
+		var (
+			syntaxErr *json.SyntaxError
+			typeErr   *json.UnmarshalTypeError
+		)
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			log.Println("skipping malformed message: ", err)
+			continue
+		}
+		log.Println("connection lost, reconnecting: ", err)
+		if lost != nil {
+			lost(err)
+		}
+		if err := reconnect(c); err != nil {
+			return nil
+		}
+		if reconnected != nil {
+			reconnected()
+		}
+	}
+}
+
+// relay runs read until the command is cancelled, reconnecting and calling
+// resubscribe whenever the connection drops. It returns the error of reconnecting.
+func relay(c *cli.Context, read func() error, resubscribe func() error) error {
+	for {
+		err := read()
+		if c.Context.Err() != nil {
+			return nil
+		}
+		log.Println("connection lost, reconnecting: ", err)
+		if err := reconnect(c); err != nil {
+			if c.Context.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "reconnecting")
+		}
+		if err := resubscribe(); err != nil {
+			log.Println("resubscribing: ", err)
+		}
+	}
+}
+
+// reconnect retries with exponential backoff until it succeeds or the command is cancelled
+func reconnect(c *cli.Context) error {
+	backoff := reconnectBackoff
+	for {
+		select {
+		case <-c.Context.Done():
+			return c.Context.Err()
+		case <-time.After(backoff):
+		}
+		err := apiClient.Reconnect()
+		if err == nil {
+			log.Println("reconnected")
+			return nil
+		}
+		log.Println("reconnect failed: ", err)
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
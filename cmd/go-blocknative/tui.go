@@ -0,0 +1,532 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/dashboard"
+	"github.com/nsf/termbox-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const tuiKeys = "up/down select  enter details  pgup/pgdn scroll events  q quit"
+
+func tuiCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "tui",
+		Usage: "live dashboard of the pending and confirmed transactions of watched addresses",
+		Description: "every watched address gets a panel of its pending and recently finished transactions, " +
+			"showing their age, fee against the current base fee and lifecycle including speedups and cancels, " +
+			"along with a scrolling event log and the health of the connection. " +
+			"enter on a transaction shows its full decoded event",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "watch",
+				Usage: "addresses to watch, defaults to --address",
+			},
+		},
+		// the history lets subscriptions be replayed when reconnecting
+		Before: func(c *cli.Context) error {
+			opts, err := clientOpts(c)
+			if err != nil {
+				return err
+			}
+			opts.History = &client.MsgHistory{}
+			return dial(c, opts)
+		},
+		Action: func(c *cli.Context) error {
+			defer apiClient.Close()
+			addresses := c.StringSlice("watch")
+			if len(addresses) == 0 {
+				addresses = []string{c.String("address")}
+			}
+			for _, addr := range addresses {
+				msg, err := client.NewAddressSubscribeChecked(baseMessage(c), addr)
+				if err != nil {
+					return err
+				}
+				if err := apiClient.WriteJSON(msg); err != nil {
+					return err
+				}
+			}
+			dash := dashboard.New(dashboard.Opts{Addresses: addresses})
+			dash.Connected()
+			// log output would corrupt the screen so it goes to the event log
+			log.SetOutput(dash)
+			log.SetFlags(0)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				log.SetFlags(log.LstdFlags)
+			}()
+			changed := make(chan struct{}, 1)
+			go feed(c, dash, changed)
+			return (&tui{c: c, dash: dash}).run(changed)
+		},
+	}
+}
+
+// feed applies events to the dashboard, reconnecting when the connection is
+// lost, and signals changed after every update
+func feed(c *cli.Context, dash *dashboard.Dashboard, changed chan<- struct{}) {
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	follow(c, func(ev *client.Event) error {
+		dash.Apply(ev)
+		notify()
+		return nil
+	}, func(err error) {
+		dash.Disconnected(err)
+		notify()
+	}, func() {
+		dash.Connected()
+		notify()
+	})
+}
+
+// segment is text drawn in a single color
+type segment struct {
+	text string
+	fg   termbox.Attribute
+}
+
+// line is a line of the transaction panels, hash is set for selectable lines
+type line struct {
+	segments []segment
+	hash     string
+}
+
+// tui draws the dashboard and handles keys
+type tui struct {
+	c    *cli.Context
+	dash *dashboard.Dashboard
+
+	// hashes are the selectable transactions as of the last draw
+	hashes   []string
+	selected string
+	// detail is the transaction whose event is shown, empty on the main screen
+	detail       string
+	detailScroll int
+	logScroll    int
+}
+
+// run draws the dashboard whenever it changes, every second for ages, and after keys until quit
+func (t *tui) run(changed <-chan struct{}) error {
+	if err := termbox.Init(); err != nil {
+		return errors.Wrap(err, "initializing terminal")
+	}
+	defer termbox.Close()
+	keys := make(chan termbox.Event)
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventInterrupt {
+				return
+			}
+			keys <- ev
+		}
+	}()
+	defer termbox.Interrupt()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-t.c.Context.Done():
+			return nil
+		case <-changed:
+		case <-ticker.C:
+		case ev := <-keys:
+			switch {
+			case ev.Type == termbox.EventError:
+				return ev.Err
+			case ev.Type == termbox.EventKey && t.key(ev):
+				return nil
+			}
+		}
+	}
+}
+
+// key handles a key press, returning true to quit
+func (t *tui) key(ev termbox.Event) bool {
+	if ev.Key == termbox.KeyCtrlC || (ev.Ch == 'q' && t.detail == "") {
+		return true
+	}
+	if t.detail != "" {
+		switch {
+		case ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyEnter || ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2 || ev.Ch == 'q':
+			t.detail, t.detailScroll = "", 0
+		case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+			t.detailScroll--
+		case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+			t.detailScroll++
+		case ev.Key == termbox.KeyPgup:
+			t.detailScroll -= 10
+		case ev.Key == termbox.KeyPgdn:
+			t.detailScroll += 10
+		}
+		if t.detailScroll < 0 {
+			t.detailScroll = 0
+		}
+		return false
+	}
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		t.move(-1)
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		t.move(1)
+	case ev.Key == termbox.KeyEnter && t.selected != "":
+		t.detail = t.selected
+	case ev.Key == termbox.KeyPgup:
+		t.logScroll += 5
+	case ev.Key == termbox.KeyPgdn:
+		if t.logScroll -= 5; t.logScroll < 0 {
+			t.logScroll = 0
+		}
+	}
+	return false
+}
+
+// move selects the transaction delta rows away from the selected one
+func (t *tui) move(delta int) {
+	if len(t.hashes) == 0 {
+		return
+	}
+	i := indexOf(t.hashes, t.selected) + delta
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(t.hashes) {
+		i = len(t.hashes) - 1
+	}
+	t.selected = t.hashes[i]
+}
+
+func (t *tui) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	defer termbox.Flush()
+	w, h := termbox.Size()
+	snap := t.dash.Snapshot()
+	now := time.Now()
+	if t.detail != "" && t.drawDetail(snap, now, h) {
+		return
+	}
+	t.detail = ""
+	t.drawHeader(snap, now)
+
+	lines := t.panelLines(snap, now)
+	t.hashes = t.hashes[:0]
+	for _, l := range lines {
+		if l.hash != "" {
+			t.hashes = append(t.hashes, l.hash)
+		}
+	}
+	if indexOf(t.hashes, t.selected) < 0 {
+		t.selected = ""
+		if len(t.hashes) > 0 {
+			t.selected = t.hashes[0]
+		}
+	}
+
+	logHeight := h / 3
+	if logHeight < 3 {
+		logHeight = 3
+	}
+	// the panels scroll to keep the selected transaction in view
+	top, height := 2, h-logHeight-3
+	first := 0
+	for i, l := range lines {
+		if l.hash != "" && l.hash == t.selected && i >= height {
+			first = i - height + 1
+		}
+	}
+	for i := 0; i < height && first+i < len(lines); i++ {
+		l := lines[first+i]
+		attr := termbox.Attribute(0)
+		if l.hash != "" && l.hash == t.selected {
+			attr = termbox.AttrReverse
+		}
+		x := 0
+		for _, s := range l.segments {
+			x = drawText(x, top+i, s.fg|attr, s.text)
+		}
+	}
+
+	y := h - logHeight - 1
+	title := "events"
+	if t.logScroll > 0 {
+		title = fmt.Sprintf("events (%d back)", t.logScroll)
+	}
+	drawText(0, y, termbox.ColorBlue, rule(title, w))
+	end := len(snap.Log) - t.logScroll
+	if end < 0 {
+		end, t.logScroll = 0, len(snap.Log)
+	}
+	start := end - (logHeight - 1)
+	if start < 0 {
+		start = 0
+	}
+	for i, e := range snap.Log[start:end] {
+		x := drawText(0, y+1+i, termbox.ColorDefault, e.Time.Format("15:04:05")+" ")
+		if e.EventCode != "" {
+			x = drawText(x, y+1+i, statusColor(e.EventCode), fmt.Sprintf("%-12s", shortCode(e.EventCode)))
+			x = drawText(x, y+1+i, termbox.ColorDefault, shortHash(e.Hash)+" ")
+		}
+		drawText(x, y+1+i, termbox.ColorDefault, e.Message)
+	}
+	drawText(0, h-1, termbox.ColorDefault|termbox.AttrBold, tuiKeys)
+}
+
+// drawHeader draws the network, connection health and base fee
+func (t *tui) drawHeader(snap dashboard.Snapshot, now time.Time) {
+	x := drawText(0, 0, termbox.ColorDefault|termbox.AttrBold, fmt.Sprintf("%s/%s  ", t.c.String("system"), t.c.String("network")))
+	health := snap.Health
+	if health.Connected {
+		x = drawText(x, 0, termbox.ColorGreen, "connected")
+	} else {
+		x = drawText(x, 0, termbox.ColorRed, "disconnected")
+	}
+	status := fmt.Sprintf("  reconnects %d  events %d", health.Reconnects, health.Events)
+	if !health.LastEvent.IsZero() {
+		status += fmt.Sprintf(" (last %s ago)", now.Sub(health.LastEvent).Round(time.Second))
+	}
+	x = drawText(x, 0, termbox.ColorDefault, status)
+	base := "unknown"
+	if snap.BaseFee != nil {
+		base = formatGwei(snap.BaseFee) + " gwei"
+	}
+	block := ""
+	if snap.Block > 0 {
+		block = fmt.Sprintf("  block %d", snap.Block)
+	}
+	drawText(x, 0, termbox.ColorDefault, block+"  base fee "+base)
+	drawText(0, 1, termbox.ColorDefault|termbox.AttrBold, fmt.Sprintf("  %-12s %6s  %-14s %-17s %12s %10s %10s %8s  %s",
+		"STATUS", "NONCE", "HASH", "COUNTERPARTY", "VALUE", "FEE", "VS BASE", "AGE", "LIFECYCLE"))
+}
+
+// panelLines returns the title and transactions of every panel
+func (t *tui) panelLines(snap dashboard.Snapshot, now time.Time) []line {
+	var lines []line
+	for _, p := range snap.Panels {
+		lines = append(lines, line{segments: []segment{{
+			text: fmt.Sprintf("%s  %d pending  %d done", p.Address, len(p.Pending), len(p.Done)),
+			fg:   termbox.ColorCyan | termbox.AttrBold,
+		}}})
+		for _, rows := range [][]dashboard.Row{p.Pending, p.Done} {
+			for _, r := range rows {
+				lines = append(lines, rowLine(p.Address, r, snap.BaseFee, now))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, line{segments: []segment{{text: "waiting for events", fg: termbox.ColorDefault}}})
+	}
+	return lines
+}
+
+// rowLine formats a transaction of the panel of addr
+func rowLine(addr string, r dashboard.Row, baseFee *big.Int, now time.Time) line {
+	counterparty := "-> " + shortHash(r.To)
+	if r.To == addr {
+		counterparty = "<- " + shortHash(r.From)
+	}
+	fee, vsBase := "-", "-"
+	if r.FeeCap != nil {
+		fee = formatGwei(r.FeeCap)
+		if baseFee != nil && baseFee.Sign() > 0 {
+			ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(r.FeeCap), new(big.Float).SetInt(baseFee)).Float64()
+			vsBase = fmt.Sprintf("%.2fx", ratio)
+		}
+	}
+	feeColor := termbox.ColorDefault
+	if r.Underpriced(baseFee) && !r.Done {
+		feeColor = termbox.ColorRed
+	}
+	var lifecycle []string
+	for _, tr := range r.Transitions {
+		lifecycle = append(lifecycle, shortCode(tr.EventCode))
+	}
+	note := strings.Join(lifecycle, " > ")
+	if r.Replaces != "" {
+		note += " (replaces " + shortHash(r.Replaces) + ")"
+	}
+	if r.ReplacedBy != "" {
+		note += " (by " + shortHash(r.ReplacedBy) + ")"
+	}
+	return line{hash: r.Hash, segments: []segment{
+		{text: fmt.Sprintf("  %-12s ", shortCode(r.Status)), fg: statusColor(r.Status)},
+		{text: fmt.Sprintf("%6d  %-14s %-17s %12s ", r.Nonce, shortHash(r.Hash), counterparty, formatEth(r.Value)), fg: termbox.ColorDefault},
+		{text: fmt.Sprintf("%10s %10s", fee, vsBase), fg: feeColor},
+		{text: fmt.Sprintf(" %8s  %s", r.Age(now).Round(time.Second), note), fg: termbox.ColorDefault},
+	}}
+}
+
+// drawDetail draws the full decoded event of the selected transaction, false
+// if it is no longer on the dashboard
+func (t *tui) drawDetail(snap dashboard.Snapshot, now time.Time, h int) bool {
+	var row *dashboard.Row
+	for _, p := range snap.Panels {
+		for _, rows := range [][]dashboard.Row{p.Pending, p.Done} {
+			for i := range rows {
+				if rows[i].Hash == t.detail {
+					row = &rows[i]
+				}
+			}
+		}
+	}
+	if row == nil {
+		return false
+	}
+	text := detailLines(*row, snap.BaseFee, now)
+	if max := len(text) - (h - 2); t.detailScroll > max {
+		t.detailScroll = max
+		if t.detailScroll < 0 {
+			t.detailScroll = 0
+		}
+	}
+	drawText(0, 0, termbox.ColorCyan|termbox.AttrBold, "transaction "+row.Hash)
+	for i := 0; i < h-2 && t.detailScroll+i < len(text); i++ {
+		drawText(0, i+1, termbox.ColorDefault, text[t.detailScroll+i])
+	}
+	drawText(0, h-1, termbox.ColorDefault|termbox.AttrBold, "up/down scroll  esc back  ctrl-c quit")
+	return true
+}
+
+// detailLines describes a transaction, its lifecycle, its contract call and its latest event
+func detailLines(r dashboard.Row, baseFee *big.Int, now time.Time) []string {
+	var out []string
+	add := func(format string, args ...interface{}) {
+		out = append(out, fmt.Sprintf(format, args...))
+	}
+	tx := r.Event.Payload.Event.Transaction
+	add("status         %s", r.Status)
+	add("from           %s", r.From)
+	add("to             %s", r.To)
+	add("nonce          %d", r.Nonce)
+	add("value          %s eth", formatEth(r.Value))
+	if r.FeeCap != nil {
+		fee := formatGwei(r.FeeCap) + " gwei"
+		if baseFee != nil {
+			fee += " (base fee " + formatGwei(baseFee) + " gwei)"
+		}
+		add("fee cap        %s", fee)
+	}
+	if tx.MaxPriorityFeePerGas != "" {
+		add("priority fee   %s gwei", formatGwei(tx.MaxPriorityFeePerGas))
+	}
+	add("gas            %d", tx.Gas)
+	if tx.BlockNumber > 0 {
+		add("block          %d", tx.BlockNumber)
+	}
+	add("first seen     %s (%s)", r.FirstSeen.Format(time.RFC3339), r.Age(now).Round(time.Second))
+	if r.Replaces != "" {
+		add("replaces       %s", r.Replaces)
+	}
+	if r.ReplacedBy != "" {
+		add("replaced by    %s", r.ReplacedBy)
+	}
+	add("")
+	add("lifecycle")
+	for _, tr := range r.Transitions {
+		add("  %s  %-12s %s", tr.Time.Format("15:04:05"), tr.EventCode, tr.Hash)
+	}
+	if call := r.Event.Payload.Event.ContractCall; call != nil {
+		add("")
+		add("contract call  %s.%s (%s %s)", call.ContractName, call.MethodName, call.ContractType, call.ContractAddress)
+		names := make([]string, 0, len(call.Params))
+		for name := range call.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, err := json.Marshal(call.Params[name])
+			if err != nil {
+				value = []byte(fmt.Sprint(call.Params[name]))
+			}
+			add("  %s: %s", name, value)
+		}
+	}
+	add("")
+	add("event")
+	var raw interface{}
+	if err := json.Unmarshal(r.Event.Raw, &raw); err == nil {
+		if data, err := json.MarshalIndent(raw, "  ", "  "); err == nil {
+			for _, l := range strings.Split(string(data), "\n") {
+				add("  %s", l)
+			}
+		}
+	}
+	return out
+}
+
+// drawText draws s from x on line y, clipping it at the screen's width, and returns where it ends
+func drawText(x, y int, fg termbox.Attribute, s string) int {
+	w, _ := termbox.Size()
+	for _, r := range s {
+		if x >= w {
+			break
+		}
+		termbox.SetCell(x, y, r, fg, termbox.ColorDefault)
+		x++
+	}
+	return x
+}
+
+// rule returns a horizontal line of width w with a title
+func rule(title string, w int) string {
+	s := "-- " + title + " "
+	if n := w - len(s); n > 0 {
+		s += strings.Repeat("-", n)
+	}
+	return s
+}
+
+// statusColor colors event codes by how the transaction is doing
+func statusColor(code string) termbox.Attribute {
+	switch code {
+	case "txConfirmed":
+		return termbox.ColorGreen
+	case "txFailed", "txDropped", "txRejected", "txStuck":
+		return termbox.ColorRed
+	case "txSpeedUp", "txCancel", dashboard.Replaced:
+		return termbox.ColorMagenta
+	default:
+		return termbox.ColorYellow
+	}
+}
+
+// shortCode drops the tx prefix of event codes
+func shortCode(code string) string {
+	if strings.HasPrefix(code, "tx") && len(code) > 2 {
+		return strings.ToLower(code[2:3]) + code[3:]
+	}
+	return code
+}
+
+// shortHash abbreviates hashes and addresses
+func shortHash(s string) string {
+	if len(s) <= 14 {
+		return s
+	}
+	return s[:8] + ".." + s[len(s)-4:]
+}
+
+func indexOf(values []string, v string) int {
+	for i, value := range values {
+		if value == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,617 @@
+// Package daemon runs the subscriptions declared in a config file, keeping
+// the live subscriptions in line with the file as it changes and writing
+// every event to the declared sinks. Each declared network gets its own
+// connection, and only the difference between the declared and live state
+// is sent when the file changes.
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/route"
+	"github.com/bonedaddy/go-blocknative/rules"
+	"github.com/bonedaddy/go-blocknative/sink"
+	"github.com/bonedaddy/go-blocknative/sink/sqlite"
+	"github.com/bonedaddy/go-blocknative/webhook"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultReloadInterval is how often the config file is checked for changes when no interval is given
+	DefaultReloadInterval = time.Second * 5
+	// reconnectBackoff is how long to wait before the first reconnect attempt, doubling up to maxReconnectBackoff
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = time.Second * 30
+	// closeTimeout bounds how long closing a network waits for its reader to stop
+	closeTimeout = time.Second * 5
+	// sinkRetries is how often file and sqlite writes are retried, webhooks retry from their own queue
+	sinkRetries = 3
+)
+
+// Conn is an initialized connection to a single network, a *client.Client satisfies it
+type Conn interface {
+	ReadJSON(out interface{}) error
+	WriteJSON(out interface{}) error
+	// Reconnect replaces a dropped connection, the daemon resends the subscriptions itself
+	Reconnect() error
+	Close() error
+}
+
+// Dialer opens and initializes a connection to a network
+type Dialer func(ctx context.Context, chain client.Blockchain) (Conn, error)
+
+// DialClient returns a Dialer opening client connections with opts
+func DialClient(opts client.Opts) Dialer {
+	return func(ctx context.Context, chain client.Blockchain) (Conn, error) {
+		cl, err := client.New(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := cl.Initialize(client.NewBaseMessage(opts.APIKey, chain.System, chain.Network)); err != nil {
+			cl.Close()
+			return nil, err
+		}
+		return cl, nil
+	}
+}
+
+// Opts configures a daemon
+type Opts struct {
+	// config file declaring the networks, subscriptions and sinks
+	Path string
+	// opens the connection of every network
+	Dial Dialer
+	// api key sent with every subscription
+	APIKey string
+	// how often the config file is checked for changes and failed subscriptions
+	// and sinks are retried, defaults to DefaultReloadInterval
+	ReloadInterval time.Duration
+	// directory holding the webhook queues, defaults to the config file's directory
+	StateDir string
+}
+
+// Daemon keeps live subscriptions in line with a config file
+type Daemon struct {
+	opts  Opts
+	sinks *sink.FanOut
+	// serializes reloads, which dial, close and remove outside mx so the health report never waits on them
+	reload sync.Mutex
+
+	mx       sync.Mutex
+	data     []byte
+	spec     *Spec
+	loadedAt time.Time
+	loadErr  error
+	networks map[client.Blockchain]*network
+	dialErrs map[client.Blockchain]error
+	outputs  map[string]SinkSpec
+	sinkErrs map[string]error
+}
+
+// New returns a daemon for the config file at opts.Path, call Run to start it
+func New(opts Opts) *Daemon {
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = DefaultReloadInterval
+	}
+	if opts.StateDir == "" {
+		opts.StateDir = filepath.Dir(opts.Path)
+	}
+	return &Daemon{
+		opts: opts,
+		sinks: sink.NewFanOut(func(name string, ev *client.Event, err error) {
+			log.Printf("sink %s dropped event %s: %v", name, ev.Payload.Event.Transaction.Hash, err)
+		}),
+		networks: make(map[client.Blockchain]*network),
+		dialErrs: make(map[client.Blockchain]error),
+		outputs:  make(map[string]SinkSpec),
+		sinkErrs: make(map[string]error),
+	}
+}
+
+// Run loads the config file, returning an error if it is invalid, and then
+// reconciles the live state with it every ReloadInterval until ctx is done.
+// Later invalid versions of the file are reported and the last valid one is kept.
+func (d *Daemon) Run(ctx context.Context) error {
+	defer d.close()
+	if err := d.Reload(ctx); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(d.opts.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.Reload(ctx); err != nil {
+				log.Println("reloading config: ", err)
+			}
+		}
+	}
+}
+
+// Reload reads the config file if it changed and reconciles the networks,
+// subscriptions and sinks with it. An error is only returned when the file
+// could not be read or a changed file is invalid.
+func (d *Daemon) Reload(ctx context.Context) error {
+	d.reload.Lock()
+	defer d.reload.Unlock()
+	d.mx.Lock()
+	data, err := ioutil.ReadFile(d.opts.Path)
+	switch {
+	case err != nil:
+		err = errors.Wrap(err, "reading config")
+		d.loadErr = err
+	case d.spec == nil || !bytes.Equal(data, d.data):
+		d.data = data
+		var spec *Spec
+		if spec, err = parse(data, filepath.Dir(d.opts.Path)); err != nil {
+			d.loadErr = err
+			break
+		}
+		d.spec, d.loadedAt, d.loadErr = spec, time.Now(), nil
+		log.Printf("loaded config %s", d.opts.Path)
+	}
+	spec := d.spec
+	d.mx.Unlock()
+	if spec != nil {
+		d.reconcileSinks(spec)
+		d.reconcileNetworks(ctx, spec)
+	}
+	return err
+}
+
+// reconcileSinks replaces sinks whose declaration changed and opens new ones, reload must be held.
+// A replaced sink is closed before its successor is opened, so the two never share a path or webhook queue.
+func (d *Daemon) reconcileSinks(spec *Spec) {
+	declared := make(map[string]SinkSpec, len(spec.Sinks))
+	for _, s := range spec.Sinks {
+		declared[s.Name] = s
+	}
+	var removed []string
+	d.mx.Lock()
+	for name, cur := range d.outputs {
+		if s, ok := declared[name]; ok && reflect.DeepEqual(s, cur) {
+			continue
+		}
+		removed = append(removed, name)
+		delete(d.outputs, name)
+	}
+	for name := range d.sinkErrs {
+		if _, ok := declared[name]; !ok {
+			delete(d.sinkErrs, name)
+		}
+	}
+	opening := make(map[string]SinkSpec)
+	for name, s := range declared {
+		if _, ok := d.outputs[name]; !ok {
+			opening[name] = s
+		}
+	}
+	d.mx.Unlock()
+	for _, name := range removed {
+		if err := d.sinks.Remove(name); err != nil {
+			log.Printf("removing sink %s: %v", name, err)
+		}
+	}
+	for name, s := range opening {
+		out, opts, err := OpenSink(s, d.opts.StateDir)
+		if err == nil {
+			err = d.sinks.Add(name, out, opts)
+		}
+		d.mx.Lock()
+		if err != nil {
+			log.Printf("opening sink %s: %v", name, err)
+			d.sinkErrs[name] = err
+		} else {
+			delete(d.sinkErrs, name)
+			d.outputs[name] = s
+		}
+		d.mx.Unlock()
+	}
+}
+
+// OpenSink creates the sink declared by s along with how the fan-out should
+// deliver to it, webhook queues are kept under stateDir
+func OpenSink(s SinkSpec, stateDir string) (sink.Sink, sink.Options, error) {
+	switch s.Type {
+	case FileSink:
+		out, err := sink.NewRotatingFile(s.Path, s.MaxBytes, s.MaxFiles)
+		return out, sink.Options{Retries: sinkRetries}, err
+	case SQLiteSink:
+		out, err := sqlite.Open(s.Path)
+		return out, sink.Options{Retries: sinkRetries}, err
+	case RulesSink:
+		spec, err := rules.Load(s.Path)
+		if err != nil {
+			return nil, sink.Options{}, err
+		}
+		out, err := rules.New(spec, rules.Opts{})
+		return out, sink.Options{}, err
+	case WebhookSink:
+		out, err := webhook.New(webhook.Opts{Dir: filepath.Join(stateDir, "webhooks")}, webhook.Endpoint{
+			Name:        s.Name,
+			URL:         s.URL,
+			Secret:      s.Secret,
+			Headers:     s.Headers,
+			Filters:     s.Filters,
+			MaxAttempts: s.MaxAttempts,
+		})
+		return out, sink.Options{}, err
+	}
+	return nil, sink.Options{}, errors.Errorf("unknown sink type:%v", s.Type)
+}
+
+// reconcileNetworks closes undeclared networks, connects new ones and syncs their subscriptions, reload must be held
+func (d *Daemon) reconcileNetworks(ctx context.Context, spec *Spec) {
+	var closing []*network
+	live := make(map[client.Blockchain]*network)
+	d.mx.Lock()
+	for chain, n := range d.networks {
+		if _, ok := spec.desired[chain]; !ok {
+			closing = append(closing, n)
+			delete(d.networks, chain)
+			continue
+		}
+		live[chain] = n
+	}
+	for chain := range d.dialErrs {
+		if _, ok := spec.desired[chain]; !ok {
+			delete(d.dialErrs, chain)
+		}
+	}
+	d.mx.Unlock()
+	for _, n := range closing {
+		log.Printf("closing network %s/%s", n.chain.System, n.chain.Network)
+		n.close()
+	}
+	for chain, subs := range spec.desired {
+		n, ok := live[chain]
+		if !ok {
+			conn, err := d.opts.Dial(ctx, chain)
+			d.mx.Lock()
+			if err != nil {
+				log.Printf("connecting to %s/%s: %v", chain.System, chain.Network, err)
+				d.dialErrs[chain] = err
+				d.mx.Unlock()
+				continue
+			}
+			delete(d.dialErrs, chain)
+			n = d.connect(ctx, chain, conn)
+			d.networks[chain] = n
+			d.mx.Unlock()
+		}
+		n.reconcile(subs)
+	}
+}
+
+// connect starts reading events from a newly opened connection
+func (d *Daemon) connect(ctx context.Context, chain client.Blockchain, conn Conn) *network {
+	ctx, cancel := context.WithCancel(ctx)
+	apiKey := d.opts.APIKey
+	n := &network{
+		chain: chain,
+		conn:  conn,
+		base: func() client.BaseMessage {
+			return client.NewBaseMessage(apiKey, chain.System, chain.Network)
+		},
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		active:    make(map[route.Key]subscription),
+		connected: true,
+	}
+	go n.read(ctx, d.sinks)
+	return n
+}
+
+// close stops every network and flushes the sinks
+func (d *Daemon) close() {
+	d.reload.Lock()
+	defer d.reload.Unlock()
+	d.mx.Lock()
+	networks := d.networks
+	d.networks = make(map[client.Blockchain]*network)
+	d.mx.Unlock()
+	for _, n := range networks {
+		n.close()
+	}
+	if err := d.sinks.Close(); err != nil {
+		log.Println("closing sinks: ", err)
+	}
+}
+
+// Health reports the state of the daemon
+type Health struct {
+	// ok, or degraded if the config is invalid, a network is disconnected,
+	// a subscription is not in place or a sink could not be opened
+	Status   string                `json:"status"`
+	Config   string                `json:"config"`
+	LoadedAt time.Time             `json:"loadedAt"`
+	Error    string                `json:"error,omitempty"`
+	Networks []NetworkHealth       `json:"networks"`
+	Sinks    map[string]SinkHealth `json:"sinks"`
+}
+
+// NetworkHealth reports the state of a single network
+type NetworkHealth struct {
+	System    string `json:"system"`
+	Network   string `json:"network"`
+	Connected bool   `json:"connected"`
+	// subscriptions in place and subscriptions or removals not yet sent
+	Subscriptions int        `json:"subscriptions"`
+	Pending       int        `json:"pending"`
+	Events        uint64     `json:"events"`
+	LastEvent     *time.Time `json:"lastEvent,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// SinkHealth reports the delivery counters of a sink
+type SinkHealth struct {
+	sink.Stats
+	Error string `json:"error,omitempty"`
+}
+
+// Health returns the state of the daemon
+func (d *Daemon) Health() Health {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	h := Health{
+		Status:   "ok",
+		Config:   d.opts.Path,
+		LoadedAt: d.loadedAt,
+		Sinks:    make(map[string]SinkHealth),
+	}
+	if d.loadErr != nil {
+		h.Status, h.Error = "degraded", d.loadErr.Error()
+	}
+	if d.spec != nil {
+		for chain := range d.spec.desired {
+			nh := NetworkHealth{System: chain.System, Network: chain.Network}
+			if n, ok := d.networks[chain]; ok {
+				nh = n.health(d.spec.desired[chain])
+			} else {
+				nh.Pending = len(d.spec.desired[chain])
+				if err := d.dialErrs[chain]; err != nil {
+					nh.Error = err.Error()
+				}
+			}
+			if !nh.Connected || nh.Pending > 0 {
+				h.Status = "degraded"
+			}
+			h.Networks = append(h.Networks, nh)
+		}
+	}
+	sort.Slice(h.Networks, func(i, j int) bool {
+		if h.Networks[i].System != h.Networks[j].System {
+			return h.Networks[i].System < h.Networks[j].System
+		}
+		return h.Networks[i].Network < h.Networks[j].Network
+	})
+	for name, st := range d.sinks.Stats() {
+		h.Sinks[name] = SinkHealth{Stats: st}
+	}
+	for name, err := range d.sinkErrs {
+		h.Sinks[name] = SinkHealth{Error: err.Error()}
+		h.Status = "degraded"
+	}
+	return h
+}
+
+// ServeHTTP serves the health report, with a 503 status while degraded
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h := d.Health()
+	status := http.StatusOK
+	if h.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(h)
+}
+
+// network is the connection to a single network along with its subscriptions
+type network struct {
+	chain  client.Blockchain
+	conn   Conn
+	base   func() client.BaseMessage
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mx        sync.Mutex
+	desired   map[route.Key]subscription
+	active    map[route.Key]subscription
+	connected bool
+	err       error
+	events    uint64
+	lastEvent time.Time
+}
+
+// reconcile sets the declared subscriptions and sends the difference to the live ones
+func (n *network) reconcile(desired map[route.Key]subscription) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.desired = desired
+	if n.connected {
+		n.sync()
+	}
+}
+
+// sync removes undeclared subscriptions and sends new or changed ones, mx
+// must be held. Subscriptions that fail to send are retried by the next sync.
+func (n *network) sync() {
+	for key, sub := range n.active {
+		if _, ok := n.desired[key]; ok {
+			// changed configs are replaced by putting them again
+			continue
+		}
+		if err := n.conn.WriteJSON(n.unsubscribe(sub)); err != nil {
+			n.err = errors.Wrap(err, "unsubscribing")
+			return
+		}
+		delete(n.active, key)
+		if key.Kind == route.Config {
+			// removing a config unwatches its scope, which has to be watched again if it is declared
+			delete(n.active, route.AddressKey(key.Value))
+		}
+	}
+	for key, sub := range n.desired {
+		if cur, ok := n.active[key]; ok && cur.digest == sub.digest {
+			continue
+		}
+		if err := n.conn.WriteJSON(n.subscribe(sub)); err != nil {
+			n.err = errors.Wrap(err, "subscribing")
+			return
+		}
+		n.active[key] = sub
+	}
+	n.err = nil
+}
+
+func (n *network) subscribe(sub subscription) interface{} {
+	switch sub.key.Kind {
+	case route.Address:
+		return client.NewAddressSubscribe(n.base(), sub.key.Value)
+	case route.Transaction:
+		return client.NewTxSubscribe(n.base(), sub.key.Value)
+	}
+	return client.NewConfiguration(n.base(), *sub.config)
+}
+
+func (n *network) unsubscribe(sub subscription) interface{} {
+	switch {
+	case sub.key.Kind == route.Transaction:
+		return client.NewTxUnsubscribe(n.base(), sub.key.Value)
+	case sub.key.Kind == route.Config && sub.key.Value == "global":
+		// the global config can not be removed, so it is reset to one without filters
+		return client.NewConfiguration(n.base(), client.NewConfig("global", false, nil))
+	}
+	return client.NewAddressUnsubscribe(n.base(), sub.key.Value)
+}
+
+// read writes events to out until ctx is done, reconnecting whenever the connection drops
+func (n *network) read(ctx context.Context, out sink.Sink) {
+	defer close(n.done)
+	for {
+		var raw json.RawMessage
+		if err := n.conn.ReadJSON(&raw); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("connection to %s/%s lost, reconnecting: %v", n.chain.System, n.chain.Network, err)
+			n.mx.Lock()
+			n.connected, n.err = false, err
+			n.mx.Unlock()
+			if !n.reconnect(ctx) {
+				return
+			}
+			continue
+		}
+		ev, err := client.NewEvent(time.Now(), raw)
+		if err != nil {
+			log.Println("skipping malformed message: ", err)
+			continue
+		}
+		if ev.Payload.Status != "" && ev.Payload.Status != "ok" {
+			log.Printf("%s/%s error: %s", n.chain.System, n.chain.Network, raw)
+			continue
+		}
+		if ev.Payload.Event.Transaction.Hash == "" {
+			// acknowledgements
+			continue
+		}
+		n.mx.Lock()
+		n.events++
+		n.lastEvent = ev.ReceivedAt
+		n.mx.Unlock()
+		if err := out.Write(ctx, ev); err != nil && ctx.Err() == nil {
+			log.Println("writing event: ", err)
+		}
+	}
+}
+
+// reconnect retries with exponential backoff until it succeeds, resending
+// every declared subscription, or until ctx is done
+func (n *network) reconnect(ctx context.Context) bool {
+	backoff := reconnectBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if err := n.conn.Reconnect(); err != nil {
+			log.Printf("reconnecting to %s/%s failed: %v", n.chain.System, n.chain.Network, err)
+			n.mx.Lock()
+			n.err = err
+			n.mx.Unlock()
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+		log.Printf("reconnected to %s/%s", n.chain.System, n.chain.Network)
+		n.mx.Lock()
+		n.connected = true
+		n.active = make(map[route.Key]subscription)
+		n.sync()
+		n.mx.Unlock()
+		return true
+	}
+}
+
+// close stops reading and closes the connection
+func (n *network) close() {
+	n.cancel()
+	n.conn.Close()
+	select {
+	case <-n.done:
+	case <-time.After(closeTimeout):
+	}
+}
+
+// health reports the network against the declared subscriptions, which it
+// may not have been reconciled with yet while a reload is in progress
+func (n *network) health(desired map[route.Key]subscription) NetworkHealth {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	h := NetworkHealth{
+		System:        n.chain.System,
+		Network:       n.chain.Network,
+		Connected:     n.connected,
+		Subscriptions: len(n.active),
+		Events:        n.events,
+	}
+	for key, sub := range desired {
+		if cur, ok := n.active[key]; !ok || cur.digest != sub.digest {
+			h.Pending++
+		}
+	}
+	for key := range n.active {
+		if _, ok := desired[key]; !ok {
+			h.Pending++
+		}
+	}
+	if !n.lastEvent.IsZero() {
+		last := n.lastEvent
+		h.LastEvent = &last
+	}
+	if n.err != nil {
+		h.Error = n.err.Error()
+	}
+	return h
+}
@@ -0,0 +1,399 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn records sent messages and serves frames pushed by the test
+type fakeConn struct {
+	mx         sync.Mutex
+	sent       []string
+	reconnects int
+	frames     chan string
+	closed     sync.Once
+}
+
+func (f *fakeConn) WriteJSON(out interface{}) error {
+	data, _ := json.Marshal(out)
+	var msg struct {
+		EventCode string `json:"eventCode"`
+		Account   struct {
+			Address string `json:"address"`
+		} `json:"account"`
+		Transaction struct {
+			Hash string `json:"hash"`
+		} `json:"transaction"`
+		Config struct {
+			Scope   string              `json:"scope"`
+			Filters []map[string]string `json:"filters"`
+		} `json:"config"`
+	}
+	json.Unmarshal(data, &msg)
+	sent := msg.EventCode + " " + msg.Account.Address + msg.Transaction.Hash + msg.Config.Scope
+	if len(msg.Config.Filters) > 0 {
+		filters, _ := json.Marshal(msg.Config.Filters)
+		sent += " " + string(filters)
+	}
+	f.mx.Lock()
+	f.sent = append(f.sent, sent)
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *fakeConn) ReadJSON(out interface{}) error {
+	frame, ok := <-f.frames
+	if !ok {
+		return io.EOF
+	}
+	if frame == "drop" {
+		return io.ErrUnexpectedEOF
+	}
+	return json.Unmarshal([]byte(frame), out)
+}
+
+func (f *fakeConn) Reconnect() error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.reconnects++
+	return nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closed.Do(func() { close(f.frames) })
+	return nil
+}
+
+// take returns and clears the messages sent so far
+func (f *fakeConn) take() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	sent := f.sent
+	f.sent = nil
+	return sent
+}
+
+const (
+	addrA  = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	addrB  = "0x88df592f8eb5d7bd38bfef7deb0fbc02cf3778a0"
+	router = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+	hash   = "0xa1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1"
+)
+
+func TestDaemon(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watch.yaml")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "router.json"), []byte(`[{"name":"swapExactETHForTokens","type":"function","inputs":[]}]`), 0644))
+	writeConfig := func(config string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+	}
+	writeConfig(`
+networks:
+  - network: "1"
+    addresses: [` + addrA[:2] + strings.ToUpper(addrA[2:]) + `]
+    transactions: [` + hash + `]
+    contracts:
+      - scope: ` + router + `
+        abi: router.json
+        filters: [{status: pending}]
+sinks:
+  - name: events
+    type: file
+    path: events.ndjson
+`)
+
+	var (
+		mx    sync.Mutex
+		conns = make(map[client.Blockchain]*fakeConn)
+	)
+	dial := func(ctx context.Context, chain client.Blockchain) (Conn, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		conn := &fakeConn{frames: make(chan string)}
+		conns[chain] = conn
+		return conn, nil
+	}
+	conn := func(network string) *fakeConn {
+		mx.Lock()
+		defer mx.Unlock()
+		return conns[client.Blockchain{System: "ethereum", Network: network}]
+	}
+
+	d := New(Opts{Path: path, Dial: dial, APIKey: "key", ReloadInterval: time.Millisecond * 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return conn("main") != nil }, time.Second, time.Millisecond)
+	main := conn("main")
+	require.Eventually(t, func() bool { return d.Health().Status == "ok" }, time.Second, time.Millisecond)
+	require.ElementsMatch(t, []string{
+		"watch " + addrA,
+		"txSent " + hash,
+		`put ` + router + ` [{"status":"pending"}]`,
+	}, main.take())
+
+	// acknowledgements are skipped and events reach the sinks
+	main.frames <- `{"status":"ok","event":{"categoryCode":"accountAddress","eventCode":"watch"}}`
+	main.frames <- `{"status":"ok","event":{"eventCode":"txPool","transaction":{"hash":"` + hash + `","status":"pending"}}}`
+	require.Eventually(t, func() bool {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "events.ndjson"))
+		return strings.Contains(string(data), hash)
+	}, time.Second, time.Millisecond)
+
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	var health Health
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, health.Networks, 1)
+	require.Equal(t, NetworkHealth{
+		System:        "ethereum",
+		Network:       "main",
+		Connected:     true,
+		Subscriptions: 3,
+		Events:        1,
+		LastEvent:     health.Networks[0].LastEvent,
+	}, health.Networks[0])
+	require.NotNil(t, health.Networks[0].LastEvent)
+	require.Equal(t, uint64(1), health.Sinks["events"].Written)
+
+	// only the difference is sent when the file changes
+	writeConfig(`
+networks:
+  - network: main
+    addresses: [` + addrA + `, ` + addrB + `]
+    contracts:
+      - scope: ` + router + `
+        abi: router.json
+        filters: [{status: confirmed}]
+  - network: goerli
+    addresses: [` + addrA + `]
+sinks:
+  - name: events
+    type: file
+    path: events.ndjson
+`)
+	require.Eventually(t, func() bool { return conn("goerli") != nil }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return d.Health().Status == "ok" }, time.Second, time.Millisecond)
+	require.ElementsMatch(t, []string{
+		"unwatch " + hash,
+		"watch " + addrB,
+		`put ` + router + ` [{"status":"confirmed"}]`,
+	}, main.take())
+	require.Equal(t, []string{"watch " + addrA}, conn("goerli").take())
+
+	// an invalid file is reported and the last valid state is kept
+	writeConfig(`
+networks:
+  - network: main
+    addresses: [0x1234]
+`)
+	require.Eventually(t, func() bool { return d.Health().Error != "" }, time.Second, time.Millisecond)
+	health = d.Health()
+	require.Equal(t, "degraded", health.Status)
+	require.Contains(t, health.Error, "invalid address")
+	require.Len(t, health.Networks, 2)
+	require.Empty(t, main.take())
+
+	// removing a config unwatches its scope and subscriptions are resent after reconnecting
+	writeConfig(`
+networks:
+  - addresses: [` + addrA + `]
+`)
+	require.Eventually(t, func() bool {
+		health := d.Health()
+		return len(health.Networks) == 1 && health.Status == "ok"
+	}, time.Second, time.Millisecond)
+	require.ElementsMatch(t, []string{"unwatch " + addrB, "unwatch " + router}, main.take())
+	main.frames <- "drop"
+	require.Eventually(t, func() bool {
+		main.mx.Lock()
+		defer main.mx.Unlock()
+		return main.reconnects == 1 && len(main.sent) == 1
+	}, time.Second*3, time.Millisecond*10)
+	require.Equal(t, []string{"watch " + addrA}, main.take())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestReloadSink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watch.yaml")
+	var (
+		mx        sync.Mutex
+		requests  int
+		delivered = make(map[string][]string)
+	)
+	// deliveries are slow so the sink is replaced while one is in flight
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		mx.Lock()
+		requests++
+		mx.Unlock()
+		// aborted deliveries stay queued and are not counted
+		select {
+		case <-time.After(time.Millisecond * 100):
+		case <-r.Context().Done():
+			return
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		id := r.Header.Get("X-Blocknative-Delivery")
+		delivered[id] = append(delivered[id], r.Header.Get("X-Version"))
+	}))
+	defer srv.Close()
+	writeConfig := func(version string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(`
+networks:
+  - addresses: [`+addrA+`]
+sinks:
+  - name: hook
+    type: webhook
+    url: `+srv.URL+`
+    headers: {X-Version: "`+version+`"}
+`), 0644))
+	}
+	writeConfig("1")
+	conn := &fakeConn{frames: make(chan string)}
+	dial := func(ctx context.Context, chain client.Blockchain) (Conn, error) { return conn, nil }
+	d := New(Opts{Path: path, Dial: dial, ReloadInterval: time.Millisecond * 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	for _, hash := range []string{"0x01", "0x02", "0x03"} {
+		conn.frames <- `{"status":"ok","event":{"eventCode":"txPool","transaction":{"hash":"` + hash + `"}}}`
+	}
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return requests > 0
+	}, time.Second, time.Millisecond)
+
+	// the replacement only takes over the queue once the old sink is closed
+	writeConfig("2")
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(delivered) == 3
+	}, time.Second*5, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 300)
+	cancel()
+	require.NoError(t, <-done)
+	mx.Lock()
+	defer mx.Unlock()
+	var replaced int
+	for id, versions := range delivered {
+		require.Len(t, versions, 1, id)
+		if versions[0] == "2" {
+			replaced++
+		}
+	}
+	require.NotZero(t, replaced)
+}
+
+func TestHealthDuringReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+networks:
+  - addresses: [`+addrA+`]
+`), 0644))
+	dialing, release := make(chan struct{}), make(chan struct{})
+	dial := func(ctx context.Context, chain client.Blockchain) (Conn, error) {
+		close(dialing)
+		<-release
+		return &fakeConn{frames: make(chan string)}, nil
+	}
+	d := New(Opts{Path: path, Dial: dial})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	<-dialing
+	// the health report does not wait for a reload's dials
+	health := make(chan Health)
+	go func() { health <- d.Health() }()
+	select {
+	case h := <-health:
+		require.Equal(t, "degraded", h.Status)
+		require.Equal(t, 1, h.Networks[0].Pending)
+	case <-time.After(time.Second):
+		t.Fatal("health blocked by a reload")
+	}
+	close(release)
+	require.Eventually(t, func() bool { return d.Health().Status == "ok" }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watch.yaml")
+	load := func(config string) error {
+		require.NoError(t, ioutil.WriteFile(path, []byte(config), 0644))
+		_, err := Load(path)
+		return err
+	}
+	require.NoError(t, load(``))
+	require.NoError(t, load(`
+networks:
+  - network: matic-main
+    contracts: [{scope: global, filters: [{to: `+router+`}]}]
+sinks:
+  - {name: db, type: sqlite, path: events.db}
+  - {name: hook, type: webhook, url: "http://localhost/events"}
+`))
+	spec, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "events.db"), spec.Sinks[0].Path)
+	require.Equal(t, "matic-main", spec.Networks[0].Network)
+
+	require.Error(t, load(`networks: [{adresses: [`+addrA+`]}]`))
+	require.Error(t, load(`networks: [{network: mainnet}]`))
+	require.Error(t, load(`networks: [{network: main}, {network: "1"}]`))
+	require.Error(t, load(`networks: [{transactions: [0x01]}]`))
+	require.Error(t, load(`networks: [{contracts: [{scope: 0x01}]}]`))
+	require.Error(t, load(`networks: [{contracts: [{scope: `+router+`, abi: missing.json}]}]`))
+	require.Error(t, load(`sinks: [{name: a, type: file}]`))
+	require.Error(t, load(`sinks: [{name: a, type: kafka}]`))
+	require.Error(t, load(`sinks: [{name: a, type: webhook, url: "http://a"}, {name: a, type: webhook, url: "http://b"}]`))
+}
+
+func TestParseSinkURL(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	for raw, want := range map[string]SinkSpec{
+		"file:events.ndjson?maxBytes=1024&maxFiles=2": {Name: "file", Type: FileSink, Path: filepath.Join(wd, "events.ndjson"), MaxBytes: 1024, MaxFiles: 2},
+		"sqlite:///var/lib/events.db?name=db":         {Name: "db", Type: SQLiteSink, Path: "/var/lib/events.db"},
+		"webhook://localhost:9000/events?secret=s&maxAttempts=3&team=ops": {
+			Name: "webhook", Type: WebhookSink, URL: "http://localhost:9000/events?team=ops", Secret: "s", MaxAttempts: 3,
+		},
+		"webhook+https://example.com/events": {Name: "webhook", Type: WebhookSink, URL: "https://example.com/events"},
+		"rules:alerts.yaml":                  {Name: "rules", Type: RulesSink, Path: filepath.Join(wd, "alerts.yaml")},
+	} {
+		s, err := ParseSinkURL(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, want, s, raw)
+	}
+	for _, raw := range []string{"file:", "webhook:///events", "kafka://localhost", "file:a?maxBytes=big", "rules:"} {
+		_, err := ParseSinkURL(raw)
+		require.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,282 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/route"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Sink types supported by SinkSpec
+const (
+	FileSink    = "file"
+	WebhookSink = "webhook"
+	SQLiteSink  = "sqlite"
+	RulesSink   = "rules"
+)
+
+// Spec is the declared state read from the config file
+type Spec struct {
+	Networks []NetworkSpec `yaml:"networks"`
+	Sinks    []SinkSpec    `yaml:"sinks"`
+
+	// the subscriptions declared for every network
+	desired map[client.Blockchain]map[route.Key]subscription
+}
+
+// NetworkSpec declares what is watched on a single network, each network gets its own connection
+type NetworkSpec struct {
+	// defaults to ethereum
+	System string `yaml:"system"`
+	// network by name or chain id, defaults to main
+	Network      string         `yaml:"network"`
+	Addresses    []string       `yaml:"addresses"`
+	Transactions []string       `yaml:"transactions"`
+	Contracts    []ContractSpec `yaml:"contracts"`
+}
+
+// ContractSpec declares a config put for a scope
+type ContractSpec struct {
+	// contract address or global
+	Scope string `yaml:"scope"`
+	// path to the contract's json abi, relative to the config file
+	ABI          string              `yaml:"abi"`
+	Filters      []map[string]string `yaml:"filters"`
+	WatchAddress bool                `yaml:"watchAddress"`
+}
+
+// SinkSpec declares a destination every event is written to
+type SinkSpec struct {
+	// unique name, used in health reports and as the webhook queue directory
+	Name string `yaml:"name"`
+	// one of file, webhook, sqlite or rules
+	Type string `yaml:"type"`
+	// file, sqlite and rules: path of the file, database or rules file, relative to the config file
+	Path string `yaml:"path"`
+	// file: size at which the file is rotated and number of rotated files kept
+	MaxBytes int64 `yaml:"maxBytes"`
+	MaxFiles int   `yaml:"maxFiles"`
+	// webhook: see webhook.Endpoint
+	URL         string                   `yaml:"url"`
+	Secret      string                   `yaml:"secret"`
+	Headers     map[string]string        `yaml:"headers"`
+	Filters     []map[string]interface{} `yaml:"filters"`
+	MaxAttempts int                      `yaml:"maxAttempts"`
+}
+
+// subscription is a single declared subscription, config is only set for config keys
+type subscription struct {
+	key    route.Key
+	config *client.Config
+	// digest changes whenever the subscription has to be sent again
+	digest string
+}
+
+// Load reads and validates a config file, normalizing addresses, hashes and
+// networks and reading contract abis
+func Load(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config")
+	}
+	return parse(data, filepath.Dir(path))
+}
+
+// parse decodes a config, resolving relative paths against dir
+func parse(data []byte, dir string) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "decoding config")
+	}
+	spec.desired = make(map[client.Blockchain]map[route.Key]subscription)
+	for i, n := range spec.Networks {
+		if n.System == "" {
+			n.System = "ethereum"
+		}
+		if n.Network == "" {
+			n.Network = "main"
+		}
+		network, err := client.ParseNetwork(n.Network)
+		if err != nil {
+			return nil, err
+		}
+		chain := client.Blockchain{System: n.System, Network: network}
+		if _, ok := spec.desired[chain]; ok {
+			return nil, errors.Errorf("network declared twice:%v/%v", chain.System, chain.Network)
+		}
+		subs, err := n.subscriptions(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network %v/%v", chain.System, chain.Network)
+		}
+		spec.desired[chain] = subs
+		spec.Networks[i].System, spec.Networks[i].Network = chain.System, chain.Network
+	}
+	names := make(map[string]bool, len(spec.Sinks))
+	for i, s := range spec.Sinks {
+		if s.Name == "" {
+			return nil, errors.New("sink name must be set")
+		}
+		if names[s.Name] {
+			return nil, errors.Errorf("sink declared twice:%v", s.Name)
+		}
+		names[s.Name] = true
+		if err := spec.Sinks[i].resolve(dir); err != nil {
+			return nil, err
+		}
+	}
+	return &spec, nil
+}
+
+// resolve checks the sink's settings, resolving a relative path against dir
+func (s *SinkSpec) resolve(dir string) error {
+	switch s.Type {
+	case FileSink, SQLiteSink, RulesSink:
+		if s.Path == "" {
+			return errors.Errorf("sink %v: path must be set", s.Name)
+		}
+		if !filepath.IsAbs(s.Path) {
+			s.Path = filepath.Join(dir, s.Path)
+		}
+	case WebhookSink:
+		if s.URL == "" {
+			return errors.Errorf("sink %v: url must be set", s.Name)
+		}
+	default:
+		return errors.Errorf("sink %v: unknown type:%v", s.Name, s.Type)
+	}
+	return nil
+}
+
+// ParseSinkURL declares a sink from a url, naming it after its type unless a
+// name parameter is given:
+//
+//	file:events.ndjson?maxBytes=1048576&maxFiles=5
+//	sqlite:///var/lib/events.db
+//	rules:alerts.yaml
+//	webhook://localhost:9000/events?secret=s&maxAttempts=3  posts over http
+//	webhook+https://example.com/events                      posts over https
+//
+// relative paths are resolved against the working directory, and webhook
+// parameters other than name, secret and maxAttempts are kept in the posted url
+func ParseSinkURL(raw string) (SinkSpec, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return SinkSpec{}, errors.Wrap(err, "parsing sink url")
+	}
+	query := u.Query()
+	s := SinkSpec{Name: query.Get("name"), Type: u.Scheme}
+	query.Del("name")
+	number := func(key string) (int64, error) {
+		v := query.Get(key)
+		query.Del(key)
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, errors.Wrapf(err, "sink url %v", key)
+	}
+	switch u.Scheme {
+	case FileSink, SQLiteSink, RulesSink:
+		s.Path = u.Opaque
+		if s.Path == "" {
+			s.Path = u.Host + u.Path
+		}
+		if s.MaxBytes, err = number("maxBytes"); err != nil {
+			return SinkSpec{}, err
+		}
+		maxFiles, err := number("maxFiles")
+		if err != nil {
+			return SinkSpec{}, err
+		}
+		s.MaxFiles = int(maxFiles)
+	case WebhookSink, WebhookSink + "+http", WebhookSink + "+https":
+		s.Type = WebhookSink
+		s.Secret = query.Get("secret")
+		query.Del("secret")
+		attempts, err := number("maxAttempts")
+		if err != nil {
+			return SinkSpec{}, err
+		}
+		s.MaxAttempts = int(attempts)
+		target := *u
+		target.Scheme = "http"
+		if u.Scheme == WebhookSink+"+https" {
+			target.Scheme = "https"
+		}
+		target.RawQuery = query.Encode()
+		if u.Host != "" {
+			s.URL = target.String()
+		}
+	}
+	if s.Name == "" {
+		s.Name = s.Type
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return SinkSpec{}, err
+	}
+	return s, s.resolve(dir)
+}
+
+// subscriptions returns the subscriptions declared for the network keyed by route key
+func (n NetworkSpec) subscriptions(dir string) (map[route.Key]subscription, error) {
+	subs := make(map[route.Key]subscription)
+	for _, addr := range n.Addresses {
+		addr, err := client.NormalizeAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		key := route.AddressKey(addr)
+		subs[key] = subscription{key: key, digest: key.String()}
+	}
+	for _, hash := range n.Transactions {
+		hash, err := client.NormalizeHash(hash)
+		if err != nil {
+			return nil, err
+		}
+		key := route.TxKey(hash)
+		subs[key] = subscription{key: key, digest: key.String()}
+	}
+	for _, c := range n.Contracts {
+		var abi interface{}
+		if c.ABI != "" {
+			path := c.ABI
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading abi")
+			}
+			if err := json.Unmarshal(data, &abi); err != nil {
+				return nil, errors.Wrapf(err, "decoding abi %v", c.ABI)
+			}
+		}
+		cfg, err := client.NewConfigChecked(c.Scope, c.WatchAddress, abi)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Filters = c.Filters
+		key := route.ConfigKey(cfg.Scope)
+		if _, ok := subs[key]; ok {
+			return nil, errors.Errorf("contract declared twice:%v", cfg.Scope)
+		}
+		digest, err := json.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		subs[key] = subscription{key: key, config: &cfg, digest: string(digest)}
+	}
+	return subs, nil
+}
@@ -0,0 +1,431 @@
+// Package dashboard keeps the state shown by the terminal dashboard: the
+// pending and finished transactions of every watched address along with the
+// events that moved them through their lifecycle, the latest base fee, a
+// bounded log of events and the health of the connection. It holds no
+// rendering code so the state can be built from recordings.
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+)
+
+const (
+	// DefaultMaxLog is how many log entries are kept when Opts.MaxLog is unset
+	DefaultMaxLog = 500
+	// DefaultMaxDone is how many finished transactions are kept per address when Opts.MaxDone is unset
+	DefaultMaxDone = 20
+	// DefaultMaxPending is how many pending transactions are kept per address when Opts.MaxPending is unset
+	DefaultMaxPending = 200
+	// DefaultMaxTransitions is how many transitions are kept per transaction when Opts.MaxTransitions is unset
+	DefaultMaxTransitions = 50
+)
+
+// Replaced is the status of a pending transaction whose nonce was confirmed by another transaction
+const Replaced = "replaced"
+
+// event codes sent by blocknative for transactions that left the mempool
+var finalCodes = map[string]bool{
+	"txConfirmed": true,
+	"txFailed":    true,
+	"txDropped":   true,
+	"txRejected":  true,
+}
+
+// Opts configures a dashboard
+type Opts struct {
+	// Addresses get a panel from the start, in this order, others get one when their first event arrives
+	Addresses []string
+	MaxLog    int
+	MaxDone   int
+	// MaxPending bounds the pending transactions of an address, the least
+	// recently updated are dropped first, as blocknative may never report
+	// them again
+	MaxPending int
+	// MaxTransitions bounds the transitions of a transaction, the oldest are dropped first
+	MaxTransitions int
+}
+
+// Transition is a single step in the lifecycle of a transaction
+type Transition struct {
+	Time      time.Time `json:"time"`
+	EventCode string    `json:"eventCode"`
+	// Hash is the other transaction of a speedup, cancel or replacement
+	Hash string `json:"hash,omitempty"`
+}
+
+// Row is a transaction of a watched address
+type Row struct {
+	Hash  string `json:"hash"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Nonce int    `json:"nonce"`
+	Value string `json:"value"`
+	// FeeCap is the most the transaction pays per gas, its max fee or gas price
+	FeeCap *big.Int `json:"feeCap"`
+	// Status is the code of the latest event, or Replaced
+	Status    string    `json:"status"`
+	FirstSeen time.Time `json:"firstSeen"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Replaces is the transaction this one sped up or cancelled
+	Replaces string `json:"replaces,omitempty"`
+	// ReplacedBy is the transaction that sped up, cancelled or replaced this one
+	ReplacedBy  string       `json:"replacedBy,omitempty"`
+	Transitions []Transition `json:"transitions"`
+	// Event is the latest event for the transaction
+	Event *client.Event `json:"event"`
+	Done  bool          `json:"done"`
+}
+
+// Age returns how long a pending transaction has been pending, or how long a
+// finished one was pending for
+func (r Row) Age(now time.Time) time.Duration {
+	if r.Done {
+		return r.UpdatedAt.Sub(r.FirstSeen)
+	}
+	return now.Sub(r.FirstSeen)
+}
+
+// Underpriced reports whether the transaction's fee cap is below baseFee, it
+// can not be mined until the base fee drops
+func (r Row) Underpriced(baseFee *big.Int) bool {
+	return r.FeeCap != nil && baseFee != nil && r.FeeCap.Cmp(baseFee) < 0
+}
+
+// Panel holds the transactions of a single watched address
+type Panel struct {
+	Address string `json:"address"`
+	// Pending is ordered by sender and nonce
+	Pending []Row `json:"pending"`
+	// Done holds confirmed, failed, dropped and replaced transactions, most recent first
+	Done []Row `json:"done"`
+}
+
+// Entry is a line of the event log
+type Entry struct {
+	Time time.Time `json:"time"`
+	// EventCode and Hash are empty for messages that are not events
+	EventCode string `json:"eventCode,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Health describes the connection
+type Health struct {
+	Connected  bool      `json:"connected"`
+	Reconnects int       `json:"reconnects"`
+	Events     uint64    `json:"events"`
+	LastEvent  time.Time `json:"lastEvent"`
+	// Error is why the connection was lost, cleared once it is back
+	Error string `json:"error,omitempty"`
+}
+
+// Snapshot is a copy of the dashboard's state
+type Snapshot struct {
+	Panels []Panel `json:"panels"`
+	// Log is ordered oldest first
+	Log     []Entry  `json:"log"`
+	Block   int      `json:"block"`
+	BaseFee *big.Int `json:"baseFee"`
+	Health  Health   `json:"health"`
+}
+
+type panel struct {
+	address string
+	rows    map[string]*Row
+}
+
+// Dashboard applies events to the dashboard's state, it is safe for concurrent use
+type Dashboard struct {
+	opts Opts
+
+	mx      sync.Mutex
+	panels  []*panel
+	byAddr  map[string]*panel
+	byHash  map[string]*panel
+	log     []Entry
+	block   int
+	baseFee *big.Int
+	health  Health
+}
+
+// New returns a dashboard with a panel for each of opts.Addresses
+func New(opts Opts) *Dashboard {
+	if opts.MaxLog <= 0 {
+		opts.MaxLog = DefaultMaxLog
+	}
+	if opts.MaxDone <= 0 {
+		opts.MaxDone = DefaultMaxDone
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = DefaultMaxPending
+	}
+	if opts.MaxTransitions <= 0 {
+		opts.MaxTransitions = DefaultMaxTransitions
+	}
+	d := &Dashboard{
+		opts:   opts,
+		byAddr: make(map[string]*panel),
+		byHash: make(map[string]*panel),
+	}
+	for _, addr := range opts.Addresses {
+		d.panel(addr)
+	}
+	return d
+}
+
+// panel returns the panel of addr, adding it if needed, the lock must be held
+func (d *Dashboard) panel(addr string) *panel {
+	addr = strings.ToLower(addr)
+	p, ok := d.byAddr[addr]
+	if !ok {
+		p = &panel{address: addr, rows: make(map[string]*Row)}
+		d.byAddr[addr] = p
+		d.panels = append(d.panels, p)
+	}
+	return p
+}
+
+// Apply updates the dashboard with an event, ignoring events that do not refer to a transaction
+func (d *Dashboard) Apply(ev *client.Event) {
+	tx := ev.Payload.Event.Transaction
+	code := ev.Payload.Event.EventCode
+	if tx.Hash == "" {
+		return
+	}
+	hash := strings.ToLower(tx.Hash)
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.health.Events++
+	d.health.LastEvent = ev.ReceivedAt
+	if code == "txConfirmed" && tx.BlockNumber >= d.block {
+		d.block = tx.BlockNumber
+		if fee, ok := new(big.Int).SetString(tx.BaseFeePerGas, 10); ok {
+			d.baseFee = fee
+		}
+	}
+
+	// events for a known transaction stay in its panel
+	p, ok := d.byHash[hash]
+	if !ok {
+		addr := tx.WatchedAddress
+		if addr == "" {
+			addr = tx.From
+		}
+		p = d.panel(addr)
+		d.byHash[hash] = p
+	}
+	row, known := p.rows[hash]
+	if !known {
+		row = &Row{Hash: hash, FirstSeen: ev.ReceivedAt}
+		p.rows[hash] = row
+	}
+	row.From, row.To = strings.ToLower(tx.From), strings.ToLower(tx.To)
+	row.Nonce, row.Value = tx.Nonce, tx.Value
+	row.FeeCap = feeCap(tx)
+	// a late pending event does not bring back a finished transaction
+	if !row.Done || finalCodes[code] {
+		row.Status, row.UpdatedAt = code, ev.ReceivedAt
+	}
+	row.Event = ev
+	d.transition(row, Transition{Time: ev.ReceivedAt, EventCode: code})
+
+	if replaced := strings.ToLower(tx.ReplaceHash); replaced != "" {
+		row.Replaces = replaced
+		if old := d.row(replaced); old != nil {
+			old.ReplacedBy, old.Status, old.UpdatedAt = hash, code, ev.ReceivedAt
+			d.transition(old, Transition{Time: ev.ReceivedAt, EventCode: code, Hash: hash})
+			d.finish(old)
+		}
+	}
+	if finalCodes[code] {
+		d.finish(row)
+	}
+	if code == "txConfirmed" {
+		// once a nonce is mined every pending transaction at or below it is invalid
+		for _, other := range p.rows {
+			if other.Done || other.From != row.From || other.Nonce > row.Nonce {
+				continue
+			}
+			other.Status, other.UpdatedAt = Replaced, ev.ReceivedAt
+			if other.Nonce == row.Nonce {
+				other.ReplacedBy = hash
+			}
+			d.transition(other, Transition{Time: ev.ReceivedAt, EventCode: Replaced, Hash: hash})
+			d.finish(other)
+		}
+	}
+	if !known {
+		d.prune(p)
+	}
+	d.appendLog(Entry{Time: ev.ReceivedAt, EventCode: code, Hash: hash, Message: describe(tx)})
+}
+
+// row returns the transaction with the given hash from any panel, the lock must be held
+func (d *Dashboard) row(hash string) *Row {
+	if p, ok := d.byHash[hash]; ok {
+		return p.rows[hash]
+	}
+	return nil
+}
+
+// finish marks a transaction as done, evicting the oldest finished
+// transactions of its panel beyond MaxDone, the lock must be held
+func (d *Dashboard) finish(row *Row) {
+	row.Done = true
+	p := d.byHash[row.Hash]
+	var done []*Row
+	for _, r := range p.rows {
+		if r.Done {
+			done = append(done, r)
+		}
+	}
+	if len(done) <= d.opts.MaxDone {
+		return
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].UpdatedAt.After(done[j].UpdatedAt) })
+	for _, r := range done[d.opts.MaxDone:] {
+		delete(p.rows, r.Hash)
+		delete(d.byHash, r.Hash)
+	}
+}
+
+// prune drops the least recently updated pending transactions of a panel
+// beyond MaxPending, the lock must be held
+func (d *Dashboard) prune(p *panel) {
+	var pending []*Row
+	for _, r := range p.rows {
+		if !r.Done {
+			pending = append(pending, r)
+		}
+	}
+	if len(pending) <= d.opts.MaxPending {
+		return
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].UpdatedAt.After(pending[j].UpdatedAt) })
+	for _, r := range pending[d.opts.MaxPending:] {
+		delete(p.rows, r.Hash)
+		delete(d.byHash, r.Hash)
+	}
+}
+
+// transition adds a step to a transaction, dropping the oldest beyond
+// MaxTransitions, the lock must be held
+func (d *Dashboard) transition(row *Row, t Transition) {
+	row.Transitions = append(row.Transitions, t)
+	if n := len(row.Transitions) - d.opts.MaxTransitions; n > 0 {
+		row.Transitions = append(row.Transitions[:0], row.Transitions[n:]...)
+	}
+}
+
+// appendLog adds an entry, dropping the oldest beyond MaxLog, the lock must be held
+func (d *Dashboard) appendLog(e Entry) {
+	d.log = append(d.log, e)
+	if n := len(d.log) - d.opts.MaxLog; n > 0 {
+		d.log = append(d.log[:0], d.log[n:]...)
+	}
+}
+
+// Logf adds a message to the event log
+func (d *Dashboard) Logf(format string, args ...interface{}) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.appendLog(Entry{Time: time.Now(), Message: fmt.Sprintf(format, args...)})
+}
+
+// Write adds every line of p to the event log, so the dashboard can be the output of a logger
+func (d *Dashboard) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if line := strings.TrimSpace(string(line)); line != "" {
+			d.Logf("%s", line)
+		}
+	}
+	return len(p), nil
+}
+
+// Connected records that the connection is up, counting reconnects after the first call
+func (d *Dashboard) Connected() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.health.Error != "" {
+		d.health.Reconnects++
+	}
+	d.health.Connected, d.health.Error = true, ""
+}
+
+// Disconnected records that the connection was lost
+func (d *Dashboard) Disconnected(err error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.health.Connected = false
+	d.health.Error = "connection lost"
+	if err != nil {
+		d.health.Error = err.Error()
+	}
+}
+
+// Snapshot returns a copy of the dashboard's state
+func (d *Dashboard) Snapshot() Snapshot {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	out := Snapshot{
+		Panels: make([]Panel, 0, len(d.panels)),
+		Log:    append([]Entry(nil), d.log...),
+		Block:  d.block,
+		Health: d.health,
+	}
+	if d.baseFee != nil {
+		out.BaseFee = new(big.Int).Set(d.baseFee)
+	}
+	for _, p := range d.panels {
+		snap := Panel{Address: p.address, Pending: []Row{}, Done: []Row{}}
+		for _, r := range p.rows {
+			row := *r
+			row.Transitions = append([]Transition(nil), r.Transitions...)
+			if row.Done {
+				snap.Done = append(snap.Done, row)
+			} else {
+				snap.Pending = append(snap.Pending, row)
+			}
+		}
+		sort.Slice(snap.Pending, func(i, j int) bool {
+			a, b := snap.Pending[i], snap.Pending[j]
+			if a.From != b.From {
+				return a.From < b.From
+			}
+			if a.Nonce != b.Nonce {
+				return a.Nonce < b.Nonce
+			}
+			return a.FirstSeen.Before(b.FirstSeen)
+		})
+		sort.Slice(snap.Done, func(i, j int) bool { return snap.Done[i].UpdatedAt.After(snap.Done[j].UpdatedAt) })
+		out.Panels = append(out.Panels, snap)
+	}
+	return out
+}
+
+// feeCap returns the most a transaction pays per gas, nil if it is unknown
+func feeCap(tx client.EthTransaction) *big.Int {
+	for _, v := range []string{tx.MaxFeePerGas, tx.GasPrice} {
+		if fee, ok := new(big.Int).SetString(v, 10); ok {
+			return fee
+		}
+	}
+	return nil
+}
+
+// describe summarizes a transaction for the event log
+func describe(tx client.EthTransaction) string {
+	msg := fmt.Sprintf("%s -> %s nonce %d", tx.From, tx.To, tx.Nonce)
+	if tx.ReplaceHash != "" {
+		msg += " replacing " + strings.ToLower(tx.ReplaceHash)
+	}
+	return msg
+}
@@ -0,0 +1,178 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/client/clienttest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sender = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	router = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+)
+
+var start = time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDashboardReplay(t *testing.T) {
+	replay, err := client.OpenReplay("../client/testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer replay.Close()
+	d := New(Opts{Addresses: []string{strings.ToUpper(sender)}})
+	for {
+		var raw json.RawMessage
+		err := replay.ReadJSON(&raw)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ev, err := client.NewEvent(time.Now(), raw)
+		require.NoError(t, err)
+		d.Apply(ev)
+	}
+
+	snap := d.Snapshot()
+	require.Len(t, snap.Panels, 1)
+	panel := snap.Panels[0]
+	require.Equal(t, sender, panel.Address)
+	require.Len(t, panel.Pending, 1)
+	swap := panel.Pending[0]
+	require.Equal(t, 43, swap.Nonce)
+	require.Equal(t, router, swap.To)
+	require.Equal(t, big.NewInt(120000000000), swap.FeeCap)
+	require.NotNil(t, swap.Event)
+	require.Len(t, panel.Done, 1)
+	require.Equal(t, "txConfirmed", panel.Done[0].Status)
+	require.Equal(t, []string{"txPool", "txConfirmed"}, codes(panel.Done[0].Transitions))
+	require.NotNil(t, snap.BaseFee)
+	require.NotZero(t, snap.Block)
+	require.Equal(t, uint64(len(snap.Log)), snap.Health.Events)
+}
+
+func TestDashboardLifecycle(t *testing.T) {
+	d := New(Opts{MaxDone: 2, MaxLog: 4})
+	// tx is a transaction from sender to router
+	tx := func(in client.EthTransaction) client.EthTransaction {
+		in.From, in.To, in.WatchedAddress = sender, router, sender
+		return in
+	}
+	d.Apply(clienttest.Event(t, start, "txPool", tx(client.EthTransaction{Hash: "0x01", Nonce: 1, MaxFeePerGas: "10"})))
+	d.Apply(clienttest.Event(t, start.Add(time.Second), "txPool", tx(client.EthTransaction{Hash: "0x02", Nonce: 2, GasPrice: "10"})))
+	d.Apply(clienttest.Event(t, start.Add(2*time.Second), "txPool", tx(client.EthTransaction{Hash: "0x03", Nonce: 3, GasPrice: "10"})))
+
+	// a speedup finishes the transaction it replaces
+	d.Apply(clienttest.Event(t, start.Add(3*time.Second), "txSpeedUp", tx(client.EthTransaction{Hash: "0x04", Nonce: 1, MaxFeePerGas: "20", ReplaceHash: "0x01"})))
+	panel := d.Snapshot().Panels[0]
+	require.Equal(t, []string{"0x04", "0x02", "0x03"}, hashes(panel.Pending))
+	require.Equal(t, "0x01", panel.Pending[0].Replaces)
+	require.Len(t, panel.Done, 1)
+	old := panel.Done[0]
+	require.Equal(t, "txSpeedUp", old.Status)
+	require.Equal(t, "0x04", old.ReplacedBy)
+	require.Equal(t, []Transition{
+		{Time: start, EventCode: "txPool"},
+		{Time: start.Add(3 * time.Second), EventCode: "txSpeedUp", Hash: "0x04"},
+	}, old.Transitions)
+	require.Equal(t, 3*time.Second, old.Age(start.Add(time.Hour)))
+	// late pending events do not bring it back
+	d.Apply(clienttest.Event(t, start.Add(3*time.Second), "txPool", tx(client.EthTransaction{Hash: "0x01", Nonce: 1, MaxFeePerGas: "10"})))
+	panel = d.Snapshot().Panels[0]
+	require.Len(t, panel.Pending, 3)
+	require.Equal(t, "txSpeedUp", panel.Done[0].Status)
+
+	// confirming a nonce replaces every pending transaction at or below it
+	d.Apply(clienttest.Event(t, start.Add(4*time.Second), "txConfirmed", tx(client.EthTransaction{Hash: "0x05", Nonce: 2, GasPrice: "10", BlockNumber: 100, BaseFeePerGas: "15"})))
+	snap := d.Snapshot()
+	panel = snap.Panels[0]
+	require.Equal(t, []string{"0x03"}, hashes(panel.Pending))
+	require.Equal(t, 58*time.Second, panel.Pending[0].Age(start.Add(time.Minute)))
+	require.True(t, panel.Pending[0].Underpriced(snap.BaseFee))
+	require.Equal(t, big.NewInt(15), snap.BaseFee)
+	require.Equal(t, 100, snap.Block)
+	// only the two most recently finished are kept
+	require.Len(t, panel.Done, 2)
+	for _, row := range panel.Done {
+		switch row.Hash {
+		case "0x05":
+			require.Equal(t, "txConfirmed", row.Status)
+		case "0x02":
+			require.Equal(t, Replaced, row.Status)
+			require.Equal(t, "0x05", row.ReplacedBy)
+		default:
+			require.Equal(t, Replaced, row.Status)
+			require.Equal(t, "", row.ReplacedBy)
+		}
+	}
+
+	// events of unknown addresses get a panel of their own
+	d.Apply(clienttest.Event(t, start.Add(5*time.Second), "txPool", client.EthTransaction{Hash: "0x06", From: router, Nonce: 1}))
+	snap = d.Snapshot()
+	require.Len(t, snap.Panels, 2)
+	require.Equal(t, router, snap.Panels[1].Address)
+
+	require.Len(t, snap.Log, 4)
+	require.Equal(t, "0x06", snap.Log[3].Hash)
+	require.Equal(t, uint64(7), snap.Health.Events)
+}
+
+func TestDashboardLimits(t *testing.T) {
+	d := New(Opts{MaxPending: 2, MaxTransitions: 3})
+	for i := 1; i <= 3; i++ {
+		hash := "0x0" + strconv.Itoa(i)
+		d.Apply(clienttest.Event(t, start.Add(time.Duration(i)*time.Second), "txPool", client.EthTransaction{Hash: hash, From: sender, Nonce: i}))
+	}
+	// the pending transaction updated longest ago is dropped
+	panel := d.Snapshot().Panels[0]
+	require.Equal(t, []string{"0x02", "0x03"}, hashes(panel.Pending))
+
+	for i := 0; i < 4; i++ {
+		d.Apply(clienttest.Event(t, start.Add(time.Duration(10+i)*time.Second), "txPool", client.EthTransaction{Hash: "0x02", From: sender, Nonce: 2}))
+	}
+	panel = d.Snapshot().Panels[0]
+	require.Len(t, panel.Pending[0].Transitions, 3)
+	require.Equal(t, start.Add(13*time.Second), panel.Pending[0].Transitions[2].Time)
+	// dropped transactions are forgotten, so their late events start over
+	d.Apply(clienttest.Event(t, start.Add(20*time.Second), "txConfirmed", client.EthTransaction{Hash: "0x01", From: sender, Nonce: 1}))
+	panel = d.Snapshot().Panels[0]
+	require.Len(t, panel.Done, 1)
+	require.Equal(t, []string{"txConfirmed"}, codes(panel.Done[0].Transitions))
+}
+
+func TestDashboardHealth(t *testing.T) {
+	d := New(Opts{})
+	d.Connected()
+	require.Equal(t, Health{Connected: true}, d.Snapshot().Health)
+	d.Disconnected(errors.New("unexpected EOF"))
+	require.Equal(t, Health{Error: "unexpected EOF"}, d.Snapshot().Health)
+	d.Connected()
+	require.Equal(t, Health{Connected: true, Reconnects: 1}, d.Snapshot().Health)
+
+	d.Write([]byte("reconnected\n\nsecond line\n"))
+	log := d.Snapshot().Log
+	require.Len(t, log, 2)
+	require.Equal(t, "second line", log[1].Message)
+}
+
+func codes(transitions []Transition) []string {
+	var out []string
+	for _, tr := range transitions {
+		out = append(out, tr.EventCode)
+	}
+	return out
+}
+
+func hashes(rows []Row) []string {
+	var out []string
+	for _, r := range rows {
+		out = append(out, r.Hash)
+	}
+	return out
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,8 +10,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bonedaddy/go-blocknative/calldata"
 	"github.com/bonedaddy/go-blocknative/client"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/gorilla/websocket"
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
@@ -58,6 +57,9 @@ func main() {
 		var abi interface{}
 		ExitOnErr(json.Unmarshal([]byte(TellorABI), &abi), "marshal abi")
 
+		decoder := calldata.New()
+		ExitOnErr(decoder.AddABI(strings.NewReader(TellorABI)), "loading the abi")
+
 		cfgMsg := client.NewConfig(
 			contractAddr,
 			true,
@@ -91,7 +93,7 @@ func main() {
 					return err
 				}
 				log.Printf("msg: %+v \n", msg)
-				log.Printf("func args: %+v \n", parseInput(msg.Event.Transaction.Input))
+				log.Printf("func args: %+v \n", parseInput(decoder, msg.Event.Transaction.Input))
 
 			}
 		}, func(error) {
@@ -114,22 +116,11 @@ func ExitOnErr(err error, msg string) {
 	}
 }
 
-func parseInput(input string) interface{} {
-	abiT, err := abi.JSON(strings.NewReader(TellorABI))
-	ExitOnErr(err, "loading the abi")
-
-	inputData, err := hex.DecodeString(input[10:])
+func parseInput(decoder *calldata.Decoder, input string) interface{} {
+	call, err := decoder.DecodeHex(input)
 	ExitOnErr(err, "input decode")
 
-	method, exist := abiT.Methods[methodName]
-	if !exist {
-		ExitOnErr(errors.New("method doesn't exists in the abi"), "")
-	}
-
-	output, err := method.Inputs.Unpack(inputData)
-	ExitOnErr(err, "args unpack")
-
-	return output
+	return call.Args
 }
 
 const TellorABI = `[
@@ -0,0 +1,370 @@
+// Package filter evaluates jsql filters, the same structure used by
+// client.Config.Filters (https://github.com/deitch/searchjs), against events
+// locally.
+//
+// A filter is a list of terms which must all match. Each term is an object
+// keyed by field, where nested fields use dots such as contractCall.methodName.
+// Fields are looked up in the event's transaction, which also carries
+// contractCall, eventCode and categoryCode. A term matches when:
+//
+//   - a string, number or bool value equals the field, strings ignoring case
+//   - an array value contains a value equal to the field
+//   - an object value satisfies every operator of gt, gte, lt, lte, from and to
+//   - the field is an array and any element matches
+//
+// Terms also support the searchjs modifiers _not to negate the term, _join
+// set to OR to match when any field matches, terms holding nested terms
+// combined according to _join, _text, _start and _end for substring, prefix
+// and suffix matching, and _propertySearch to find the field at any depth.
+package filter
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+)
+
+// Filter is a parsed list of jsql terms
+type Filter struct {
+	terms []map[string]interface{}
+}
+
+// New returns a filter matching when every term matches
+func New(terms []map[string]interface{}) *Filter {
+	return &Filter{terms: terms}
+}
+
+// FromConfig returns a filter equivalent to the filters of a blocknative config
+func FromConfig(filters []map[string]string) *Filter {
+	terms := make([]map[string]interface{}, 0, len(filters))
+	for _, f := range filters {
+		term := make(map[string]interface{}, len(f))
+		for k, v := range f {
+			term[k] = v
+		}
+		terms = append(terms, term)
+	}
+	return New(terms)
+}
+
+// Parse parses a filter from JSON, either a single term or a list of terms
+func Parse(data []byte) (*Filter, error) {
+	var terms []map[string]interface{}
+	if err := json.Unmarshal(data, &terms); err == nil {
+		return New(terms), nil
+	}
+	var term map[string]interface{}
+	if err := json.Unmarshal(data, &term); err != nil {
+		return nil, errors.Wrap(err, "parsing filter")
+	}
+	return New([]map[string]interface{}{term}), nil
+}
+
+// Terms returns the terms of the filter
+func (f *Filter) Terms() []map[string]interface{} {
+	return f.terms
+}
+
+// Empty reports whether the filter has no terms and so matches everything
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.terms) == 0
+}
+
+// Match reports whether ev satisfies the filter, a nil or empty filter matches every event
+func (f *Filter) Match(ev *client.Event) bool {
+	if f.Empty() {
+		return true
+	}
+	doc, err := Document(ev)
+	if err != nil {
+		return false
+	}
+	return f.MatchDocument(doc)
+}
+
+// MatchDocument reports whether a document as returned by Document satisfies the filter
+func (f *Filter) MatchDocument(doc map[string]interface{}) bool {
+	if f.Empty() {
+		return true
+	}
+	for _, term := range f.terms {
+		if !matchTerm(doc, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Document returns the generic form of ev that filters are evaluated against:
+// the transaction along with the contract call and event codes
+func Document(ev *client.Event) (map[string]interface{}, error) {
+	var frame struct {
+		Event map[string]interface{} `json:"event"`
+	}
+	if err := json.Unmarshal(ev.Raw, &frame); err != nil {
+		return nil, errors.Wrap(err, "decoding event")
+	}
+	doc, _ := frame.Event["transaction"].(map[string]interface{})
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for _, key := range []string{"contractCall", "eventCode", "categoryCode"} {
+		if _, ok := doc[key]; ok {
+			continue
+		}
+		if v, ok := frame.Event[key]; ok {
+			doc[key] = v
+		}
+	}
+	return doc, nil
+}
+
+// Lookup returns the value found at the dotted path of a document
+func Lookup(doc map[string]interface{}, path string) (interface{}, bool) {
+	return resolve(doc, strings.Split(path, "."))
+}
+
+// Equal reports whether two document values are equal the way filters
+// compare them: numerically if both are numbers, else as case insensitive text
+func Equal(a, b interface{}) bool {
+	return equal(a, b, matchOpts{})
+}
+
+// Compare orders two numeric document values, ok is false unless both are numbers
+func Compare(a, b interface{}) (cmp int, ok bool) {
+	an, ok := toNumber(a)
+	if !ok {
+		return 0, false
+	}
+	bn, ok := toNumber(b)
+	if !ok {
+		return 0, false
+	}
+	return an.Cmp(bn), true
+}
+
+// modifiers are the keys of a term which are not field names
+var modifiers = map[string]bool{
+	"_not": true, "_join": true, "terms": true, "_text": true,
+	"_word": true, "_start": true, "_end": true, "_propertySearch": true,
+}
+
+func matchTerm(doc map[string]interface{}, term map[string]interface{}) bool {
+	or := strings.EqualFold(toString(term["_join"]), "OR")
+	opts := matchOpts{
+		text:     truthy(term["_text"]) || truthy(term["_word"]),
+		start:    truthy(term["_start"]),
+		end:      truthy(term["_end"]),
+		property: truthy(term["_propertySearch"]),
+	}
+	var results []bool
+	if nested, ok := term["terms"].([]interface{}); ok {
+		for _, n := range nested {
+			if sub, ok := n.(map[string]interface{}); ok {
+				results = append(results, matchTerm(doc, sub))
+			}
+		}
+	}
+	for key, want := range term {
+		if modifiers[key] {
+			continue
+		}
+		results = append(results, matchField(lookup(doc, key, opts.property), want, opts))
+	}
+	matched := !or
+	for _, r := range results {
+		if or && r {
+			matched = true
+			break
+		}
+		if !or && !r {
+			matched = false
+			break
+		}
+	}
+	if truthy(term["_not"]) {
+		return !matched
+	}
+	return matched
+}
+
+type matchOpts struct {
+	text, start, end, property bool
+}
+
+// lookup returns every value found at the dotted path, searching nested objects when deep is set
+func lookup(doc interface{}, path string, deep bool) []interface{} {
+	var found []interface{}
+	if v, ok := resolve(doc, strings.Split(path, ".")); ok {
+		found = append(found, v)
+	}
+	if !deep {
+		return found
+	}
+	switch t := doc.(type) {
+	case map[string]interface{}:
+		for _, v := range t {
+			found = append(found, lookup(v, path, true)...)
+		}
+	case []interface{}:
+		for _, v := range t {
+			found = append(found, lookup(v, path, true)...)
+		}
+	}
+	return found
+}
+
+func resolve(doc interface{}, parts []string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return doc, true
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := obj[parts[0]]
+	if !ok {
+		return nil, false
+	}
+	return resolve(v, parts[1:])
+}
+
+// matchField reports whether any of the found values satisfies want
+func matchField(found []interface{}, want interface{}, opts matchOpts) bool {
+	for _, have := range found {
+		if matchValue(have, want, opts) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(have, want interface{}, opts matchOpts) bool {
+	if arr, ok := have.([]interface{}); ok {
+		for _, h := range arr {
+			if matchValue(h, want, opts) {
+				return true
+			}
+		}
+		return false
+	}
+	switch w := want.(type) {
+	case []interface{}:
+		for _, v := range w {
+			if matchValue(have, v, opts) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		return matchRange(have, w)
+	default:
+		return equal(have, want, opts)
+	}
+}
+
+func equal(have, want interface{}, opts matchOpts) bool {
+	if have == nil || want == nil {
+		return have == want
+	}
+	partial := opts.text || opts.start || opts.end
+	if hn, ok := toNumber(have); ok && !partial {
+		if wn, ok := toNumber(want); ok {
+			return hn.Cmp(wn) == 0
+		}
+	}
+	h, w := strings.ToLower(toString(have)), strings.ToLower(toString(want))
+	switch {
+	case opts.text:
+		return strings.Contains(h, w)
+	case opts.start:
+		return strings.HasPrefix(h, w)
+	case opts.end:
+		return strings.HasSuffix(h, w)
+	default:
+		return h == w
+	}
+}
+
+func matchRange(have interface{}, ops map[string]interface{}) bool {
+	hn, ok := toNumber(have)
+	if !ok {
+		return false
+	}
+	for op, v := range ops {
+		wn, ok := toNumber(v)
+		if !ok {
+			return false
+		}
+		cmp := hn.Cmp(wn)
+		switch op {
+		case "gt":
+			ok = cmp > 0
+		case "gte", "from":
+			ok = cmp >= 0
+		case "lt":
+			ok = cmp < 0
+		case "lte", "to":
+			ok = cmp <= 0
+		default:
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// toNumber parses numbers and numeric strings, including hex, with arbitrary precision as wei values exceed float64
+func toNumber(v interface{}) (*big.Float, bool) {
+	switch t := v.(type) {
+	case float64:
+		return big.NewFloat(t), true
+	case json.Number:
+		f, ok := new(big.Float).SetPrec(256).SetString(t.String())
+		return f, ok
+	case string:
+		if strings.HasPrefix(t, "0x") || strings.HasPrefix(t, "0X") {
+			// only treat short hex strings as numbers, addresses and hashes are compared as text
+			if len(t) > 18 {
+				return nil, false
+			}
+			i, ok := new(big.Int).SetString(t[2:], 16)
+			if !ok {
+				return nil, false
+			}
+			return new(big.Float).SetInt(i), true
+		}
+		f, ok := new(big.Float).SetPrec(256).SetString(t)
+		return f, ok
+	default:
+		return nil, false
+	}
+}
+
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	default:
+		data, _ := json.Marshal(t)
+		return string(data)
+	}
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return strings.EqualFold(t, "true")
+	default:
+		return false
+	}
+}
@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+const swapEvent = `{"status":"ok","event":{"categoryCode":"activeAddress","eventCode":"txPool",
+	"contractCall":{"methodName":"swapExactETHForTokens","params":{"path":["0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2","0x6b175474e89094c44da98b954eedeac495271d0f"]}},
+	"transaction":{"status":"pending","hash":"0x01","from":"0xFA6DE2697D59E88ED7FC4DFE5A33DAC43565EA41","value":"1500000000000000000","gas":210000,"direction":"outgoing"}}}`
+
+func TestFilter(t *testing.T) {
+	ev, err := client.NewEvent(time.Now(), []byte(swapEvent))
+	require.NoError(t, err)
+	tests := []struct {
+		name   string
+		filter string
+		match  bool
+	}{
+		{"empty", `[]`, true},
+		{"equal", `[{"status":"pending"}]`, true},
+		{"not equal", `[{"status":"confirmed"}]`, false},
+		{"case insensitive", `{"from":"0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"}`, true},
+		{"nested", `[{"contractCall.methodName":"swapExactETHForTokens"}]`, true},
+		{"event code", `[{"eventCode":"txPool"}]`, true},
+		{"missing field", `[{"contractCall.contractName":"Uniswap"}]`, false},
+		{"any of", `[{"status":["confirmed","pending"]}]`, true},
+		{"array field", `[{"contractCall.params.path":"0x6b175474e89094c44da98b954eedeac495271d0f"}]`, true},
+		{"gt", `[{"value":{"gt":"1000000000000000000"}}]`, true},
+		{"range", `[{"gas":{"gte":21000,"lt":"210000"}}]`, false},
+		{"and", `[{"status":"pending"},{"direction":"incoming"}]`, false},
+		{"or", `[{"_join":"OR","status":"confirmed","direction":"outgoing"}]`, true},
+		{"terms", `[{"_join":"OR","terms":[{"status":"confirmed"},{"gas":{"lt":1}}]}]`, false},
+		{"not", `[{"_not":true,"status":"pending"}]`, false},
+		{"text", `[{"_text":true,"contractCall.methodName":"exacteth"}]`, true},
+		{"start", `[{"_start":true,"hash":"0x0"}]`, true},
+		{"property search", `[{"_propertySearch":"true","methodName":"swapExactETHForTokens"}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.filter))
+			require.NoError(t, err)
+			require.Equal(t, tt.match, f.Match(ev))
+		})
+	}
+	// config filters use string values
+	require.True(t, FromConfig([]map[string]string{
+		{"contractCall.methodName": "swapExactETHForTokens", "_propertySearch": "true"},
+	}).Match(ev))
+	var nilFilter *Filter
+	require.True(t, nilFilter.Match(ev))
+
+	// conditions of package rules compare values the same way
+	doc, err := Document(ev)
+	require.NoError(t, err)
+	value, ok := Lookup(doc, "contractCall.methodName")
+	require.True(t, ok)
+	require.True(t, Equal(value, "SWAPEXACTETHFORTOKENS"))
+	require.True(t, Equal(doc["gas"], "210000"))
+	cmp, ok := Compare(doc["value"], "2000000000000000000")
+	require.True(t, ok)
+	require.Equal(t, -1, cmp)
+	_, ok = Compare(doc["from"], 1)
+	require.False(t, ok)
+}
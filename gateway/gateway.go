@@ -0,0 +1,467 @@
+// Package gateway exposes blocknative subscriptions over plain HTTP for
+// consumers that cannot speak the websocket protocol, such as browser
+// dashboards and shell scripts. Subscriptions are created with
+// POST /subscriptions and their events are streamed with server-sent events
+// from GET /subscriptions/{id}/events, or fetched in batches by long-polling
+// GET /subscriptions/{id}/poll. All subscriptions share a single connection.
+// Each subscription is read by one stream or poll at a time, and subscriptions
+// nobody reads from anymore are removed by Prune.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/route"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPollTimeout is how long a long-poll waits for an event when no timeout is given
+	DefaultPollTimeout = time.Second * 30
+	// MaxPollTimeout caps the timeout a long-poll may ask for
+	MaxPollTimeout = time.Minute * 5
+	// heartbeatInterval is how often a comment is sent on idle event streams to keep proxies from closing them
+	heartbeatInterval = time.Second * 15
+	// maxPollBatch is the most events returned by a single long-poll
+	maxPollBatch = 100
+	// DefaultIdleTimeout is how long a subscription may go without a reader before it is pruned
+	DefaultIdleTimeout = time.Minute * 5
+)
+
+// ErrReading is returned when reading a subscription that is already being read,
+// as concurrent readers would each only see part of its events
+var ErrReading = errors.New("subscription already has a reader")
+
+// Conn is used to send subscription messages, typically a client.Client
+type Conn interface {
+	WriteJSON(out interface{}) error
+}
+
+// Request creates a subscription, exactly one of Address, Hash or Config must be set
+type Request struct {
+	Address string         `json:"address,omitempty"`
+	Hash    string         `json:"hash,omitempty"`
+	Config  *client.Config `json:"config,omitempty"`
+}
+
+// Subscription describes a subscription created through the gateway
+type Subscription struct {
+	ID        string             `json:"id"`
+	Kind      route.Kind         `json:"kind"`
+	Value     string             `json:"value"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Stats     client.BufferStats `json:"stats"`
+}
+
+// Gateway is an http.Handler serving subscriptions backed by a single connection
+type Gateway struct {
+	conn  Conn
+	base  func() client.BaseMessage
+	opts  client.BufferOpts
+	table *route.Table
+	seq   uint64
+
+	mx   sync.RWMutex
+	subs map[string]*subscription
+	// latest config put for each scope, keyed like route.ConfigKey
+	configs map[string]client.Config
+}
+
+type subscription struct {
+	Subscription
+	key route.Key
+	buf *client.EventBuffer
+	// whether a reader holds the subscription and when the last one finished, guarded by the gateway's mx
+	reading  bool
+	lastRead time.Time
+}
+
+// New returns a gateway sending subscriptions over conn. base returns the
+// base message used for every subscription and opts controls the buffering
+// of each subscription's events, defaulting to DropOldest.
+func New(conn Conn, base func() client.BaseMessage, opts client.BufferOpts) *Gateway {
+	if opts == (client.BufferOpts{}) {
+		opts.Policy = client.DropOldest
+	}
+	return &Gateway{
+		conn:    conn,
+		base:    base,
+		opts:    opts,
+		table:   route.NewTable(),
+		subs:    make(map[string]*subscription),
+		configs: make(map[string]client.Config),
+	}
+}
+
+// Run reads events from src and routes them to subscriptions until reading fails
+func (g *Gateway) Run(ctx context.Context, src client.Source) error {
+	for {
+		var raw json.RawMessage
+		if err := src.ReadJSON(&raw); err != nil {
+			return err
+		}
+		ev, err := client.NewEvent(time.Now(), raw)
+		if err != nil {
+			continue
+		}
+		for _, id := range g.table.Match(ev) {
+			g.mx.RLock()
+			sub, ok := g.subs[id]
+			g.mx.RUnlock()
+			if ok {
+				sub.buf.Push(ctx, ev)
+			}
+		}
+	}
+}
+
+// Subscribe creates a subscription, only sending it upstream if no other subscription already holds it
+func (g *Gateway) Subscribe(req Request) (*Subscription, error) {
+	var (
+		key route.Key
+		msg interface{}
+	)
+	switch {
+	case req.Address != "" && req.Hash == "" && req.Config == nil:
+		sub, err := client.NewAddressSubscribeChecked(g.base(), req.Address)
+		if err != nil {
+			return nil, err
+		}
+		key, msg = route.AddressKey(sub.Address), sub
+	case req.Hash != "" && req.Address == "" && req.Config == nil:
+		sub, err := client.NewTxSubscribeChecked(g.base(), req.Hash)
+		if err != nil {
+			return nil, err
+		}
+		key, msg = route.TxKey(sub.Hash), sub
+	case req.Config != nil && req.Address == "" && req.Hash == "":
+		if req.Config.Scope == "" {
+			return nil, errors.New("config scope must be set")
+		}
+		cfg, err := client.NewConfigChecked(req.Config.Scope, req.Config.WatchAddress, req.Config.ABI)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Filters = req.Config.Filters
+		req.Config = &cfg
+		key, msg = route.ConfigKey(cfg.Scope), client.NewConfiguration(g.base(), cfg)
+	default:
+		return nil, errors.New("exactly one of address, hash or config must be set")
+	}
+	buf, err := client.NewEventBuffer(g.opts)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscription{
+		Subscription: Subscription{
+			ID:        fmt.Sprintf("sub-%d", atomic.AddUint64(&g.seq, 1)),
+			Kind:      key.Kind,
+			Value:     key.Value,
+			CreatedAt: time.Now(),
+		},
+		key:      key,
+		buf:      buf,
+		lastRead: time.Now(),
+	}
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	// configs replace any previous config for the scope so they are always sent
+	if g.table.Add(sub.ID, key) || key.Kind == route.Config {
+		if err := g.conn.WriteJSON(msg); err != nil {
+			g.table.Remove(sub.ID, key)
+			buf.Close()
+			return nil, err
+		}
+	}
+	if req.Config != nil {
+		g.configs[key.Value] = *req.Config
+	}
+	g.subs[sub.ID] = sub
+	out := sub.Subscription
+	return &out, nil
+}
+
+// Unsubscribe removes a subscription, unsubscribing upstream if nothing else holds it
+func (g *Gateway) Unsubscribe(id string) error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return g.unsubscribe(id)
+}
+
+// unsubscribe removes a subscription, mx must be held
+func (g *Gateway) unsubscribe(id string) error {
+	sub, ok := g.subs[id]
+	if !ok {
+		return errors.Errorf("unknown subscription:%v", id)
+	}
+	delete(g.subs, id)
+	sub.buf.Close()
+	if !g.table.Remove(id, sub.key) {
+		return nil
+	}
+	switch sub.key.Kind {
+	case route.Address:
+		return g.conn.WriteJSON(client.NewAddressUnsubscribe(g.base(), sub.key.Value))
+	case route.Transaction:
+		return g.conn.WriteJSON(client.NewTxUnsubscribe(g.base(), sub.key.Value))
+	case route.Config:
+		delete(g.configs, sub.key.Value)
+	}
+	return nil
+}
+
+// Resubscribe sends every subscription held through the gateway again, such
+// as after the connection was re-established
+func (g *Gateway) Resubscribe() error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	for _, key := range g.table.Keys() {
+		var msg interface{}
+		switch key.Kind {
+		case route.Address:
+			msg = client.NewAddressSubscribe(g.base(), key.Value)
+		case route.Transaction:
+			msg = client.NewTxSubscribe(g.base(), key.Value)
+		case route.Config:
+			cfg, ok := g.configs[key.Value]
+			if !ok {
+				continue
+			}
+			msg = client.NewConfiguration(g.base(), cfg)
+		}
+		if err := g.conn.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes subscriptions that have had no reader since before, for
+// clients that went away without unsubscribing, returning how many were removed
+func (g *Gateway) Prune(before time.Time) int {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	var n int
+	for id, sub := range g.subs {
+		if sub.reading || !sub.lastRead.Before(before) {
+			continue
+		}
+		g.unsubscribe(id)
+		n++
+	}
+	return n
+}
+
+// Subscriptions returns every current subscription
+func (g *Gateway) Subscriptions() []Subscription {
+	g.mx.RLock()
+	defer g.mx.RUnlock()
+	subs := make([]Subscription, 0, len(g.subs))
+	for _, sub := range g.subs {
+		s := sub.Subscription
+		s.Stats = sub.buf.Stats()
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// Next blocks until the subscription has an event or ctx is done, failing
+// with client.ErrClosed once the subscription is removed and with ErrReading
+// while another reader waits on it
+func (g *Gateway) Next(ctx context.Context, id string) (*client.Event, error) {
+	sub, err := g.acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	defer g.release(sub)
+	return sub.buf.Next(ctx)
+}
+
+// Close removes every subscription
+func (g *Gateway) Close() error {
+	g.mx.RLock()
+	ids := make([]string, 0, len(g.subs))
+	for id := range g.subs {
+		ids = append(ids, id)
+	}
+	g.mx.RUnlock()
+	for _, id := range ids {
+		g.Unsubscribe(id)
+	}
+	return nil
+}
+
+// ServeHTTP routes gateway requests
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "subscriptions" {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		g.handleCreate(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, g.Subscriptions())
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := g.Unsubscribe(parts[1]); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && parts[2] == "events" && r.Method == http.MethodGet:
+		g.handleEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "poll" && r.Method == http.MethodGet:
+		g.handlePoll(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (g *Gateway) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "decoding request"))
+		return
+	}
+	sub, err := g.Subscribe(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+// handleEvents streams a subscription's events as server-sent events until the client disconnects
+func (g *Gateway) handleEvents(w http.ResponseWriter, r *http.Request, id string) {
+	sub, ok := g.read(w, id)
+	if !ok {
+		return
+	}
+	defer g.release(sub)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ctx := r.Context()
+	for seq := 1; ; {
+		next, cancel := context.WithTimeout(ctx, heartbeatInterval)
+		ev, err := sub.buf.Next(next)
+		cancel()
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", seq, eventName(ev), ev.Raw)
+			seq++
+		case err == context.DeadlineExceeded && ctx.Err() == nil:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		default:
+			// client went away or subscription was removed
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handlePoll waits up to the timeout query parameter for events, returning every buffered event as a JSON array
+func (g *Gateway) handlePoll(w http.ResponseWriter, r *http.Request, id string) {
+	sub, ok := g.read(w, id)
+	if !ok {
+		return
+	}
+	defer g.release(sub)
+	timeout := DefaultPollTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "parsing timeout"))
+			return
+		}
+		timeout = d
+	}
+	if timeout > MaxPollTimeout {
+		timeout = MaxPollTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	events := []json.RawMessage{}
+	if ev, err := sub.buf.Next(ctx); err == nil {
+		events = append(events, ev.Raw)
+		// return whatever else is already buffered without waiting
+		for len(events) < maxPollBatch && sub.buf.Stats().Pending > 0 {
+			ev, err := sub.buf.Next(r.Context())
+			if err != nil {
+				break
+			}
+			events = append(events, ev.Raw)
+		}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// acquire marks a subscription as read, failing if it is unknown or already being read
+func (g *Gateway) acquire(id string) (*subscription, error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	sub, ok := g.subs[id]
+	if !ok {
+		return nil, errors.Errorf("unknown subscription:%v", id)
+	}
+	if sub.reading {
+		return nil, ErrReading
+	}
+	sub.reading = true
+	return sub, nil
+}
+
+// release ends a read started by acquire
+func (g *Gateway) release(sub *subscription) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	sub.reading, sub.lastRead = false, time.Now()
+}
+
+// read acquires a subscription for a request, writing the error if it can not be read
+func (g *Gateway) read(w http.ResponseWriter, id string) (*subscription, bool) {
+	sub, err := g.acquire(id)
+	switch {
+	case err == ErrReading:
+		writeError(w, http.StatusConflict, err)
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	return sub, true
+}
+
+// eventName returns the event code used as the server-sent event name
+func eventName(ev *client.Event) string {
+	if code := ev.Payload.Event.EventCode; code != "" {
+		return code
+	}
+	return "message"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
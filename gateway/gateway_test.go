@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	hash1 = "0x0000000000000000000000000000000000000000000000000000000000000001"
+	hash2 = "0x0000000000000000000000000000000000000000000000000000000000000002"
+)
+
+// fakeConn records sent messages and serves frames pushed by the test
+type fakeConn struct {
+	mx     sync.Mutex
+	sent   []string
+	frames chan string
+}
+
+func (f *fakeConn) WriteJSON(out interface{}) error {
+	data, _ := json.Marshal(out)
+	var msg client.BaseMessage
+	json.Unmarshal(data, &msg)
+	f.mx.Lock()
+	f.sent = append(f.sent, msg.EventCode)
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *fakeConn) ReadJSON(out interface{}) error {
+	frame, ok := <-f.frames
+	if !ok {
+		return io.EOF
+	}
+	return json.Unmarshal([]byte(frame), out)
+}
+
+func (f *fakeConn) codes() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+func TestGateway(t *testing.T) {
+	conn := &fakeConn{frames: make(chan string)}
+	gw := New(conn, func() client.BaseMessage { return client.NewBaseMessageMainnet("key") }, client.BufferOpts{})
+	go gw.Run(context.Background(), conn)
+	defer close(conn.frames)
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	create := func(body string) Subscription {
+		resp, err := http.Post(srv.URL+"/subscriptions", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var sub Subscription
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&sub))
+		return sub
+	}
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	stream := create(`{"address":"0x` + strings.ToUpper(addr[2:]) + `"}`)
+	poll := create(`{"address":"` + addr + `"}`)
+	create(`{"hash":"` + hash1 + `"}`)
+	// both address subscriptions share one upstream subscription
+	require.Equal(t, []string{"watch", "txSent"}, conn.codes())
+
+	resp, err := http.Post(srv.URL+"/subscriptions", "application/json", strings.NewReader(`{"address":"0x1","hash":"0x2"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Post(srv.URL+"/subscriptions", "application/json", strings.NewReader(`{"address":"0x1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// start streaming before any events arrive
+	resp, err = http.Get(srv.URL + "/subscriptions/" + stream.ID + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	// a second reader would only see part of the events, so it is refused
+	second, err := http.Get(srv.URL + "/subscriptions/" + stream.ID + "/events")
+	require.NoError(t, err)
+	second.Body.Close()
+	require.Equal(t, http.StatusConflict, second.StatusCode)
+
+	conn.frames <- `{"event":{"eventCode":"txPool","transaction":{"hash":"` + hash2 + `","watchedAddress":"` + addr + `"}}}`
+	conn.frames <- `{"event":{"eventCode":"txPool","transaction":{"hash":"` + hash1 + `"}}}`
+	conn.frames <- `{"event":{"eventCode":"txConfirmed","transaction":{"hash":"` + hash2 + `","watchedAddress":"` + addr + `"}}}`
+
+	rd := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 6 {
+		line, err := rd.ReadString('\n')
+		require.NoError(t, err)
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	require.Equal(t, "id: 1", lines[0])
+	require.Equal(t, "event: txPool", lines[1])
+	require.True(t, strings.HasPrefix(lines[2], "data: {"))
+	require.Equal(t, "event: txConfirmed", lines[4])
+
+	// long-polling returns everything buffered so far
+	require.Eventually(t, func() bool {
+		for _, sub := range gw.Subscriptions() {
+			if sub.ID == poll.ID {
+				return sub.Stats.Pending == 2
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond*10)
+	get := func(path string) []json.RawMessage {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var events []json.RawMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		return events
+	}
+	require.Len(t, get("/subscriptions/"+poll.ID+"/poll?timeout=1s"), 2)
+	require.Len(t, get("/subscriptions/"+poll.ID+"/poll?timeout=10ms"), 0)
+
+	// unsubscribing upstream only happens once the last subscription is removed
+	del := func(id string) int {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/subscriptions/"+id, nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusNoContent, del(poll.ID))
+	require.Equal(t, []string{"watch", "txSent"}, conn.codes())
+	require.Equal(t, http.StatusNoContent, del(stream.ID))
+	require.Equal(t, []string{"watch", "txSent", "unwatch"}, conn.codes())
+	require.Equal(t, http.StatusNotFound, del(stream.ID))
+	require.Len(t, gw.Subscriptions(), 1)
+
+	var list bytes.Buffer
+	resp, err = http.Get(srv.URL + "/subscriptions")
+	require.NoError(t, err)
+	io.Copy(&list, resp.Body)
+	resp.Body.Close()
+	require.Contains(t, list.String(), `"kind":"tx"`)
+}
+
+func TestResubscribe(t *testing.T) {
+	conn := &fakeConn{}
+	gw := New(conn, func() client.BaseMessage { return client.NewBaseMessageMainnet("key") }, client.BufferOpts{})
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	_, err := gw.Subscribe(Request{Address: addr})
+	require.NoError(t, err)
+	_, err = gw.Subscribe(Request{Address: addr})
+	require.NoError(t, err)
+	tx, err := gw.Subscribe(Request{Hash: hash1})
+	require.NoError(t, err)
+	_, err = gw.Subscribe(Request{Config: &client.Config{Scope: addr}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"watch", "txSent", "put"}, conn.codes())
+
+	// every subscription still held is sent once more
+	require.NoError(t, gw.Unsubscribe(tx.ID))
+	conn.sent = nil
+	require.NoError(t, gw.Resubscribe())
+	require.ElementsMatch(t, []string{"watch", "put"}, conn.codes())
+}
+
+func TestPrune(t *testing.T) {
+	conn := &fakeConn{}
+	gw := New(conn, func() client.BaseMessage { return client.NewBaseMessageMainnet("key") }, client.BufferOpts{})
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	idle, err := gw.Subscribe(Request{Address: addr})
+	require.NoError(t, err)
+	read, err := gw.Subscribe(Request{Hash: hash1})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	reading := make(chan error)
+	go func() {
+		for {
+			if _, err := gw.Next(ctx, read.ID); err != ErrReading {
+				reading <- err
+				return
+			}
+		}
+	}()
+	require.Eventually(t, func() bool {
+		probe, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		_, err := gw.Next(probe, read.ID)
+		return err == ErrReading
+	}, time.Second, time.Millisecond*5)
+
+	// subscriptions being read are kept, idle ones are removed along with their upstream subscription
+	require.Equal(t, 1, gw.Prune(time.Now().Add(time.Second)))
+	require.Equal(t, []string{"watch", "txSent", "unwatch"}, conn.codes())
+	require.Error(t, gw.Unsubscribe(idle.ID))
+	cancel()
+	require.Equal(t, context.Canceled, <-reading)
+	require.Equal(t, 0, gw.Prune(time.Now().Add(-time.Minute)))
+	require.Equal(t, 1, gw.Prune(time.Now().Add(time.Second)))
+	require.Empty(t, gw.Subscriptions())
+}
@@ -7,8 +7,14 @@ require (
 	github.com/ethereum/go-ethereum v1.10.8
 	github.com/gorilla/websocket v1.4.3-0.20200912193213-c3dd95aea977
 	github.com/joho/godotenv v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/nsf/termbox-go v1.1.1
 	github.com/oklog/run v1.1.0
+	github.com/peterh/liner v1.1.1-0.20190123174540-a2c9a5303de7
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v3 v3.0.1
 )
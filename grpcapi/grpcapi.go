@@ -0,0 +1,198 @@
+// Package grpcapi serves blocknative subscriptions over gRPC so that services
+// written in other languages can consume events through the typed schema in
+// grpcapi/pb. Every call creates a subscription on a shared gateway.Gateway
+// and streams its events until the call is cancelled.
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	"github.com/bonedaddy/go-blocknative/grpcapi/pb"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements pb.BlocknativeServer on top of a gateway
+type Server struct {
+	pb.UnimplementedBlocknativeServer
+	gw *gateway.Gateway
+}
+
+// New returns a server creating subscriptions on gw, which must be running
+func New(gw *gateway.Gateway) *Server {
+	return &Server{gw: gw}
+}
+
+// WatchAddress streams events for transactions to or from an address
+func (s *Server) WatchAddress(req *pb.WatchAddressRequest, stream pb.Blocknative_WatchAddressServer) error {
+	if req.GetAddress() == "" {
+		return status.Error(codes.InvalidArgument, "address must be set")
+	}
+	return s.stream(stream.Context(), gateway.Request{Address: req.GetAddress()}, stream.Send)
+}
+
+// WatchTransaction streams lifecycle events for a single transaction
+func (s *Server) WatchTransaction(req *pb.WatchTransactionRequest, stream pb.Blocknative_WatchTransactionServer) error {
+	if req.GetHash() == "" {
+		return status.Error(codes.InvalidArgument, "hash must be set")
+	}
+	return s.stream(stream.Context(), gateway.Request{Hash: req.GetHash()}, stream.Send)
+}
+
+// PutConfig applies a config for a scope and streams the events it produces
+func (s *Server) PutConfig(req *pb.PutConfigRequest, stream pb.Blocknative_PutConfigServer) error {
+	cfg, err := ToConfig(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.stream(stream.Context(), gateway.Request{Config: &cfg}, stream.Send)
+}
+
+// stream subscribes and sends events until ctx is done or the subscription is removed
+func (s *Server) stream(ctx context.Context, req gateway.Request, send func(*pb.Event) error) error {
+	sub, err := s.gw.Subscribe(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer s.gw.Unsubscribe(sub.ID)
+	for {
+		ev, err := s.gw.Next(ctx, sub.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return status.Error(codes.Canceled, ctx.Err().Error())
+			}
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		out, err := FromEvent(ev)
+		if err != nil {
+			// skip events that cannot be represented rather than ending the stream
+			continue
+		}
+		if err := send(out); err != nil {
+			return err
+		}
+	}
+}
+
+// ToConfig converts a config request into a config, decoding its abi
+func ToConfig(req *pb.PutConfigRequest) (client.Config, error) {
+	var abi interface{}
+	if req.GetAbi() != "" {
+		if err := json.Unmarshal([]byte(req.GetAbi()), &abi); err != nil {
+			return client.Config{}, errors.Wrap(err, "decoding abi")
+		}
+	}
+	cfg, err := client.NewConfigChecked(req.GetScope(), req.GetWatchAddress(), abi)
+	if err != nil {
+		return client.Config{}, err
+	}
+	for _, filter := range req.GetFilters() {
+		cfg.Filters = append(cfg.Filters, filter.GetTerms())
+	}
+	return cfg, nil
+}
+
+// FromEvent converts an event into its protobuf representation
+func FromEvent(ev *client.Event) (*pb.Event, error) {
+	payload := ev.Payload
+	out := &pb.Event{
+		Version:        int32(payload.Version),
+		ServerVersion:  payload.ServerVersion,
+		TimeStamp:      timestamp(payload.TimeStamp),
+		ConnectionId:   payload.ConnectionID,
+		Status:         payload.Status,
+		CategoryCode:   payload.Event.CategoryCode,
+		EventCode:      payload.Event.EventCode,
+		EventTimeStamp: timestamp(payload.Event.Timestamp),
+		Blockchain: &pb.Blockchain{
+			System:  payload.Event.System,
+			Network: payload.Event.Network,
+		},
+		Transaction: fromTransaction(payload.Event.Transaction),
+		ReceivedAt:  timestamp(ev.ReceivedAt),
+		Raw:         ev.Raw,
+	}
+	if call := payload.Event.ContractCall; call != nil {
+		params, err := structpb.NewStruct(call.Params)
+		if err != nil {
+			return nil, errors.Wrap(err, "converting contract call params")
+		}
+		out.ContractCall = &pb.ContractCall{
+			ContractType:    call.ContractType,
+			ContractAddress: call.ContractAddress,
+			ContractName:    call.ContractName,
+			MethodName:      call.MethodName,
+			Params:          params,
+		}
+	}
+	return out, nil
+}
+
+func fromTransaction(tx client.EthTransaction) *pb.Transaction {
+	out := &pb.Transaction{
+		Type:                 int32(tx.Type),
+		MaxFeePerGas:         tx.MaxFeePerGas,
+		MaxPriorityFeePerGas: tx.MaxPriorityFeePerGas,
+		BaseFeePerGas:        tx.BaseFeePerGas,
+		TimeStamp:            timestamp(tx.TimeStamp),
+		Status:               tx.Status,
+		MonitorId:            tx.MonitorID,
+		MonitorVersion:       tx.MonitorVersion,
+		TimePending:          tx.TimePending,
+		PendingTimeStamp:     timestamp(tx.PendingTimeStamp),
+		PendingBlockNumber:   int64(tx.PendingBlockNumber),
+		BlocksPending:        int64(tx.BlocksPending),
+		Hash:                 tx.Hash,
+		ReplaceHash:          tx.ReplaceHash,
+		From:                 tx.From,
+		To:                   tx.To,
+		Value:                tx.Value,
+		Gas:                  int64(tx.Gas),
+		GasPrice:             tx.GasPrice,
+		GasPriceGwei:         int64(tx.GasPriceGwei),
+		Nonce:                int64(tx.Nonce),
+		BlockHash:            tx.BlockHash,
+		BlockNumber:          int64(tx.BlockNumber),
+		TransactionIndex:     int64(tx.TransactionIndex),
+		Input:                tx.Input,
+		GasUsed:              tx.GasUsed,
+		Asset:                tx.Asset,
+		WatchedAddress:       tx.WatchedAddress,
+		Direction:            tx.Direction,
+		Counterparty:         tx.Counterparty,
+	}
+	for _, bc := range tx.NetBalanceChanges {
+		change := &pb.BalanceChange{Address: bc.Address}
+		for _, ac := range bc.BalanceChanges {
+			asset := &pb.AssetChange{
+				Delta: ac.Delta,
+				Asset: &pb.Asset{
+					Type:            ac.Asset.Type,
+					Symbol:          ac.Asset.Symbol,
+					ContractAddress: ac.Asset.ContractAddress,
+				},
+			}
+			for _, b := range ac.Breakdown {
+				asset.Breakdown = append(asset.Breakdown, &pb.AssetBreakdown{Counterparty: b.Counterparty, Amount: b.Amount})
+			}
+			change.BalanceChanges = append(change.BalanceChanges, asset)
+		}
+		out.NetBalanceChanges = append(out.NetBalanceChanges, change)
+	}
+	return out
+}
+
+// timestamp converts t, leaving unset times nil
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
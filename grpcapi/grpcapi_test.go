@@ -0,0 +1,154 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	"github.com/bonedaddy/go-blocknative/grpcapi/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeConn records sent messages and serves frames pushed by the test
+type fakeConn struct {
+	mx     sync.Mutex
+	sent   []string
+	frames chan json.RawMessage
+}
+
+func (f *fakeConn) WriteJSON(out interface{}) error {
+	data, _ := json.Marshal(out)
+	var msg client.BaseMessage
+	json.Unmarshal(data, &msg)
+	f.mx.Lock()
+	f.sent = append(f.sent, msg.EventCode)
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *fakeConn) ReadJSON(out interface{}) error {
+	frame, ok := <-f.frames
+	if !ok {
+		return io.EOF
+	}
+	return json.Unmarshal(frame, out)
+}
+
+func (f *fakeConn) codes() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := &fakeConn{frames: make(chan json.RawMessage)}
+	gw := gateway.New(conn, func() client.BaseMessage { return client.NewBaseMessageMainnet("key") }, client.BufferOpts{})
+	defer gw.Close()
+	go gw.Run(ctx, conn)
+	defer close(conn.frames)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterBlocknativeServer(srv, New(gw))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	cc, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	require.NoError(t, err)
+	defer cc.Close()
+	cl := pb.NewBlocknativeClient(cc)
+
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	watchCtx, stop := context.WithCancel(ctx)
+	stream, err := cl.WatchAddress(watchCtx, &pb.WatchAddressRequest{Address: addr})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(gw.Subscriptions()) == 1 }, time.Second, time.Millisecond*10)
+
+	replay, err := client.OpenReplay("../client/testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer replay.Close()
+	for {
+		var frame json.RawMessage
+		if err := replay.ReadJSON(&frame); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+		conn.frames <- frame
+	}
+
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "txPool", first.EventCode)
+	require.Equal(t, "main", first.Blockchain.Network)
+	require.Equal(t, int64(42), first.Transaction.Nonce)
+	require.Nil(t, first.ContractCall)
+
+	swap, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int32(2), swap.Transaction.Type)
+	require.Equal(t, "120000000000", swap.Transaction.MaxFeePerGas)
+	require.Equal(t, "swapExactETHForTokens", swap.ContractCall.MethodName)
+	require.Len(t, swap.ContractCall.Params.Fields["path"].GetListValue().GetValues(), 2)
+	require.Len(t, swap.Transaction.NetBalanceChanges, 1)
+	changes := swap.Transaction.NetBalanceChanges[0].BalanceChanges
+	require.Equal(t, "DAI", changes[1].Asset.Symbol)
+	require.Equal(t, "5000000000000000000000", changes[1].Breakdown[0].Amount)
+	require.True(t, swap.ReceivedAt.IsValid())
+	require.NotEmpty(t, swap.Raw)
+
+	confirmed, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "txConfirmed", confirmed.EventCode)
+	require.Equal(t, int64(13218001), confirmed.Transaction.BlockNumber)
+	require.Equal(t, first.Transaction.Hash, confirmed.Transaction.Hash)
+
+	// cancelling the call removes the subscription
+	stop()
+	require.Eventually(t, func() bool { return len(gw.Subscriptions()) == 0 }, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"watch", "unwatch"}, conn.codes())
+
+	invalid, err := cl.PutConfig(ctx, &pb.PutConfigRequest{Scope: addr, Abi: "{"})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	missing, err := cl.WatchTransaction(ctx, &pb.WatchTransactionRequest{})
+	require.NoError(t, err)
+	_, err = missing.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	malformed, err := cl.WatchTransaction(ctx, &pb.WatchTransactionRequest{Hash: "0x01"})
+	require.NoError(t, err)
+	_, err = malformed.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	scope, err := cl.PutConfig(ctx, &pb.PutConfigRequest{Scope: "0x1"})
+	require.NoError(t, err)
+	_, err = scope.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestToConfig(t *testing.T) {
+	cfg, err := ToConfig(&pb.PutConfigRequest{
+		Scope:        "global",
+		Filters:      []*pb.Filter{{Terms: map[string]string{"status": "pending"}}},
+		Abi:          `[{"type":"function","name":"transfer"}]`,
+		WatchAddress: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "global", cfg.Scope)
+	require.True(t, cfg.WatchAddress)
+	require.Equal(t, []map[string]string{{"status": "pending"}}, cfg.Filters)
+	require.Len(t, cfg.ABI, 1)
+}
@@ -0,0 +1,260 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BlocknativeClient is the client API for Blocknative service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BlocknativeClient interface {
+	// WatchAddress streams events for transactions to or from an address
+	WatchAddress(ctx context.Context, in *WatchAddressRequest, opts ...grpc.CallOption) (Blocknative_WatchAddressClient, error)
+	// WatchTransaction streams lifecycle events for a single transaction
+	WatchTransaction(ctx context.Context, in *WatchTransactionRequest, opts ...grpc.CallOption) (Blocknative_WatchTransactionClient, error)
+	// PutConfig applies a config for a scope and streams the events it produces
+	PutConfig(ctx context.Context, in *PutConfigRequest, opts ...grpc.CallOption) (Blocknative_PutConfigClient, error)
+}
+
+type blocknativeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBlocknativeClient(cc grpc.ClientConnInterface) BlocknativeClient {
+	return &blocknativeClient{cc}
+}
+
+func (c *blocknativeClient) WatchAddress(ctx context.Context, in *WatchAddressRequest, opts ...grpc.CallOption) (Blocknative_WatchAddressClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocknative_ServiceDesc.Streams[0], "/blocknative.v1.Blocknative/WatchAddress", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocknativeWatchAddressClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Blocknative_WatchAddressClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type blocknativeWatchAddressClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocknativeWatchAddressClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blocknativeClient) WatchTransaction(ctx context.Context, in *WatchTransactionRequest, opts ...grpc.CallOption) (Blocknative_WatchTransactionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocknative_ServiceDesc.Streams[1], "/blocknative.v1.Blocknative/WatchTransaction", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocknativeWatchTransactionClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Blocknative_WatchTransactionClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type blocknativeWatchTransactionClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocknativeWatchTransactionClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blocknativeClient) PutConfig(ctx context.Context, in *PutConfigRequest, opts ...grpc.CallOption) (Blocknative_PutConfigClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Blocknative_ServiceDesc.Streams[2], "/blocknative.v1.Blocknative/PutConfig", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blocknativePutConfigClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Blocknative_PutConfigClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type blocknativePutConfigClient struct {
+	grpc.ClientStream
+}
+
+func (x *blocknativePutConfigClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BlocknativeServer is the server API for Blocknative service.
+// All implementations must embed UnimplementedBlocknativeServer
+// for forward compatibility
+type BlocknativeServer interface {
+	// WatchAddress streams events for transactions to or from an address
+	WatchAddress(*WatchAddressRequest, Blocknative_WatchAddressServer) error
+	// WatchTransaction streams lifecycle events for a single transaction
+	WatchTransaction(*WatchTransactionRequest, Blocknative_WatchTransactionServer) error
+	// PutConfig applies a config for a scope and streams the events it produces
+	PutConfig(*PutConfigRequest, Blocknative_PutConfigServer) error
+	mustEmbedUnimplementedBlocknativeServer()
+}
+
+// UnimplementedBlocknativeServer must be embedded to have forward compatible implementations.
+type UnimplementedBlocknativeServer struct {
+}
+
+func (UnimplementedBlocknativeServer) WatchAddress(*WatchAddressRequest, Blocknative_WatchAddressServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAddress not implemented")
+}
+func (UnimplementedBlocknativeServer) WatchTransaction(*WatchTransactionRequest, Blocknative_WatchTransactionServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTransaction not implemented")
+}
+func (UnimplementedBlocknativeServer) PutConfig(*PutConfigRequest, Blocknative_PutConfigServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutConfig not implemented")
+}
+func (UnimplementedBlocknativeServer) mustEmbedUnimplementedBlocknativeServer() {}
+
+// UnsafeBlocknativeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BlocknativeServer will
+// result in compilation errors.
+type UnsafeBlocknativeServer interface {
+	mustEmbedUnimplementedBlocknativeServer()
+}
+
+func RegisterBlocknativeServer(s grpc.ServiceRegistrar, srv BlocknativeServer) {
+	s.RegisterService(&Blocknative_ServiceDesc, srv)
+}
+
+func _Blocknative_WatchAddress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchAddressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlocknativeServer).WatchAddress(m, &blocknativeWatchAddressServer{stream})
+}
+
+type Blocknative_WatchAddressServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type blocknativeWatchAddressServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocknativeWatchAddressServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Blocknative_WatchTransaction_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTransactionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlocknativeServer).WatchTransaction(m, &blocknativeWatchTransactionServer{stream})
+}
+
+type Blocknative_WatchTransactionServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type blocknativeWatchTransactionServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocknativeWatchTransactionServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Blocknative_PutConfig_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PutConfigRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlocknativeServer).PutConfig(m, &blocknativePutConfigServer{stream})
+}
+
+type Blocknative_PutConfigServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type blocknativePutConfigServer struct {
+	grpc.ServerStream
+}
+
+func (x *blocknativePutConfigServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Blocknative_ServiceDesc is the grpc.ServiceDesc for Blocknative service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Blocknative_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "blocknative.v1.Blocknative",
+	HandlerType: (*BlocknativeServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchAddress",
+			Handler:       _Blocknative_WatchAddress_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchTransaction",
+			Handler:       _Blocknative_WatchTransaction_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PutConfig",
+			Handler:       _Blocknative_PutConfig_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "blocknative.proto",
+}
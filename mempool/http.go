@@ -0,0 +1,106 @@
+package mempool
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Nonces describes the nonces an address has pending
+type Nonces struct {
+	Address string `json:"address"`
+	Nonces  []int  `json:"nonces"`
+	// Highest is unset when nothing is pending
+	Highest *int `json:"highest"`
+	// Gaps are nonces missing between the lowest and highest pending nonce
+	Gaps []int `json:"gaps"`
+}
+
+// SenderStats summarizes the pending transactions of a single sender
+type SenderStats struct {
+	Pending int    `json:"pending"`
+	Highest int    `json:"highestNonce"`
+	Outflow string `json:"outflow"`
+}
+
+// ServeHTTP serves a read-only JSON view of the mempool:
+//
+//	GET /pending?from=      pending transactions, optionally only those sent by from
+//	GET /tx/{hash}          a single pending transaction
+//	GET /address/{addr}/nonces  the nonces an address has pending
+//	GET /stats              counters and a summary of every sender
+func (m *Mempool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "pending":
+		var txs []Tx
+		if from := r.URL.Query().Get("from"); from != "" {
+			txs = m.Pending(from)
+		} else {
+			txs = []Tx{}
+			for _, from := range m.Senders() {
+				txs = append(txs, m.Pending(from)...)
+			}
+		}
+		writeJSON(w, http.StatusOK, txs)
+	case len(parts) == 2 && parts[0] == "tx":
+		tx, ok := m.Get(parts[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, errors.Errorf("transaction not pending:%v", parts[1]))
+			return
+		}
+		writeJSON(w, http.StatusOK, tx)
+	case len(parts) == 3 && parts[0] == "address" && parts[2] == "nonces":
+		writeJSON(w, http.StatusOK, m.nonces(parts[1]))
+	case len(parts) == 1 && parts[0] == "stats":
+		senders := make(map[string]SenderStats)
+		for _, from := range m.Senders() {
+			highest, _ := m.HighestNonce(from)
+			senders[from] = SenderStats{
+				Pending: len(m.Pending(from)),
+				Highest: highest,
+				Outflow: m.Outflow(from).String(),
+			}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Stats
+			Senders map[string]SenderStats `json:"bySender"`
+		}{m.Stats(), senders})
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (m *Mempool) nonces(addr string) Nonces {
+	out := Nonces{Address: strings.ToLower(addr), Nonces: []int{}, Gaps: []int{}}
+	for _, tx := range m.Pending(addr) {
+		out.Nonces = append(out.Nonces, tx.Nonce)
+	}
+	if len(out.Nonces) == 0 {
+		return out
+	}
+	highest := out.Nonces[len(out.Nonces)-1]
+	out.Highest = &highest
+	for i := 1; i < len(out.Nonces); i++ {
+		for n := out.Nonces[i-1] + 1; n < out.Nonces[i]; n++ {
+			out.Gaps = append(out.Gaps, n)
+		}
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
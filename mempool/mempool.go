@@ -0,0 +1,287 @@
+// Package mempool mirrors the pending transactions of watched addresses from
+// the blocknative event stream. Transactions are indexed by hash, by sender
+// and by sender and nonce, and are evicted once they are confirmed, fail, are
+// dropped or are replaced, so callers can safely pick the next nonce to use
+// and see how much value is still in flight.
+package mempool
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+)
+
+// event codes sent by blocknative for transactions that are still pending
+var pendingCodes = map[string]bool{
+	"txPool":           true,
+	"txPoolSimulation": true,
+	"txSpeedUp":        true,
+	"txCancel":         true,
+	"txStuck":          true,
+}
+
+// event codes sent by blocknative for transactions that left the mempool
+var finalCodes = map[string]bool{
+	"txConfirmed": true,
+	"txFailed":    true,
+	"txDropped":   true,
+	"txRejected":  true,
+}
+
+// Tx is a pending transaction
+type Tx struct {
+	client.EthTransaction
+	// FirstSeen is when the first event for the transaction was received
+	FirstSeen time.Time `json:"firstSeen"`
+	// UpdatedAt is when the latest event for the transaction was received
+	UpdatedAt time.Time `json:"updatedAt"`
+	// EventCode is the code of the latest event for the transaction
+	EventCode string `json:"eventCode"`
+}
+
+// Cost returns the most the transaction can take from its sender, its value
+// plus its gas limit at its maximum fee per gas
+func (tx Tx) Cost() *big.Int {
+	cost := parseInt(tx.Value)
+	price := parseInt(tx.MaxFeePerGas)
+	if price.Sign() == 0 {
+		price = parseInt(tx.GasPrice)
+	}
+	fee := new(big.Int).Mul(price, big.NewInt(int64(tx.Gas)))
+	return cost.Add(cost, fee)
+}
+
+// Stats counts the transactions held and the events applied to the mempool
+type Stats struct {
+	Pending int    `json:"pending"`
+	Senders int    `json:"senders"`
+	Applied uint64 `json:"applied"`
+	Evicted uint64 `json:"evicted"`
+}
+
+type nonceKey struct {
+	from  string
+	nonce int
+}
+
+// Mempool holds pending transactions, it is safe for concurrent use
+type Mempool struct {
+	mx       sync.RWMutex
+	byHash   map[string]*Tx
+	bySender map[string]map[string]*Tx
+	byNonce  map[nonceKey]*Tx
+	applied  uint64
+	evicted  uint64
+}
+
+// New returns an empty mempool
+func New() *Mempool {
+	return &Mempool{
+		byHash:   make(map[string]*Tx),
+		bySender: make(map[string]map[string]*Tx),
+		byNonce:  make(map[nonceKey]*Tx),
+	}
+}
+
+// Run reads events from src and applies them until reading fails or ctx is done
+func (m *Mempool) Run(ctx context.Context, src client.Source) error {
+	for {
+		var raw json.RawMessage
+		if err := src.ReadJSON(&raw); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ev, err := client.NewEvent(time.Now(), raw)
+		if err != nil {
+			continue
+		}
+		m.Apply(ev)
+	}
+}
+
+// Apply updates the mempool with an event, ignoring events that do not refer to a transaction
+func (m *Mempool) Apply(ev *client.Event) {
+	tx := ev.Payload.Event.Transaction
+	code := ev.Payload.Event.EventCode
+	if tx.Hash == "" || tx.From == "" {
+		return
+	}
+	hash, from := strings.ToLower(tx.Hash), strings.ToLower(tx.From)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if finalCodes[code] || pendingCodes[code] {
+		m.applied++
+	}
+	switch {
+	case finalCodes[code]:
+		m.remove(hash)
+		if code == "txConfirmed" {
+			// once a nonce is mined every pending transaction at or below it is invalid
+			for h, other := range m.bySender[from] {
+				if other.Nonce <= tx.Nonce {
+					m.remove(h)
+				}
+			}
+		}
+	case pendingCodes[code]:
+		if tx.ReplaceHash != "" {
+			m.remove(strings.ToLower(tx.ReplaceHash))
+		}
+		key := nonceKey{from: from, nonce: tx.Nonce}
+		if prev, ok := m.byNonce[key]; ok && prev.Hash != hash {
+			m.remove(prev.Hash)
+		}
+		entry := &Tx{EthTransaction: tx, FirstSeen: ev.ReceivedAt}
+		if prev, ok := m.byHash[hash]; ok {
+			entry.FirstSeen = prev.FirstSeen
+		}
+		entry.Hash, entry.From = hash, from
+		entry.UpdatedAt = ev.ReceivedAt
+		entry.EventCode = code
+		m.byHash[hash] = entry
+		if m.bySender[from] == nil {
+			m.bySender[from] = make(map[string]*Tx)
+		}
+		m.bySender[from][hash] = entry
+		m.byNonce[key] = entry
+	}
+}
+
+// remove evicts a transaction from every index, the lock must be held
+func (m *Mempool) remove(hash string) {
+	tx, ok := m.byHash[hash]
+	if !ok {
+		return
+	}
+	delete(m.byHash, hash)
+	m.evicted++
+	delete(m.bySender[tx.From], hash)
+	if len(m.bySender[tx.From]) == 0 {
+		delete(m.bySender, tx.From)
+	}
+	key := nonceKey{from: tx.From, nonce: tx.Nonce}
+	if m.byNonce[key] == tx {
+		delete(m.byNonce, key)
+	}
+}
+
+// Get returns the pending transaction with the given hash
+func (m *Mempool) Get(hash string) (Tx, bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	tx, ok := m.byHash[strings.ToLower(hash)]
+	if !ok {
+		return Tx{}, false
+	}
+	return *tx, true
+}
+
+// ByNonce returns the pending transaction sent by from with the given nonce
+func (m *Mempool) ByNonce(from string, nonce int) (Tx, bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	tx, ok := m.byNonce[nonceKey{from: strings.ToLower(from), nonce: nonce}]
+	if !ok {
+		return Tx{}, false
+	}
+	return *tx, true
+}
+
+// Pending returns the pending transactions sent by from ordered by nonce
+func (m *Mempool) Pending(from string) []Tx {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	txs := make([]Tx, 0, len(m.bySender[strings.ToLower(from)]))
+	for _, tx := range m.bySender[strings.ToLower(from)] {
+		txs = append(txs, *tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce < txs[j].Nonce })
+	return txs
+}
+
+// HighestNonce returns the highest nonce pending for from, ok is false if nothing is pending
+func (m *Mempool) HighestNonce(from string) (nonce int, ok bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	for _, tx := range m.bySender[strings.ToLower(from)] {
+		if !ok || tx.Nonce > nonce {
+			nonce, ok = tx.Nonce, true
+		}
+	}
+	return nonce, ok
+}
+
+// Outflow returns the most the pending transactions sent by from can take
+// from its balance, the sum of their costs
+func (m *Mempool) Outflow(from string) *big.Int {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	total := new(big.Int)
+	for _, tx := range m.bySender[strings.ToLower(from)] {
+		total.Add(total, tx.Cost())
+	}
+	return total
+}
+
+// Senders returns every address with pending transactions
+func (m *Mempool) Senders() []string {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	senders := make([]string, 0, len(m.bySender))
+	for from := range m.bySender {
+		senders = append(senders, from)
+	}
+	sort.Strings(senders)
+	return senders
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return len(m.byHash)
+}
+
+// Stats returns the mempool's counters
+func (m *Mempool) Stats() Stats {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	return Stats{
+		Pending: len(m.byHash),
+		Senders: len(m.bySender),
+		Applied: m.applied,
+		Evicted: m.evicted,
+	}
+}
+
+// Prune evicts transactions that have not been updated since before, for
+// transactions blocknative stopped reporting on, returning how many were evicted
+func (m *Mempool) Prune(before time.Time) int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	var n int
+	for hash, tx := range m.byHash {
+		if tx.UpdatedAt.Before(before) {
+			m.remove(hash)
+			n++
+		}
+	}
+	return n
+}
+
+// parseInt parses a decimal or 0x prefixed hex integer, returning zero if it is malformed
+func parseInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return new(big.Int)
+	}
+	return v
+}
@@ -0,0 +1,137 @@
+package mempool
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/client/clienttest"
+	"github.com/stretchr/testify/require"
+)
+
+const sender = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+
+func TestMempoolReplay(t *testing.T) {
+	replay, err := client.OpenReplay("../client/testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer replay.Close()
+	m := New()
+	require.Equal(t, io.EOF, m.Run(context.Background(), replay))
+
+	// the first transaction was confirmed, leaving only the swap
+	require.Equal(t, 1, m.Len())
+	_, ok := m.Get("0x" + strings.Repeat("a1", 32))
+	require.False(t, ok)
+	pending := m.Pending(strings.ToUpper(sender))
+	require.Len(t, pending, 1)
+	require.Equal(t, 43, pending[0].Nonce)
+	require.Equal(t, "txPool", pending[0].EventCode)
+	nonce, ok := m.HighestNonce(sender)
+	require.True(t, ok)
+	require.Equal(t, 43, nonce)
+	// 1.5 ether plus 210000 gas at 120 gwei
+	want, _ := new(big.Int).SetString("1525200000000000000", 10)
+	require.Equal(t, want, m.Outflow(sender))
+	require.Equal(t, []string{sender}, m.Senders())
+}
+
+func TestMempoolEviction(t *testing.T) {
+	m := New()
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x01", From: sender, Nonce: 1, Value: "10", Gas: 1, GasPrice: "1"}))
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x02", From: sender, Nonce: 2, Value: "10", Gas: 1, GasPrice: "1"}))
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x03", From: sender, Nonce: 3, Value: "10", Gas: 1, GasPrice: "1"}))
+	require.Equal(t, big.NewInt(33), m.Outflow(sender))
+
+	// a speedup replaces the transaction it refers to
+	m.Apply(clienttest.Event(t, time.Now(), "txSpeedUp", client.EthTransaction{Hash: "0x04", ReplaceHash: "0x02", From: sender, Nonce: 2, Value: "10", Gas: 1, GasPrice: "2"}))
+	_, ok := m.Get("0x02")
+	require.False(t, ok)
+	tx, ok := m.ByNonce(sender, 2)
+	require.True(t, ok)
+	require.Equal(t, "0x04", tx.Hash)
+
+	// a different transaction with the same nonce replaces it too
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x05", From: sender, Nonce: 3, Value: "0", Gas: 1, GasPrice: "5"}))
+	_, ok = m.Get("0x03")
+	require.False(t, ok)
+	require.Equal(t, 3, m.Len())
+
+	// confirming a nonce evicts every transaction at or below it
+	m.Apply(clienttest.Event(t, time.Now(), "txConfirmed", client.EthTransaction{Hash: "0x04", From: sender, Nonce: 2}))
+	pending := m.Pending(sender)
+	require.Len(t, pending, 1)
+	require.Equal(t, "0x05", pending[0].Hash)
+
+	m.Apply(clienttest.Event(t, time.Now(), "txDropped", client.EthTransaction{Hash: "0x05", From: sender, Nonce: 3}))
+	_, ok = m.HighestNonce(sender)
+	require.False(t, ok)
+	require.Empty(t, m.Senders())
+	require.Equal(t, 0, m.Outflow(sender).Sign())
+
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x06", From: sender, Nonce: 4}))
+	require.Equal(t, 0, m.Prune(time.Now().Add(-time.Minute)))
+	require.Equal(t, 1, m.Prune(time.Now().Add(time.Minute)))
+	require.Equal(t, 0, m.Len())
+}
+
+func TestMempoolHTTP(t *testing.T) {
+	m := New()
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x01", From: sender, Nonce: 1, Value: "10", Gas: 1, GasPrice: "1"}))
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x04", From: sender, Nonce: 4, Value: "10", Gas: 1, GasPrice: "1"}))
+	m.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x05", From: "0x01"}))
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	get := func(path string, out interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		return resp.StatusCode
+	}
+
+	var txs []Tx
+	require.Equal(t, http.StatusOK, get("/pending?from="+strings.ToUpper(sender), &txs))
+	require.Len(t, txs, 2)
+	require.Equal(t, "0x04", txs[1].Hash)
+	require.Equal(t, http.StatusOK, get("/pending", &txs))
+	require.Len(t, txs, 3)
+
+	var tx Tx
+	require.Equal(t, http.StatusOK, get("/tx/0x01", &tx))
+	require.Equal(t, 1, tx.Nonce)
+	require.Equal(t, "txPool", tx.EventCode)
+	var errResp map[string]string
+	require.Equal(t, http.StatusNotFound, get("/tx/0x02", &errResp))
+	require.NotEmpty(t, errResp["error"])
+
+	var nonces Nonces
+	require.Equal(t, http.StatusOK, get("/address/"+sender+"/nonces", &nonces))
+	require.Equal(t, []int{1, 4}, nonces.Nonces)
+	require.Equal(t, 4, *nonces.Highest)
+	require.Equal(t, []int{2, 3}, nonces.Gaps)
+	require.Equal(t, http.StatusOK, get("/address/0x02/nonces", &nonces))
+	require.Nil(t, nonces.Highest)
+
+	var stats struct {
+		Stats
+		Senders map[string]SenderStats `json:"bySender"`
+	}
+	require.Equal(t, http.StatusOK, get("/stats", &stats))
+	require.Equal(t, 3, stats.Pending)
+	require.Equal(t, uint64(3), stats.Applied)
+	require.Equal(t, "22", stats.Senders[sender].Outflow)
+	require.Equal(t, 4, stats.Senders[sender].Highest)
+
+	resp, err := http.Post(srv.URL+"/stats", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
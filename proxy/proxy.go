@@ -0,0 +1,592 @@
+// Package proxy implements a local websocket server speaking blocknative's v0
+// protocol, letting many clients share upstream connections. Downstream
+// clients connect exactly as they would to blocknative, so existing
+// client.Client users only need to point Opts.Host at the proxy. Each
+// network and api key gets a single upstream connection, subscriptions are reference
+// counted across downstream clients and every upstream event is routed only
+// to the downstream clients that asked for it. Upstream connections which
+// drop are re-established with every subscription still held.
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/route"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// ServerVersion is reported to downstream clients
+const ServerVersion = "go-blocknative-proxy"
+
+const (
+	// reconnectBackoff is how long to wait before the first upstream reconnect attempt, doubling up to maxReconnectBackoff
+	reconnectBackoff    = time.Second
+	maxReconnectBackoff = time.Second * 30
+)
+
+// Opts configures the proxy
+type Opts struct {
+	// connection to blocknative. if APIKey is set every downstream client
+	// shares its connections, otherwise connections are only shared between
+	// clients initializing with the same dappId
+	Upstream client.Opts
+	// buffering of events for each downstream client, a slow client only
+	// loses its own events. defaults to DropOldest, Block is refused as a
+	// slow client would hold up every other client of its upstream
+	Buffer client.BufferOpts
+}
+
+// Server is an http.Handler accepting downstream websocket connections
+type Server struct {
+	opts     Opts
+	ctx      context.Context
+	cancel   context.CancelFunc
+	upgrader websocket.Upgrader
+	seq      uint64
+
+	mx        sync.Mutex
+	upstreams map[upstreamKey]*upstream
+	downs     map[string]*downstream
+}
+
+// upstreamKey identifies an upstream connection, which is billed to and rate limited under its api key
+type upstreamKey struct {
+	chain  client.Blockchain
+	apiKey string
+}
+
+// request is any message a downstream client can send
+type request struct {
+	client.BaseMessage
+	Transaction *client.Transaction `json:"transaction,omitempty"`
+	Account     *client.Account     `json:"account,omitempty"`
+	Config      *client.Config      `json:"config,omitempty"`
+}
+
+// reply acknowledges a request in the same format blocknative does
+type reply struct {
+	Version       int             `json:"version"`
+	ServerVersion string          `json:"serverVersion"`
+	TimeStamp     time.Time       `json:"timeStamp"`
+	ConnectionID  string          `json:"connectionId"`
+	Status        string          `json:"status"`
+	Reason        string          `json:"reason,omitempty"`
+	Event         json.RawMessage `json:"event,omitempty"`
+}
+
+// New returns a proxy server, upstream connections are opened as downstream clients initialize
+func New(ctx context.Context, opts Opts) (*Server, error) {
+	if opts.Buffer == (client.BufferOpts{}) {
+		opts.Buffer.Policy = client.DropOldest
+	}
+	if opts.Buffer.Policy == client.Block {
+		return nil, errors.New("downstream buffers can not use the block policy")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &Server{
+		opts:      opts,
+		ctx:       ctx,
+		cancel:    cancel,
+		upstreams: make(map[upstreamKey]*upstream),
+		downs:     make(map[string]*downstream),
+	}, nil
+}
+
+// ServeHTTP upgrades the request to a websocket and serves a downstream client until it disconnects
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	buf, err := client.NewEventBuffer(s.opts.Buffer)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	d := &downstream{
+		id:   fmt.Sprintf("proxy-%d", atomic.AddUint64(&s.seq, 1)),
+		conn: conn,
+		buf:  buf,
+	}
+	s.mx.Lock()
+	s.downs[d.id] = d
+	s.mx.Unlock()
+	defer s.disconnect(d)
+	if err := d.write(reply{ServerVersion: ServerVersion, TimeStamp: time.Now(), ConnectionID: d.id, Status: "ok"}); err != nil {
+		return
+	}
+	go d.forward(s.ctx)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			d.reply(data, errors.Wrap(err, "invalid message"))
+			continue
+		}
+		d.reply(data, s.handle(d, &req))
+	}
+}
+
+// Subscriptions returns the number of downstream clients holding each upstream subscription of a network
+func (s *Server) Subscriptions(chain client.Blockchain) map[route.Key]int {
+	s.mx.Lock()
+	var ups []*upstream
+	for key, up := range s.upstreams {
+		if key.chain == chain {
+			ups = append(ups, up)
+		}
+	}
+	s.mx.Unlock()
+	refs := make(map[route.Key]int)
+	for _, up := range ups {
+		for _, key := range up.table.Keys() {
+			refs[key] += up.table.Refs(key)
+		}
+	}
+	return refs
+}
+
+// Close disconnects every downstream client and upstream connection
+func (s *Server) Close() error {
+	s.cancel()
+	s.mx.Lock()
+	ups := make([]*upstream, 0, len(s.upstreams))
+	for _, up := range s.upstreams {
+		ups = append(ups, up)
+	}
+	downs := make([]*downstream, 0, len(s.downs))
+	for _, d := range s.downs {
+		downs = append(downs, d)
+	}
+	s.mx.Unlock()
+	for _, d := range downs {
+		d.conn.Close()
+	}
+	for _, up := range ups {
+		<-up.ready
+		if up.err == nil {
+			up.client.Close()
+		}
+	}
+	return nil
+}
+
+// handle applies a single downstream request
+func (s *Server) handle(d *downstream, req *request) error {
+	if req.CategoryCode == "initialize" && req.EventCode == "checkDappId" {
+		up, err := s.upstream(req.Blockchain, req.DappID)
+		if err != nil {
+			return err
+		}
+		return up.attach(d)
+	}
+	up := d.upstream()
+	if up == nil {
+		return errors.New("connection not initialized")
+	}
+	switch {
+	case req.CategoryCode == "accountAddress" && req.Account != nil:
+		msg, err := client.NewAddressSubscribeChecked(up.base(), req.Account.Address)
+		if err != nil {
+			return err
+		}
+		key := route.AddressKey(msg.Address)
+		switch req.EventCode {
+		case "watch":
+			return up.subscribe(d, key, msg)
+		case "unwatch":
+			return up.unsubscribe(d, key)
+		}
+	case req.CategoryCode == "activeTransaction" && req.Transaction != nil:
+		msg, err := client.NewTxSubscribeChecked(up.base(), req.Transaction.Hash)
+		if err != nil {
+			return err
+		}
+		key := route.TxKey(msg.Hash)
+		switch req.EventCode {
+		case "txSent":
+			return up.subscribe(d, key, msg)
+		case "unwatch":
+			return up.unsubscribe(d, key)
+		}
+	case req.CategoryCode == "configs" && req.EventCode == "put" && req.Config != nil:
+		cfg, err := client.NewConfigChecked(req.Config.Scope, req.Config.WatchAddress, req.Config.ABI)
+		if err != nil {
+			return err
+		}
+		cfg.Filters = req.Config.Filters
+		return up.configure(d, cfg)
+	}
+	return errors.Errorf("unsupported message categoryCode:%v eventCode:%v", req.CategoryCode, req.EventCode)
+}
+
+// upstream returns the connection for chain and the client's dappId,
+// opening and initializing it if needed. Connecting happens outside the
+// server's lock so a slow upstream only holds up clients waiting for it.
+func (s *Server) upstream(chain client.Blockchain, dappID string) (*upstream, error) {
+	key := upstreamKey{chain: chain, apiKey: s.opts.Upstream.APIKey}
+	if key.apiKey == "" {
+		key.apiKey = dappID
+	}
+	if key.apiKey == "" {
+		return nil, errors.New("dappId must be set")
+	}
+	s.mx.Lock()
+	up, ok := s.upstreams[key]
+	if !ok {
+		up = &upstream{
+			chain:   chain,
+			apiKey:  key.apiKey,
+			table:   route.NewTable(),
+			configs: make(map[string]client.Config),
+			downs:   make(map[string]*downstream),
+			ready:   make(chan struct{}),
+		}
+		s.upstreams[key] = up
+	}
+	s.mx.Unlock()
+	if !ok {
+		if up.err = s.dial(up); up.err != nil {
+			s.mx.Lock()
+			if s.upstreams[key] == up {
+				delete(s.upstreams, key)
+			}
+			s.mx.Unlock()
+		} else {
+			go s.read(up)
+		}
+		close(up.ready)
+	}
+	<-up.ready
+	if up.err != nil {
+		return nil, up.err
+	}
+	return up, nil
+}
+
+// dial opens and initializes the connection of up
+func (s *Server) dial(up *upstream) error {
+	opts := s.opts.Upstream
+	opts.APIKey = up.apiKey
+	// subscriptions are resent from the route table on reconnect, a history would repeat unsubscribed ones
+	opts.History = nil
+	cl, err := client.New(s.ctx, opts)
+	if err != nil {
+		return errors.Wrap(err, "connecting upstream")
+	}
+	if err := cl.Initialize(up.base()); err != nil {
+		cl.Close()
+		return errors.Wrap(err, "initializing upstream")
+	}
+	up.client = cl
+	return nil
+}
+
+// read routes upstream events to downstream clients until the server is
+// closed, reconnecting whenever the upstream connection drops
+func (s *Server) read(up *upstream) {
+	for {
+		var raw json.RawMessage
+		if err := up.client.ReadJSON(&raw); err != nil {
+			if s.ctx.Err() != nil {
+				break
+			}
+			log.Printf("upstream %s/%s lost, reconnecting: %v", up.chain.System, up.chain.Network, err)
+			if !s.reconnect(up) {
+				break
+			}
+			continue
+		}
+		ev, err := client.NewEvent(time.Now(), raw)
+		if err != nil {
+			log.Println("discarding upstream event: ", err)
+			continue
+		}
+		if ev.Payload.Status != "" && ev.Payload.Status != "ok" {
+			log.Printf("upstream error: %s", raw)
+			continue
+		}
+		for _, d := range up.match(ev) {
+			d.buf.Push(s.ctx, ev)
+		}
+	}
+	s.mx.Lock()
+	key := upstreamKey{chain: up.chain, apiKey: up.apiKey}
+	if s.upstreams[key] == up {
+		delete(s.upstreams, key)
+	}
+	s.mx.Unlock()
+	up.mx.Lock()
+	for _, d := range up.downs {
+		d.conn.Close()
+	}
+	up.mx.Unlock()
+}
+
+// reconnect retries with exponential backoff until the upstream connection is
+// re-established with every subscription held by downstream clients, or until
+// the server is closed
+func (s *Server) reconnect(up *upstream) bool {
+	backoff := reconnectBackoff
+	for {
+		select {
+		case <-s.ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		err := up.client.Reconnect()
+		if err == nil {
+			err = up.resubscribe()
+		}
+		if err == nil {
+			log.Printf("reconnected upstream %s/%s", up.chain.System, up.chain.Network)
+			return true
+		}
+		log.Printf("reconnecting upstream %s/%s failed: %v", up.chain.System, up.chain.Network, err)
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// disconnect releases every subscription held by a downstream client
+func (s *Server) disconnect(d *downstream) {
+	s.mx.Lock()
+	delete(s.downs, d.id)
+	s.mx.Unlock()
+	d.buf.Close()
+	d.conn.Close()
+	if up := d.upstream(); up != nil {
+		up.detach(d)
+	}
+}
+
+type upstream struct {
+	chain  client.Blockchain
+	apiKey string
+	// set before ready is closed, client is only valid if err is nil
+	client *client.Client
+	err    error
+	ready  chan struct{}
+	table  *route.Table
+	// serializes table changes with the upstream messages they cause so a
+	// concurrent unwatch and watch of the same key cannot be reordered
+	smx sync.Mutex
+	// config put for each held scope, keyed like route.ConfigKey, guarded by smx
+	configs map[string]client.Config
+
+	mx    sync.Mutex
+	downs map[string]*downstream
+}
+
+func (up *upstream) base() client.BaseMessage {
+	return client.BaseMessage{Timestamp: time.Now(), DappID: up.apiKey, Blockchain: up.chain}
+}
+
+func (up *upstream) attach(d *downstream) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.up != nil && d.up != up {
+		return errors.New("connection already initialized for another network")
+	}
+	d.up = up
+	up.mx.Lock()
+	up.downs[d.id] = d
+	up.mx.Unlock()
+	return nil
+}
+
+// detach removes a downstream client, unsubscribing upstream from anything no one else holds
+func (up *upstream) detach(d *downstream) {
+	up.mx.Lock()
+	delete(up.downs, d.id)
+	up.mx.Unlock()
+	up.smx.Lock()
+	defer up.smx.Unlock()
+	for _, key := range up.table.RemoveAll(d.id) {
+		up.release(key)
+	}
+}
+
+func (up *upstream) subscribe(d *downstream, key route.Key, msg interface{}) error {
+	up.smx.Lock()
+	defer up.smx.Unlock()
+	if !up.table.Add(d.id, key) {
+		return nil
+	}
+	if err := up.client.WriteJSON(msg); err != nil {
+		up.table.Remove(d.id, key)
+		return err
+	}
+	return nil
+}
+
+func (up *upstream) unsubscribe(d *downstream, key route.Key) error {
+	up.smx.Lock()
+	defer up.smx.Unlock()
+	if !up.table.Remove(d.id, key) {
+		return nil
+	}
+	return up.release(key)
+}
+
+// configure puts cfg for its scope. Configs are shared by every client of the
+// upstream, so a scope can only be configured differently while no other client holds it.
+func (up *upstream) configure(d *downstream, cfg client.Config) error {
+	up.smx.Lock()
+	defer up.smx.Unlock()
+	key := route.ConfigKey(cfg.Scope)
+	cur, ok := up.configs[key.Value]
+	if ok && reflect.DeepEqual(cur, cfg) {
+		up.table.Add(d.id, key)
+		return nil
+	}
+	if others := up.table.Refs(key) - up.holds(d, key); others > 0 {
+		return errors.Errorf("scope %s is configured differently by %d other clients", cfg.Scope, others)
+	}
+	first := up.table.Add(d.id, key)
+	if err := up.client.WriteJSON(client.NewConfiguration(up.base(), cfg)); err != nil {
+		if first {
+			up.table.Remove(d.id, key)
+		}
+		return err
+	}
+	up.configs[key.Value] = cfg
+	return nil
+}
+
+// holds returns 1 if d holds key and 0 otherwise
+func (up *upstream) holds(d *downstream, key route.Key) int {
+	for _, held := range up.table.Held(d.id) {
+		if held == key {
+			return 1
+		}
+	}
+	return 0
+}
+
+// resubscribe sends every subscription held by downstream clients, such as after reconnecting
+func (up *upstream) resubscribe() error {
+	up.smx.Lock()
+	defer up.smx.Unlock()
+	for _, key := range up.table.Keys() {
+		var msg interface{}
+		switch key.Kind {
+		case route.Address:
+			msg = client.NewAddressSubscribe(up.base(), key.Value)
+		case route.Transaction:
+			msg = client.NewTxSubscribe(up.base(), key.Value)
+		case route.Config:
+			cfg, ok := up.configs[key.Value]
+			if !ok {
+				continue
+			}
+			msg = client.NewConfiguration(up.base(), cfg)
+		}
+		if err := up.client.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release unsubscribes upstream from a key no downstream client holds anymore, smx must be held
+func (up *upstream) release(key route.Key) error {
+	switch key.Kind {
+	case route.Address:
+		if _, ok := up.configs[key.Value]; ok {
+			// unwatching the address would also remove the config still held for it
+			return nil
+		}
+		return up.client.WriteJSON(client.NewAddressUnsubscribe(up.base(), key.Value))
+	case route.Transaction:
+		return up.client.WriteJSON(client.NewTxUnsubscribe(up.base(), key.Value))
+	}
+	delete(up.configs, key.Value)
+	switch {
+	case key.Value == "global":
+		// the global config can not be removed, so it is reset to one without filters
+		return up.client.WriteJSON(client.NewConfiguration(up.base(), client.NewConfig("global", false, nil)))
+	case up.table.Refs(route.AddressKey(key.Value)) > 0:
+		// the address is still watched, only its filters and abi are dropped
+		return up.client.WriteJSON(client.NewConfiguration(up.base(), client.NewConfig(key.Value, true, nil)))
+	}
+	// unwatching the scope removes its config
+	return up.client.WriteJSON(client.NewAddressUnsubscribe(up.base(), key.Value))
+}
+
+func (up *upstream) match(ev *client.Event) []*downstream {
+	ids := up.table.Match(ev)
+	up.mx.Lock()
+	defer up.mx.Unlock()
+	downs := make([]*downstream, 0, len(ids))
+	for _, id := range ids {
+		if d, ok := up.downs[id]; ok {
+			downs = append(downs, d)
+		}
+	}
+	return downs
+}
+
+type downstream struct {
+	id   string
+	conn *websocket.Conn
+	buf  *client.EventBuffer
+	wmx  sync.Mutex
+
+	mx sync.Mutex
+	up *upstream
+}
+
+func (d *downstream) upstream() *upstream {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.up
+}
+
+// forward writes routed events to the client until its buffer is closed
+func (d *downstream) forward(ctx context.Context) {
+	for {
+		ev, err := d.buf.Next(ctx)
+		if err != nil {
+			return
+		}
+		d.wmx.Lock()
+		err = d.conn.WriteMessage(websocket.TextMessage, ev.Raw)
+		d.wmx.Unlock()
+		if err != nil {
+			d.conn.Close()
+			return
+		}
+	}
+}
+
+func (d *downstream) reply(event []byte, err error) {
+	r := reply{ServerVersion: ServerVersion, TimeStamp: time.Now(), ConnectionID: d.id, Status: "ok"}
+	if json.Valid(event) {
+		r.Event = event
+	}
+	if err != nil {
+		r.Status, r.Reason = "error", err.Error()
+	}
+	d.write(r)
+}
+
+func (d *downstream) write(v interface{}) error {
+	d.wmx.Lock()
+	defer d.wmx.Unlock()
+	return d.conn.WriteJSON(v)
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/route"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpstream acknowledges every message, records what it receives and lets tests push events
+type fakeUpstream struct {
+	mx       sync.Mutex
+	conns    []*websocket.Conn
+	received []request
+}
+
+func (f *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	f.mx.Lock()
+	f.conns = append(f.conns, conn)
+	conn.WriteJSON(client.ConnectResponse{Status: "ok"})
+	f.mx.Unlock()
+	for {
+		var req request
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		f.mx.Lock()
+		f.received = append(f.received, req)
+		conn.WriteJSON(client.ConnectResponse{Status: "ok"})
+		f.mx.Unlock()
+	}
+}
+
+func (f *fakeUpstream) push(t *testing.T, frame string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for _, conn := range f.conns {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	}
+}
+
+// drop closes every connection, as if blocknative went away
+func (f *fakeUpstream) drop() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+// take returns and clears the requests received so far as event codes and dappIds
+func (f *fakeUpstream) take() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	var taken []string
+	for _, req := range f.received {
+		taken = append(taken, req.EventCode+" "+req.DappID)
+	}
+	f.received = nil
+	return taken
+}
+
+func (f *fakeUpstream) codes() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	var codes []string
+	for _, req := range f.received {
+		codes = append(codes, req.EventCode)
+	}
+	return codes
+}
+
+func hostOf(t *testing.T, srv *httptest.Server) string {
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u.Host
+}
+
+func TestProxy(t *testing.T) {
+	ctx := context.Background()
+	upstream := &fakeUpstream{}
+	upSrv := httptest.NewServer(upstream)
+	defer upSrv.Close()
+	px, err := New(ctx, Opts{Upstream: client.Opts{Scheme: "ws", Host: hostOf(t, upSrv), APIKey: "key"}})
+	require.NoError(t, err)
+	defer px.Close()
+	pxSrv := httptest.NewServer(px)
+	defer pxSrv.Close()
+
+	connect := func() *client.Client {
+		cl, err := client.New(ctx, client.Opts{Scheme: "ws", Host: hostOf(t, pxSrv)})
+		require.NoError(t, err)
+		require.NoError(t, cl.Initialize(client.NewBaseMessageMainnet("downstream")))
+		return cl
+	}
+	// reads frames until one refers to a transaction, skipping acknowledgements
+	next := func(cl *client.Client) client.EthTxPayload {
+		for {
+			var out client.EthTxPayload
+			require.NoError(t, cl.ReadJSON(&out))
+			if out.Event.Transaction.Hash != "" {
+				return out
+			}
+		}
+	}
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	other := "0x88df592f8eb5d7bd38bfef7deb0fbc02cf3778a0"
+	a, b := connect(), connect()
+	defer a.Close()
+	// configs for malformed scopes are refused
+	require.Error(t, a.EventSub(client.NewConfiguration(client.NewBaseMessageMainnet(""), client.NewConfig("0x1", false, nil))))
+	require.NoError(t, a.WriteJSON(client.NewAddressSubscribe(client.NewBaseMessageMainnet(""), addr)))
+	require.NoError(t, b.WriteJSON(client.NewAddressSubscribe(client.NewBaseMessageMainnet(""), addr)))
+	require.NoError(t, b.WriteJSON(client.NewAddressSubscribe(client.NewBaseMessageMainnet(""), other)))
+	main := client.Blockchain{System: "ethereum", Network: "main"}
+	require.Eventually(t, func() bool {
+		subs := px.Subscriptions(main)
+		return subs[route.AddressKey(addr)] == 2 && subs[route.AddressKey(other)] == 1
+	}, time.Second, time.Millisecond*10)
+	// one upstream connection, initialized once and subscribed once per address
+	require.Equal(t, []string{"checkDappId", "watch", "watch"}, upstream.codes())
+
+	upstream.push(t, `{"status":"ok","event":{"transaction":{"hash":"0x01","watchedAddress":"`+other+`"}}}`)
+	upstream.push(t, `{"status":"ok","event":{"transaction":{"hash":"0x02","watchedAddress":"`+addr+`"}}}`)
+	// a only receives events for the address it asked for
+	require.Equal(t, "0x02", next(a).Event.Transaction.Hash)
+	require.Equal(t, "0x01", next(b).Event.Transaction.Hash)
+	require.Equal(t, "0x02", next(b).Event.Transaction.Hash)
+
+	// disconnecting b only unsubscribes the address nobody else holds
+	require.NoError(t, b.Close())
+	require.Eventually(t, func() bool {
+		return len(upstream.codes()) == 4
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, "unwatch", upstream.codes()[3])
+	require.Equal(t, other, upstream.received[3].Account.Address)
+	require.NoError(t, a.WriteJSON(client.NewAddressUnsubscribe(client.NewBaseMessageMainnet(""), addr)))
+	require.Eventually(t, func() bool {
+		return len(upstream.codes()) == 5
+	}, time.Second, time.Millisecond*10)
+	require.Empty(t, px.Subscriptions(main))
+}
+
+func TestProxyUninitialized(t *testing.T) {
+	px, err := New(context.Background(), Opts{})
+	require.NoError(t, err)
+	defer px.Close()
+	srv := httptest.NewServer(px)
+	defer srv.Close()
+	cl, err := client.New(context.Background(), client.Opts{Scheme: "ws", Host: hostOf(t, srv)})
+	require.NoError(t, err)
+	defer cl.Close()
+	require.Error(t, cl.EventSub(client.NewConfiguration(client.NewBaseMessageMainnet(""), client.NewConfig("global", false, nil))))
+}
+
+func TestProxyUpstreams(t *testing.T) {
+	ctx := context.Background()
+	upstream := &fakeUpstream{}
+	upSrv := httptest.NewServer(upstream)
+	defer upSrv.Close()
+	px, err := New(ctx, Opts{Upstream: client.Opts{Scheme: "ws", Host: hostOf(t, upSrv)}})
+	require.NoError(t, err)
+	defer px.Close()
+	pxSrv := httptest.NewServer(px)
+	defer pxSrv.Close()
+	connect := func(dappID string) *client.Client {
+		cl, err := client.New(ctx, client.Opts{Scheme: "ws", Host: hostOf(t, pxSrv)})
+		require.NoError(t, err)
+		require.NoError(t, cl.Initialize(client.NewBaseMessageMainnet(dappID)))
+		t.Cleanup(func() { cl.Close() })
+		return cl
+	}
+	addr := "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	main := client.Blockchain{System: "ethereum", Network: "main"}
+
+	// without an upstream key every dappId gets its own upstream connection
+	a, b := connect("key-a"), connect("key-b")
+	require.NoError(t, a.WriteJSON(client.NewAddressSubscribe(client.NewBaseMessageMainnet("key-a"), addr)))
+	require.NoError(t, b.WriteJSON(client.NewAddressSubscribe(client.NewBaseMessageMainnet("key-b"), addr)))
+	require.Eventually(t, func() bool { return px.Subscriptions(main)[route.AddressKey(addr)] == 2 }, time.Second, time.Millisecond*10)
+	require.ElementsMatch(t, []string{"checkDappId key-a", "checkDappId key-b", "watch key-a", "watch key-b"}, upstream.take())
+
+	// dropped upstream connections are re-established with their subscriptions
+	upstream.drop()
+	require.Eventually(t, func() bool { return len(upstream.codes()) == 4 }, time.Second*5, time.Millisecond*10)
+	require.ElementsMatch(t, []string{"checkDappId key-a", "checkDappId key-b", "watch key-a", "watch key-b"}, upstream.take())
+	upstream.push(t, `{"status":"ok","event":{"transaction":{"hash":"0x01","watchedAddress":"`+addr+`"}}}`)
+	for _, cl := range []*client.Client{a, b} {
+		for {
+			var out client.EthTxPayload
+			require.NoError(t, cl.ReadJSON(&out))
+			if out.Event.Transaction.Hash != "" {
+				require.Equal(t, "0x01", out.Event.Transaction.Hash)
+				break
+			}
+		}
+	}
+}
+
+func TestProxyConfigs(t *testing.T) {
+	ctx := context.Background()
+	_, err := New(ctx, Opts{Buffer: client.BufferOpts{Size: 10, Policy: client.Block}})
+	require.Error(t, err)
+
+	upstream := &fakeUpstream{}
+	upSrv := httptest.NewServer(upstream)
+	defer upSrv.Close()
+	px, err := New(ctx, Opts{Upstream: client.Opts{Scheme: "ws", Host: hostOf(t, upSrv), APIKey: "key"}})
+	require.NoError(t, err)
+	defer px.Close()
+	pxSrv := httptest.NewServer(px)
+	defer pxSrv.Close()
+	connect := func() *client.Client {
+		cl, err := client.New(ctx, client.Opts{Scheme: "ws", Host: hostOf(t, pxSrv)})
+		require.NoError(t, err)
+		require.NoError(t, cl.Initialize(client.NewBaseMessageMainnet("downstream")))
+		t.Cleanup(func() { cl.Close() })
+		return cl
+	}
+	put := func(cl *client.Client, status string) error {
+		cfg := client.NewConfig("0x7a250d5630b4cf539739df2c5dacb4c659f2488d", true, nil)
+		cfg.Filters = []map[string]string{{"status": status}}
+		return cl.EventSub(client.NewConfiguration(client.NewBaseMessageMainnet(""), cfg))
+	}
+	main := client.Blockchain{System: "ethereum", Network: "main"}
+	scope := route.ConfigKey("0x7a250d5630b4cf539739df2c5dacb4c659f2488d")
+	a, b := connect(), connect()
+	require.NoError(t, put(a, "pending"))
+	// the same config is shared, a different one is refused while another client holds the scope
+	require.NoError(t, put(b, "pending"))
+	require.Error(t, put(b, "confirmed"))
+	require.Equal(t, 2, px.Subscriptions(main)[scope])
+	require.Equal(t, []string{"checkDappId", "put"}, upstream.codes())
+
+	// once b is the only holder it may change the config, which is reset when it leaves
+	require.NoError(t, a.Close())
+	require.Eventually(t, func() bool { return px.Subscriptions(main)[scope] == 1 }, time.Second, time.Millisecond*10)
+	require.NoError(t, put(b, "confirmed"))
+	require.NoError(t, b.Close())
+	require.Eventually(t, func() bool { return len(upstream.codes()) == 4 }, time.Second, time.Millisecond*10)
+	require.Equal(t, []string{"checkDappId", "put", "put", "unwatch"}, upstream.codes())
+	require.Equal(t, "0x7a250d5630b4cf539739df2c5dacb4c659f2488d", upstream.received[3].Account.Address)
+	require.Empty(t, px.Subscriptions(main))
+}
@@ -0,0 +1,198 @@
+// Package route tracks which subscribers asked for which blocknative
+// subscriptions, reference counting them so that a shared connection only
+// subscribes once, and routes events to the subscribers they belong to.
+package route
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bonedaddy/go-blocknative/client"
+)
+
+// Kind is the type of subscription a key refers to
+type Kind string
+
+const (
+	// Address subscriptions are created with client.NewAddressSubscribe
+	Address Kind = "address"
+	// Transaction subscriptions are created with client.NewTxSubscribe
+	Transaction Kind = "tx"
+	// Config subscriptions are created with client.NewConfiguration
+	Config Kind = "config"
+)
+
+// Key identifies a single upstream subscription
+type Key struct {
+	Kind  Kind
+	Value string
+}
+
+// AddressKey returns the key of an address subscription
+func AddressKey(address string) Key {
+	return Key{Kind: Address, Value: strings.ToLower(address)}
+}
+
+// TxKey returns the key of a transaction subscription
+func TxKey(hash string) Key {
+	return Key{Kind: Transaction, Value: strings.ToLower(hash)}
+}
+
+// ConfigKey returns the key of a config subscription
+func ConfigKey(scope string) Key {
+	return Key{Kind: Config, Value: strings.ToLower(scope)}
+}
+
+// String returns the key as kind:value
+func (k Key) String() string {
+	return string(k.Kind) + ":" + k.Value
+}
+
+// Keys returns every key an event should be routed by: the transaction hash,
+// and the watched address as both an address and a config scope
+func Keys(ev *client.Event) []Key {
+	txn := ev.Payload.Event.Transaction
+	var keys []Key
+	if txn.Hash != "" {
+		keys = append(keys, TxKey(txn.Hash))
+	}
+	if txn.ReplaceHash != "" {
+		// speedups and cancels are delivered to those watching the replaced transaction
+		keys = append(keys, TxKey(txn.ReplaceHash))
+	}
+	if txn.WatchedAddress != "" {
+		keys = append(keys, AddressKey(txn.WatchedAddress), ConfigKey(txn.WatchedAddress))
+	}
+	return keys
+}
+
+// Table maps keys to the subscribers holding them
+type Table struct {
+	mx   sync.RWMutex
+	subs map[Key]map[string]struct{}
+	held map[string]map[Key]struct{}
+}
+
+// NewTable returns an empty table
+func NewTable() *Table {
+	return &Table{
+		subs: make(map[Key]map[string]struct{}),
+		held: make(map[string]map[Key]struct{}),
+	}
+}
+
+// Add records that subscriber holds key, returning true if it is the first
+// subscriber to do so and the upstream subscription needs to be created
+func (t *Table) Add(subscriber string, key Key) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.held[subscriber] == nil {
+		t.held[subscriber] = make(map[Key]struct{})
+	}
+	t.held[subscriber][key] = struct{}{}
+	subs := t.subs[key]
+	if subs == nil {
+		subs = make(map[string]struct{})
+		t.subs[key] = subs
+	}
+	subs[subscriber] = struct{}{}
+	return len(subs) == 1
+}
+
+// Remove releases key for subscriber, returning true if no subscribers are
+// left holding it and the upstream subscription can be removed
+func (t *Table) Remove(subscriber string, key Key) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.remove(subscriber, key)
+}
+
+// RemoveAll releases every key held by subscriber, returning the keys no longer held by anyone
+func (t *Table) RemoveAll(subscriber string) []Key {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	var released []Key
+	for key := range t.held[subscriber] {
+		if t.remove(subscriber, key) {
+			released = append(released, key)
+		}
+	}
+	sortKeys(released)
+	return released
+}
+
+// Refs returns the number of subscribers holding key
+func (t *Table) Refs(key Key) int {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return len(t.subs[key])
+}
+
+// Held returns the keys held by subscriber
+func (t *Table) Held(subscriber string) []Key {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	keys := make([]Key, 0, len(t.held[subscriber]))
+	for key := range t.held[subscriber] {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// Keys returns every key held by at least one subscriber, such as to resend
+// the subscriptions of a shared connection after it reconnected
+func (t *Table) Keys() []Key {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	keys := make([]Key, 0, len(t.subs))
+	for key := range t.subs {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+// Match returns the subscribers an event should be delivered to
+func (t *Table) Match(ev *client.Event) []string {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	seen := make(map[string]struct{})
+	var matched []string
+	for _, key := range Keys(ev) {
+		for sub := range t.subs[key] {
+			if _, ok := seen[sub]; ok {
+				continue
+			}
+			seen[sub] = struct{}{}
+			matched = append(matched, sub)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+func (t *Table) remove(subscriber string, key Key) bool {
+	subs, ok := t.subs[key]
+	if !ok {
+		return false
+	}
+	if _, ok := subs[subscriber]; !ok {
+		return false
+	}
+	delete(subs, subscriber)
+	delete(t.held[subscriber], key)
+	if len(t.held[subscriber]) == 0 {
+		delete(t.held, subscriber)
+	}
+	if len(subs) > 0 {
+		return false
+	}
+	delete(t.subs, key)
+	return true
+}
+
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+}
@@ -0,0 +1,36 @@
+package route
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	table := NewTable()
+	addr := AddressKey("0xFA6DE2697D59E88ED7FC4DFE5A33DAC43565EA41")
+	tx := TxKey("0x01")
+	require.True(t, table.Add("a", addr))
+	require.False(t, table.Add("b", addr))
+	require.True(t, table.Add("b", tx))
+	require.Equal(t, 2, table.Refs(addr))
+	require.Equal(t, []Key{addr, tx}, table.Held("b"))
+	require.Equal(t, []Key{addr, tx}, table.Keys())
+
+	ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{
+		"hash":"0x02","watchedAddress":"0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"}}}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, table.Match(ev))
+	speedup, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x03","replaceHash":"0x01"}}}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, table.Match(speedup))
+
+	require.False(t, table.Remove("a", addr))
+	require.False(t, table.Remove("a", addr))
+	require.Equal(t, []Key{addr, tx}, table.RemoveAll("b"))
+	require.Equal(t, 0, table.Refs(addr))
+	require.Empty(t, table.Match(ev))
+	require.Empty(t, table.Keys())
+}
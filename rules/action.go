@@ -0,0 +1,251 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/webhook"
+	"github.com/pkg/errors"
+)
+
+// Action types supported by ActionSpec
+const (
+	LogAction     = "log"
+	WebhookAction = "webhook"
+	SMTPAction    = "smtp"
+	ExecAction    = "exec"
+)
+
+const (
+	// DefaultActionTimeout bounds webhook, smtp and exec actions without a timeout
+	DefaultActionTimeout = time.Second * 10
+	// DefaultSMTPAddr is the relay used by smtp actions without an address
+	DefaultSMTPAddr = "localhost:25"
+)
+
+// ActionSpec declares what is done when a rule fires
+type ActionSpec struct {
+	// one of log, webhook, smtp or exec
+	Type string `yaml:"type"`
+	// webhook: the alert is posted as json, signed like package webhook does if a secret is set
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+	// smtp: relay address, defaults to DefaultSMTPAddr, the sender, recipients
+	// and a subject template, which defaults to the severity and rule name
+	Addr    string   `yaml:"addr"`
+	From    string   `yaml:"from"`
+	To      []string `yaml:"to"`
+	Subject string   `yaml:"subject"`
+	// exec: command and arguments run with the alert as json on stdin and
+	// BLOCKNATIVE_RULE, BLOCKNATIVE_SEVERITY, BLOCKNATIVE_MESSAGE and BLOCKNATIVE_HASH set
+	Command []string `yaml:"command"`
+	// how long a webhook, smtp or exec action may take, defaults to DefaultActionTimeout
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// action is run with every alert of its rule
+type action interface {
+	run(ctx context.Context, a *Alert) error
+}
+
+func newAction(s ActionSpec, opts Opts) (action, error) {
+	if s.Timeout <= 0 {
+		s.Timeout = DefaultActionTimeout
+	}
+	switch s.Type {
+	case LogAction:
+		return logAction{}, nil
+	case WebhookAction:
+		if s.URL == "" {
+			return nil, errors.New("webhook action: url must be set")
+		}
+		return &webhookAction{ActionSpec: s, client: opts.Client}, nil
+	case SMTPAction:
+		if s.From == "" || len(s.To) == 0 {
+			return nil, errors.New("smtp action: from and to must be set")
+		}
+		for _, addr := range append([]string{s.From}, s.To...) {
+			if strings.ContainsAny(addr, "\r\n") {
+				return nil, errors.Errorf("smtp action: invalid address:%q", addr)
+			}
+		}
+		if s.Addr == "" {
+			s.Addr = DefaultSMTPAddr
+		}
+		if s.Subject == "" {
+			s.Subject = "[{{.Severity}}] {{.Rule}}"
+		}
+		subject, err := newTemplate(s.Subject)
+		if err != nil {
+			return nil, errors.Wrap(err, "smtp action: parsing subject")
+		}
+		return &smtpAction{ActionSpec: s, subject: subject}, nil
+	case ExecAction:
+		if len(s.Command) == 0 {
+			return nil, errors.New("exec action: command must be set")
+		}
+		return &execAction{s}, nil
+	}
+	return nil, errors.Errorf("unknown action type:%v", s.Type)
+}
+
+// logAction writes the alert message to the standard logger
+type logAction struct{}
+
+func (logAction) run(ctx context.Context, a *Alert) error {
+	log.Printf("%s alert %s", a.Severity, a.Message)
+	return nil
+}
+
+// webhookAction posts the alert to a url
+type webhookAction struct {
+	ActionSpec
+	client *http.Client
+}
+
+func (w *webhookAction) run(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(webhook.DefaultSignatureHeader, webhook.Sign([]byte(w.Secret), body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("endpoint returned status:%v", resp.Status)
+	}
+	return nil
+}
+
+// smtpAction mails the alert through a relay which needs no authentication, such as a local mta
+type smtpAction struct {
+	ActionSpec
+	subject *template.Template
+}
+
+func (s *smtpAction) run(ctx context.Context, a *Alert) error {
+	var subject strings.Builder
+	if err := s.subject.Execute(&subject, a); err != nil {
+		return errors.Wrap(err, "formatting subject")
+	}
+	details, err := json.MarshalIndent(a.Transaction, "", "  ")
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", header(s.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", header(strings.Join(s.To, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", header(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\n%s\r\n", a.Message, bytes.ReplaceAll(details, []byte("\n"), []byte("\r\n")))
+
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// header strips line breaks from a header value so templated event fields can not add headers
+func header(v string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(v)
+}
+
+// execAction runs a command
+type execAction struct {
+	ActionSpec
+}
+
+func (e *execAction) run(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	hash := ""
+	if len(a.Hashes) > 0 {
+		hash = a.Hashes[len(a.Hashes)-1]
+	}
+	cmd.Env = append(os.Environ(),
+		"BLOCKNATIVE_RULE="+a.Rule,
+		"BLOCKNATIVE_SEVERITY="+a.Severity,
+		"BLOCKNATIVE_MESSAGE="+a.Message,
+		"BLOCKNATIVE_HASH="+hash,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return errors.Wrapf(err, "%s", out)
+		}
+		return err
+	}
+	return nil
+}
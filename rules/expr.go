@@ -0,0 +1,477 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/pkg/errors"
+)
+
+// units are the suffixes allowed after a number, scaling it to wei
+var units = map[string]int64{
+	"wei":   1,
+	"gwei":  1e9,
+	"ether": 1e18,
+	"eth":   1e18,
+}
+
+// Expr is a compiled condition evaluated against event documents, see filter.Document.
+//
+// Conditions compare fields, referred to by dotted path such as
+// contractCall.methodName, with literals or other fields:
+//
+//	value > 100 ETH and direction == "outgoing"
+//	contractCall.methodName == "approve" and counterparty not in allowlist
+//	eventCode in ["txSpeedUp", "txCancel"] or not (gas < 100000)
+//
+// The operators are ==, !=, <, <=, >, >=, in, not in, contains and matches,
+// which takes a regular expression. Conditions are combined with and, or and
+// not, or &&, || and !. Strings compare case insensitively and numbers,
+// including numeric strings such as wei values, compare numerically. Numbers
+// may be followed by a unit of wei, gwei, eth or ether. A bare name that is
+// not a field path is looked up in the named lists. A field on its own is
+// true if it is set to anything but false, zero or an empty string.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses a condition, lists holds the named lists it may refer to
+func Compile(src string, lists map[string][]string) (*Expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks, lists: lists}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %v", tok)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// Match reports whether the document satisfies the condition
+func (e *Expr) Match(doc map[string]interface{}) bool {
+	return truthy(e.root.eval(doc))
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of condition"
+	case tokString:
+		return "string " + t.text
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// is reports whether the token is the given operator or keyword, keywords ignoring case
+func (t token) is(text string) bool {
+	return (t.kind == tokOp || t.kind == tokIdent) && strings.EqualFold(t.text, text)
+}
+
+// comparisons are the operators comparing two values
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for ; end < len(src) && rune(src[end]) != c; end++ {
+				if src[end] == '\\' && end+1 < len(src) {
+					end++
+				}
+				sb.WriteByte(src[end])
+			}
+			if end >= len(src) {
+				return nil, errors.Errorf("unterminated string at offset %d", i)
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String(), pos: i})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			end := i + 1
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.' || unicode.IsLetter(rune(src[end]))) {
+				end++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:end], pos: i})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || strings.ContainsRune("_.", rune(src[end]))) {
+				end++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	src   string
+	toks  []token
+	lists map[string][]string
+}
+
+func (p *parser) peek() token {
+	return p.toks[0]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[0]
+	if tok.kind != tokEOF {
+		p.toks = p.toks[1:]
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return errors.Errorf("%s at offset %d of condition:%v", fmt.Sprintf(format, args...), tok.pos, p.src)
+}
+
+func (p *parser) or() (node, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("or") || p.peek().is("||") {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = logical{or: true, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (node, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("and") || p.peek().is("&&") {
+		p.next()
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = logical{l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) not() (node, error) {
+	if p.peek().is("not") || p.peek().is("!") {
+		p.next()
+		n, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return negate{n}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	l, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	op := strings.ToLower(tok.text)
+	switch {
+	case tok.kind == tokOp && comparisons[op]:
+	case tok.is("in"), tok.is("contains"), tok.is("matches"):
+	case tok.is("not") && len(p.toks) > 1 && p.toks[1].is("in"):
+		p.next()
+		op = "not in"
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	cmp := comparison{op: op, l: l, r: r}
+	if op == "matches" {
+		lit, ok := r.(literal)
+		if !ok {
+			return nil, p.errorf(tok, "matches takes a string")
+		}
+		pattern, _ := lit.v.(string)
+		if cmp.re, err = regexp.Compile("(?i)" + pattern); err != nil {
+			return nil, p.errorf(tok, "invalid pattern:%v", err)
+		}
+	}
+	return cmp, nil
+}
+
+func (p *parser) value() (node, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == tokString:
+		return literal{tok.text}, nil
+	case tok.kind == tokNumber:
+		v, err := number(tok.text)
+		if err != nil {
+			return nil, p.errorf(tok, "%v", err)
+		}
+		// a unit may also follow the number after a space
+		if next := p.peek(); next.kind == tokIdent {
+			if scale, ok := units[strings.ToLower(next.text)]; ok {
+				p.next()
+				return literal{scaled(v, scale)}, nil
+			}
+		}
+		return literal{scaled(v, 1)}, nil
+	case tok.is("("):
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); !tok.is(")") {
+			return nil, p.errorf(tok, "expected ')' but got %v", tok)
+		}
+		return n, nil
+	case tok.is("["):
+		var items list
+		for !p.peek().is("]") {
+			if len(items) > 0 {
+				if tok := p.next(); !tok.is(",") {
+					return nil, p.errorf(tok, "expected ',' but got %v", tok)
+				}
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		p.next()
+		return items, nil
+	case tok.is("true"), tok.is("false"):
+		return literal{strings.EqualFold(tok.text, "true")}, nil
+	case tok.is("null"), tok.is("nil"):
+		return literal{nil}, nil
+	case tok.kind == tokIdent:
+		if values, ok := p.lists[tok.text]; ok && !strings.Contains(tok.text, ".") {
+			items := make(list, len(values))
+			for i, v := range values {
+				items[i] = literal{v}
+			}
+			return items, nil
+		}
+		return field(tok.text), nil
+	}
+	return nil, p.errorf(tok, "unexpected %v", tok)
+}
+
+// number parses a number along with a unit written without a space, such as 1.5eth
+func number(text string) (*big.Rat, error) {
+	digits := strings.TrimRightFunc(text, unicode.IsLetter)
+	v, ok := new(big.Rat).SetString(digits)
+	if !ok {
+		return nil, errors.Errorf("invalid number:%v", text)
+	}
+	if unit := text[len(digits):]; unit != "" {
+		scale, ok := units[strings.ToLower(unit)]
+		if !ok {
+			return nil, errors.Errorf("unknown unit:%v", unit)
+		}
+		v.Mul(v, new(big.Rat).SetInt64(scale))
+	}
+	return v, nil
+}
+
+// scaled returns v times scale as a json number, which filter comparisons
+// handle with arbitrary precision
+func scaled(v *big.Rat, scale int64) json.Number {
+	v = new(big.Rat).Mul(v, new(big.Rat).SetInt64(scale))
+	if v.IsInt() {
+		return json.Number(v.Num().String())
+	}
+	return json.Number(strings.TrimRight(v.FloatString(18), "0"))
+}
+
+type node interface {
+	eval(doc map[string]interface{}) interface{}
+}
+
+type literal struct {
+	v interface{}
+}
+
+func (n literal) eval(map[string]interface{}) interface{} {
+	return n.v
+}
+
+type field string
+
+func (n field) eval(doc map[string]interface{}) interface{} {
+	v, _ := filter.Lookup(doc, string(n))
+	return v
+}
+
+type list []node
+
+func (n list) eval(doc map[string]interface{}) interface{} {
+	values := make([]interface{}, len(n))
+	for i, item := range n {
+		values[i] = item.eval(doc)
+	}
+	return values
+}
+
+type logical struct {
+	or   bool
+	l, r node
+}
+
+func (n logical) eval(doc map[string]interface{}) interface{} {
+	if n.or {
+		return truthy(n.l.eval(doc)) || truthy(n.r.eval(doc))
+	}
+	return truthy(n.l.eval(doc)) && truthy(n.r.eval(doc))
+}
+
+type negate struct {
+	n node
+}
+
+func (n negate) eval(doc map[string]interface{}) interface{} {
+	return !truthy(n.n.eval(doc))
+}
+
+type comparison struct {
+	op   string
+	l, r node
+	re   *regexp.Regexp
+}
+
+func (n comparison) eval(doc map[string]interface{}) interface{} {
+	l, r := n.l.eval(doc), n.r.eval(doc)
+	switch n.op {
+	case "==":
+		return filter.Equal(l, r)
+	case "!=":
+		return !filter.Equal(l, r)
+	case "in":
+		return contains(r, l)
+	case "not in":
+		return !contains(r, l)
+	case "contains":
+		if s, ok := l.(string); ok {
+			sub, _ := r.(string)
+			return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+		}
+		return contains(l, r)
+	case "matches":
+		s, ok := l.(string)
+		return ok && n.re.MatchString(s)
+	}
+	cmp, ok := filter.Compare(l, r)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// contains reports whether the list holds v, or any element of v if it is a list itself
+func contains(values, v interface{}) bool {
+	items, ok := values.([]interface{})
+	if !ok {
+		return false
+	}
+	if vs, ok := v.([]interface{}); ok {
+		for _, v := range vs {
+			if contains(items, v) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, item := range items {
+		if filter.Equal(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	case json.Number:
+		return t != "0"
+	default:
+		return true
+	}
+}
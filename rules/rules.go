@@ -0,0 +1,538 @@
+// Package rules raises alerts from the event stream. A rule matches events
+// with a condition, see Expr, and optional jsql filters. It counts matching
+// events per group within a window, fires its actions once enough were seen,
+// and then stays quiet for the group for a debounce period. Rules are declared
+// in YAML:
+//
+//	lists:
+//	  allowlist: ["0x7a250d5630b4cf539739df2c5dacb4c659f2488d"]
+//	rules:
+//	  - name: large-outgoing
+//	    when: value > 100 ETH and direction == "outgoing"
+//	    debounce: 10m
+//	    actions:
+//	      - type: webhook
+//	        url: http://localhost:9000/alerts
+//	  - name: unknown-approval
+//	    when: contractCall.methodName == "approve" and contractCall.params.spender not in allowlist
+//	    groupBy: [from]
+//	    threshold: 3
+//	    window: 1h
+//	    message: "{{.Count}} approvals by {{.Group.from}}"
+//	    actions:
+//	      - type: smtp
+//	        from: alerts@example.com
+//	        to: [ops@example.com]
+//	      - type: exec
+//	        command: [notify-send, blocknative]
+//	  - name: treasury-approvals
+//	    approvals:
+//	      owners: [treasury]
+//	      allowlist: [allowlist]
+//	    actions:
+//	      - type: log
+//
+// Rules with approvals use the detector of package approval, firing for
+// every approval which is unlimited or to a spender outside the allowlist.
+//
+// Time is taken from the events, so recorded events can be evaluated at any
+// speed. Engine is a sink.Sink, fed live events by the daemon or recorded
+// ones by replay, and Evaluate applies the rules without running actions.
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/approval"
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMessage is the message template of rules without one
+const DefaultMessage = `{{.Rule}}: {{.Transaction.eventCode}} {{.Transaction.hash}} from {{.Transaction.from}} to {{.Transaction.to}}` +
+	`{{if gt .Count 1}}, {{.Count}} matching events{{end}}{{if .Suppressed}}, {{.Suppressed}} suppressed{{end}}`
+
+// DefaultApprovalMessage is the message template of approval rules without one
+const DefaultApprovalMessage = `{{.Rule}}: {{.Details.Method}} by {{.Details.Owner}} lets {{.Details.Spender}} spend ` +
+	`{{if .Details.Unlimited}}an unlimited amount{{else if .Details.Amount}}{{.Details.Amount}}{{else}}token {{.Details.TokenID}}{{end}} ` +
+	`of {{.Details.Token}} ({{join .Details.Reasons ", "}}) in {{.Details.Hash}}`
+
+const (
+	// DefaultGroupTTL is how long groups of rules without a window or debounce
+	// are kept after their last matching event, their count is then forgotten
+	DefaultGroupTTL = time.Hour
+	// DefaultActionWorkers is the number of goroutines running actions for Write
+	DefaultActionWorkers = 4
+	// DefaultActionQueue is the number of alerts waiting for a worker before further ones are dropped
+	DefaultActionQueue = 256
+)
+
+// Severities of alerts raised by rules without one
+const (
+	DefaultSeverity         = "info"
+	DefaultApprovalSeverity = "high"
+)
+
+// Spec is a rules file
+type Spec struct {
+	// named lists of values that conditions can refer to, such as allowlists
+	Lists map[string][]string `yaml:"lists"`
+	Rules []RuleSpec          `yaml:"rules"`
+}
+
+// RuleSpec declares a single rule
+type RuleSpec struct {
+	// unique name reported in alerts
+	Name string `yaml:"name"`
+	// condition matching events must satisfy, see Expr
+	When string `yaml:"when"`
+	// jsql filters matching events must satisfy as well, see package filter
+	Filters []map[string]interface{} `yaml:"filters"`
+	// fields whose values matching events are counted by, such as from
+	GroupBy []string `yaml:"groupBy"`
+	// matching events of a group needed within Window to fire, defaults to 1.
+	// without a window events are counted since the group last fired, unless
+	// the group saw no matching event for DefaultGroupTTL
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	// period after firing in which matching events of the group are only counted as suppressed
+	Debounce time.Duration `yaml:"debounce"`
+	// reports approvals found in matching events, see package approval. owners,
+	// allowlist and nfts may name lists of the rules file as well as hold addresses
+	Approvals *approval.Opts `yaml:"approvals"`
+	// how serious alerts of the rule are, such as info, low, medium, high or critical.
+	// defaults to DefaultApprovalSeverity for approval rules and DefaultSeverity otherwise
+	Severity string `yaml:"severity"`
+	// text/template of the alert message executed with the Alert, defaults to
+	// DefaultApprovalMessage for approval rules and DefaultMessage otherwise
+	Message string `yaml:"message"`
+	// actions run when the rule fires, defaults to a log action
+	Actions []ActionSpec `yaml:"actions"`
+}
+
+// Load reads and validates a rules file
+func Load(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading rules")
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates rules
+func Parse(data []byte) (*Spec, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var spec Spec
+	if err := dec.Decode(&spec); err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "decoding rules")
+	}
+	names := make(map[string]bool, len(spec.Rules))
+	for _, r := range spec.Rules {
+		if r.Name == "" {
+			return nil, errors.New("rule name must be set")
+		}
+		if names[r.Name] {
+			return nil, errors.Errorf("rule declared twice:%v", r.Name)
+		}
+		names[r.Name] = true
+		if _, err := compile(r, spec.Lists, Opts{}); err != nil {
+			return nil, err
+		}
+	}
+	return &spec, nil
+}
+
+// Alert is raised when a rule fires
+type Alert struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// values of the rule's groupBy fields
+	Group map[string]string `json:"group,omitempty"`
+	// time of the event that fired the rule
+	Time time.Time `json:"time"`
+	// matching events counted towards the threshold, the last of which fired the rule
+	Count  int      `json:"count"`
+	Hashes []string `json:"hashes"`
+	// matching events of the group debounced since the rule last fired
+	Suppressed int    `json:"suppressed,omitempty"`
+	Message    string `json:"message"`
+	// what the rule detected, an approval.Finding for approval rules
+	Details interface{} `json:"details,omitempty"`
+	// transaction of the event that fired the rule as seen by conditions, see filter.Document
+	Transaction map[string]interface{} `json:"transaction"`
+	Event       *client.Event          `json:"-"`
+}
+
+// Opts configures an engine
+type Opts struct {
+	// client used by webhook actions, defaults to http.DefaultClient
+	Client *http.Client
+	// called when an action fails or an alert is dropped because the action
+	// queue is full, possibly from several workers at once. defaults to logging the error
+	OnError func(a *Alert, action string, err error)
+	// goroutines running the actions of alerts raised by Write, defaults to DefaultActionWorkers
+	Workers int
+	// alerts waiting for a worker before further ones are dropped, defaults to DefaultActionQueue
+	QueueSize int
+}
+
+// Engine applies rules to events
+type Engine struct {
+	opts  Opts
+	mx    sync.Mutex
+	rules []*rule
+
+	// alerts raised by Write waiting for their actions to run
+	qmx    sync.RWMutex
+	queue  chan *Alert
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type rule struct {
+	RuleSpec
+	when     *Expr
+	filter   *filter.Filter
+	detector *approval.Detector
+	message  *template.Template
+	actions  []action
+	groups   map[string]*group
+	// last time idle groups were removed
+	swept time.Time
+}
+
+// group tracks the matching events sharing the values of the groupBy fields
+type group struct {
+	hits       []hit
+	fired      time.Time
+	suppressed int
+	seen       time.Time
+}
+
+type hit struct {
+	time time.Time
+	hash string
+}
+
+// New returns an engine applying the rules of spec
+func New(spec *Spec, opts Opts) (*Engine, error) {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(a *Alert, action string, err error) {
+			log.Printf("rule %s: %s action failed: %v", a.Rule, action, err)
+		}
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultActionWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultActionQueue
+	}
+	e := &Engine{opts: opts, queue: make(chan *Alert, opts.QueueSize)}
+	for _, rs := range spec.Rules {
+		r, err := compile(rs, spec.Lists, opts)
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, r)
+	}
+	for i := 0; i < opts.Workers; i++ {
+		e.wg.Add(1)
+		go e.work()
+	}
+	return e, nil
+}
+
+// compile checks a rule and prepares its condition, filters, message and actions
+func compile(rs RuleSpec, lists map[string][]string, opts Opts) (*rule, error) {
+	if rs.When == "" && len(rs.Filters) == 0 && rs.Approvals == nil {
+		return nil, errors.Errorf("rule %v: when, filters or approvals must be set", rs.Name)
+	}
+	if rs.Threshold < 0 || rs.Window < 0 || rs.Debounce < 0 {
+		return nil, errors.Errorf("rule %v: threshold, window and debounce must not be negative", rs.Name)
+	}
+	if rs.Threshold == 0 {
+		rs.Threshold = 1
+	}
+	switch {
+	case rs.Approvals != nil && rs.Severity == "":
+		rs.Severity = DefaultApprovalSeverity
+	case rs.Severity == "":
+		rs.Severity = DefaultSeverity
+	}
+	switch {
+	case rs.Approvals != nil && rs.Message == "":
+		rs.Message = DefaultApprovalMessage
+	case rs.Message == "":
+		rs.Message = DefaultMessage
+	}
+	if len(rs.Actions) == 0 {
+		rs.Actions = []ActionSpec{{Type: LogAction}}
+	}
+	r := &rule{RuleSpec: rs, groups: make(map[string]*group)}
+	// yaml decodes numbers as ints, filters compare the float64s json decodes
+	data, err := json.Marshal(rs.Filters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "rule %v: encoding filters", rs.Name)
+	}
+	if r.filter, err = filter.Parse(data); err != nil {
+		return nil, errors.Wrapf(err, "rule %v", rs.Name)
+	}
+	if rs.When != "" {
+		if r.when, err = Compile(rs.When, lists); err != nil {
+			return nil, errors.Wrapf(err, "rule %v", rs.Name)
+		}
+	}
+	if rs.Approvals != nil {
+		opts := approval.Opts{
+			Owners:    expand(rs.Approvals.Owners, lists),
+			Allowlist: expand(rs.Approvals.Allowlist, lists),
+			NFTs:      expand(rs.Approvals.NFTs, lists),
+		}
+		if r.detector, err = approval.New(opts); err != nil {
+			return nil, errors.Wrapf(err, "rule %v: approvals", rs.Name)
+		}
+	}
+	if r.message, err = newTemplate(rs.Message); err != nil {
+		return nil, errors.Wrapf(err, "rule %v: parsing message", rs.Name)
+	}
+	for _, as := range rs.Actions {
+		a, err := newAction(as, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rule %v", rs.Name)
+		}
+		r.actions = append(r.actions, a)
+	}
+	return r, nil
+}
+
+// expand replaces the names of lists with their values
+func expand(values []string, lists map[string][]string) []string {
+	var out []string
+	for _, v := range values {
+		if list, ok := lists[v]; ok {
+			out = append(out, list...)
+		} else {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Evaluate applies the rules to ev and returns the alerts it raised without running their actions
+func (e *Engine) Evaluate(ev *client.Event) ([]*Alert, error) {
+	if ev.Payload.Event.Transaction.Hash == "" {
+		return nil, nil
+	}
+	doc, err := filter.Document(ev)
+	if err != nil {
+		return nil, err
+	}
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	var alerts []*Alert
+	for _, r := range e.rules {
+		if r.when != nil && !r.when.Match(doc) || !r.filter.MatchDocument(doc) {
+			continue
+		}
+		if r.detector == nil {
+			if alert := r.observe(ev, doc, nil); alert != nil {
+				alerts = append(alerts, alert)
+			}
+			continue
+		}
+		// malformed input makes no approvals
+		findings, _ := r.detector.Detect(ev)
+		for _, f := range findings {
+			if alert := r.observe(ev, doc, f); alert != nil {
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// observe counts a matching event and returns an alert if the rule fires,
+// details are what the rule detected in the event if anything
+func (r *rule) observe(ev *client.Event, doc map[string]interface{}, details interface{}) *Alert {
+	now := ev.ReceivedAt
+	values := make(map[string]string, len(r.GroupBy))
+	keys := make([]string, len(r.GroupBy))
+	for i, name := range r.GroupBy {
+		v, _ := filter.Lookup(doc, name)
+		if v != nil {
+			values[name] = strings.ToLower(fmt.Sprint(v))
+		}
+		keys[i] = values[name]
+	}
+	key := strings.Join(keys, "\x00")
+	g, ok := r.groups[key]
+	if !ok {
+		g = &group{}
+		r.groups[key] = g
+	}
+	g.seen = now
+	defer r.sweep(now)
+
+	if r.Debounce > 0 && !g.fired.IsZero() && now.Sub(g.fired) < r.Debounce {
+		g.suppressed++
+		return nil
+	}
+	if r.Window > 0 {
+		kept := g.hits[:0]
+		for _, h := range g.hits {
+			if now.Sub(h.time) <= r.Window {
+				kept = append(kept, h)
+			}
+		}
+		g.hits = kept
+	}
+	g.hits = append(g.hits, hit{time: now, hash: ev.Payload.Event.Transaction.Hash})
+	if len(g.hits) < r.Threshold {
+		return nil
+	}
+	alert := &Alert{
+		Rule:        r.Name,
+		Severity:    r.Severity,
+		Time:        now,
+		Count:       len(g.hits),
+		Suppressed:  g.suppressed,
+		Details:     details,
+		Transaction: doc,
+		Event:       ev,
+	}
+	if len(values) > 0 {
+		alert.Group = values
+	}
+	for _, h := range g.hits {
+		alert.Hashes = append(alert.Hashes, h.hash)
+	}
+	g.hits, g.suppressed, g.fired = nil, 0, now
+	var msg strings.Builder
+	if err := r.message.Execute(&msg, alert); err != nil {
+		alert.Message = fmt.Sprintf("%s: formatting message: %v", r.Name, err)
+	} else {
+		alert.Message = msg.String()
+	}
+	return alert
+}
+
+// sweep removes groups which neither count events within the window nor are
+// debounced, or saw no event for DefaultGroupTTL if the rule has neither
+func (r *rule) sweep(now time.Time) {
+	idle := r.Window
+	if r.Debounce > idle {
+		idle = r.Debounce
+	}
+	if idle == 0 {
+		idle = DefaultGroupTTL
+	}
+	if now.Sub(r.swept) < idle {
+		return
+	}
+	r.swept = now
+	for key, g := range r.groups {
+		if now.Sub(g.seen) > idle {
+			delete(r.groups, key)
+		}
+	}
+}
+
+// Write applies the rules to ev and queues the alerts raised for the workers
+// running their actions, so slow actions do not hold up evaluation. Alerts
+// which do not fit in the queue are dropped and reported to Opts.OnError.
+func (e *Engine) Write(ctx context.Context, ev *client.Event) error {
+	alerts, err := e.Evaluate(ev)
+	if err != nil {
+		return err
+	}
+	e.qmx.RLock()
+	defer e.qmx.RUnlock()
+	if e.closed {
+		return errors.New("engine closed")
+	}
+	for _, a := range alerts {
+		select {
+		case e.queue <- a:
+		default:
+			e.opts.OnError(a, "queue", errors.New("action queue full, alert dropped"))
+		}
+	}
+	return nil
+}
+
+// work runs the actions of queued alerts until the engine is closed and the queue drained
+func (e *Engine) work() {
+	defer e.wg.Done()
+	for a := range e.queue {
+		e.Run(context.Background(), a)
+	}
+}
+
+// Run runs the actions of the rule that raised the alert. Failed actions are
+// reported to Opts.OnError rather than returned, so that events are not
+// evaluated twice when a sink write is retried.
+func (e *Engine) Run(ctx context.Context, a *Alert) error {
+	var r *rule
+	for _, candidate := range e.rules {
+		if candidate.Name == a.Rule {
+			r = candidate
+		}
+	}
+	if r == nil {
+		return errors.Errorf("unknown rule:%v", a.Rule)
+	}
+	for i, action := range r.actions {
+		if err := action.run(ctx, a); err != nil {
+			e.opts.OnError(a, r.Actions[i].Type, err)
+		}
+	}
+	return nil
+}
+
+// Close waits for the actions of queued alerts to run and stops the workers
+func (e *Engine) Close() error {
+	e.qmx.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.qmx.Unlock()
+	e.wg.Wait()
+	return nil
+}
+
+func newTemplate(text string) (*template.Template, error) {
+	return template.New("alert").Funcs(template.FuncMap{
+		"eth":  func(v interface{}) string { return formatUnits(v, params.Ether, 6) },
+		"gwei": func(v interface{}) string { return formatUnits(v, params.GWei, 2) },
+		"join": strings.Join,
+	}).Parse(text)
+}
+
+// formatUnits formats a wei amount in the given unit
+func formatUnits(v interface{}, unit int64, prec int) string {
+	wei, ok := new(big.Float).SetString(fmt.Sprint(v))
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	return wei.Quo(wei, new(big.Float).SetInt64(unit)).Text('f', prec)
+}
@@ -0,0 +1,424 @@
+package rules
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/approval"
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/client/clienttest"
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/bonedaddy/go-blocknative/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sender = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+	router = "0x7a250d5630b4cf539739df2c5dacb4c659f2488d"
+)
+
+const swapEvent = `{"status":"ok","event":{"categoryCode":"activeAddress","eventCode":"txPool",
+	"contractCall":{"methodName":"swapExactETHForTokens","params":{"path":["0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2","0x6b175474e89094c44da98b954eedeac495271d0f"]}},
+	"transaction":{"status":"pending","hash":"0x01","from":"0xFA6DE2697D59E88ED7FC4DFE5A33DAC43565EA41","to":"0x7a250d5630b4cf539739df2c5dacb4c659f2488d",
+	"value":"1500000000000000000","gas":210000,"direction":"outgoing"}}}`
+
+// outgoing returns a pending transaction from sender to router calling method
+func outgoing(hash, method, value string) client.TxEvent {
+	return client.TxEvent{
+		BaseMessage:  client.BaseMessage{EventCode: "txPool"},
+		ContractCall: &client.ContractCall{MethodName: method},
+		Transaction:  client.EthTransaction{Hash: hash, From: sender, To: router, Value: value, Direction: "outgoing"},
+	}
+}
+
+func TestExpr(t *testing.T) {
+	ev, err := client.NewEvent(time.Now(), []byte(swapEvent))
+	require.NoError(t, err)
+	doc, err := filter.Document(ev)
+	require.NoError(t, err)
+	lists := map[string][]string{"allowlist": {router}}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{`status == "pending"`, true},
+		{`status != 'pending'`, false},
+		{`value > 1 ETH and direction == "outgoing"`, true},
+		{`value > 1.5eth`, false},
+		{`value >= 1500000000 gwei`, true},
+		{`gas < 100000 or eventCode == "txPool"`, true},
+		{`not (gas < 100000) && !contractCall.contractName`, true},
+		{`from == "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"`, true},
+		{`to in allowlist`, true},
+		{`from not in allowlist`, true},
+		{`eventCode in ["txSpeedUp", "txCancel"]`, false},
+		{`contractCall.params.path contains "0x6b175474e89094c44da98b954eedeac495271d0f"`, true},
+		{`contractCall.methodName contains "exacteth"`, true},
+		{`contractCall.methodName matches "^swap.*tokens$"`, true},
+		{`missing == null and missing != "x" and not (missing > 1)`, true},
+		{`STATUS == "pending" OR false`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := Compile(tt.expr, lists)
+			require.NoError(t, err)
+			require.Equal(t, tt.match, e.Match(doc))
+		})
+	}
+	for _, bad := range []string{`status ==`, `status == "pending`, `(gas > 1`, `value > 1 wei wei`, `value > 1btc`, `hash matches "("`, `gas @ 1`} {
+		_, err := Compile(bad, lists)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	spec, err := Parse([]byte(`
+lists:
+  allowlist: ["` + router + `"]
+rules:
+  - name: large
+    when: value > 10 ETH
+    debounce: 1m
+  - name: approvals
+    when: contractCall.methodName == "approve" and to not in allowlist
+    groupBy: [from]
+    threshold: 2
+    window: 10m
+    message: "{{.Count}} approvals by {{.Group.from}}, last {{eth .Transaction.value}} eth"
+  - name: pending
+    filters:
+      - direction: outgoing
+        value: {gt: 0}
+    when: contractCall.methodName == "none"
+`))
+	require.NoError(t, err)
+	e, err := New(spec, Opts{})
+	require.NoError(t, err)
+	start := time.Date(2021, 9, 14, 10, 0, 0, 0, time.UTC)
+	evaluate := func(ev *client.Event) []string {
+		alerts, err := e.Evaluate(ev)
+		require.NoError(t, err)
+		var messages []string
+		for _, a := range alerts {
+			messages = append(messages, a.Message)
+		}
+		return messages
+	}
+
+	// debounced events are counted in the next alert
+	require.Equal(t, []string{"large: txPool 0x01 from " + sender + " to " + router}, evaluate(clienttest.TxEvent(t, start, outgoing("0x01", "", "20000000000000000000"))))
+	require.Empty(t, evaluate(clienttest.TxEvent(t, start.Add(time.Second*30), outgoing("0x02", "", "20000000000000000000"))))
+	alerts, err := e.Evaluate(clienttest.TxEvent(t, start.Add(time.Minute*2), outgoing("0x03", "", "20000000000000000000")))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, 1, alerts[0].Suppressed)
+	require.Equal(t, []string{"0x03"}, alerts[0].Hashes)
+	require.Contains(t, alerts[0].Message, ", 1 suppressed")
+
+	// approvals to the router are allowed, others fire once two are seen within the window
+	require.Empty(t, evaluate(clienttest.TxEvent(t, start, outgoing("0x10", "approve", "0"))))
+	ev := clienttest.TxEvent(t, start, outgoing("0x11", "approve", "0"))
+	ev.Raw = json.RawMessage(strings.Replace(string(ev.Raw), router, "0x00000000000000000000000000000000000000aa", 1))
+	require.Empty(t, evaluate(ev))
+	ev.ReceivedAt = start.Add(time.Minute * 11)
+	require.Empty(t, evaluate(ev))
+	ev.ReceivedAt = start.Add(time.Minute * 12)
+	alerts, err = e.Evaluate(ev)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "2 approvals by "+sender+", last 0.000000 eth", alerts[0].Message)
+	require.Equal(t, map[string]string{"from": sender}, alerts[0].Group)
+	require.Equal(t, 2, alerts[0].Count)
+
+	// filters and the condition must both match, events without a transaction never do
+	require.Equal(t, []string{"pending: txPool 0x20 from " + sender + " to " + router}, evaluate(clienttest.TxEvent(t, start, outgoing("0x20", "none", "1"))))
+	require.Empty(t, evaluate(clienttest.TxEvent(t, start, outgoing("0x21", "none", "0"))))
+	status, err := client.NewEvent(start, []byte(`{"status":"ok"}`))
+	require.NoError(t, err)
+	require.Empty(t, evaluate(status))
+
+	for _, bad := range []string{
+		`rules: [{name: a}]`,
+		`rules: [{name: a, when: "gas >"}]`,
+		`rules: [{name: a, when: "gas > 1"}, {name: a, when: "gas > 1"}]`,
+		`rules: [{name: a, when: "gas > 1", actions: [{type: page}]}]`,
+		`rules: [{name: a, when: "gas > 1", actions: [{type: webhook}]}]`,
+		`rules: [{name: a, when: "gas > 1", actions: [{type: smtp, from: a@example.com, to: ["b@example.com\r\nBcc: c@example.com"]}]}]`,
+		`rules: [{name: a, when: "gas > 1", message: "{{.Rule"}]`,
+		`rules: [{name: a, when: "gas > 1", window: -1s}]`,
+		`rules: [{name: a, when: "gas > 1", unknown: 1}]`,
+	} {
+		_, err := Parse([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	spec, err := Parse([]byte(`
+rules:
+  - name: confirmed
+    when: eventCode == "txConfirmed"
+  - name: swaps
+    when: contractCall.methodName == "swapExactETHForTokens" and maxFeePerGas >= 100 gwei
+`))
+	require.NoError(t, err)
+	e, err := New(spec, Opts{})
+	require.NoError(t, err)
+	rp, err := client.OpenReplay(filepath.Join("..", "client", "testdata", "session.ndjson"), 0)
+	require.NoError(t, err)
+	defer rp.Close()
+	var fired []string
+	for {
+		frame, err := rp.Next()
+		if err != nil {
+			break
+		}
+		if frame.Direction != client.Inbound {
+			continue
+		}
+		ev, err := client.NewEvent(frame.Time, frame.Data)
+		require.NoError(t, err)
+		alerts, err := e.Evaluate(ev)
+		require.NoError(t, err)
+		for _, a := range alerts {
+			fired = append(fired, a.Rule+" "+a.Hashes[0][:6])
+		}
+	}
+	require.Equal(t, []string{"swaps 0xb2b2", "confirmed 0xa1a1"}, fired)
+}
+
+func TestActions(t *testing.T) {
+	dir := t.TempDir()
+	posted := make(chan *Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhook.Verify([]byte("s3cret"), body, r.Header.Get(webhook.DefaultSignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var a Alert
+		json.Unmarshal(body, &a)
+		posted <- &a
+	}))
+	defer srv.Close()
+	mails := make(chan string, 1)
+	relay := smtpRelay(t, mails)
+
+	out := filepath.Join(dir, "exec.json")
+	spec, err := Parse([]byte(`
+rules:
+  - name: large
+    when: value > 10 ETH
+    actions:
+      - type: webhook
+        url: ` + srv.URL + `
+        secret: s3cret
+      - type: smtp
+        addr: ` + relay + `
+        from: alerts@example.com
+        to: [ops@example.com]
+        subject: "{{.Rule}} alert"
+      - type: exec
+        command: [sh, -c, 'cat > ` + out + ` && test "$BLOCKNATIVE_HASH" = 0x01']
+  - name: failing
+    when: value > 10 ETH
+    actions:
+      - type: webhook
+        url: ` + srv.URL + `
+      - type: exec
+        command: [sh, -c, "echo broken; exit 3"]
+`))
+	require.NoError(t, err)
+	var (
+		mx     sync.Mutex
+		failed []string
+	)
+	e, err := New(spec, Opts{OnError: func(a *Alert, action string, err error) {
+		mx.Lock()
+		failed = append(failed, a.Rule+" "+action+": "+err.Error())
+		mx.Unlock()
+	}})
+	require.NoError(t, err)
+	require.NoError(t, e.Write(context.Background(), clienttest.TxEvent(t, time.Now(), outgoing("0x01", "", "20000000000000000000"))))
+	require.NoError(t, e.Close())
+
+	a := <-posted
+	require.Equal(t, "large", a.Rule)
+	require.Equal(t, []string{"0x01"}, a.Hashes)
+	require.Equal(t, "20000000000000000000", a.Transaction["value"])
+	mail := <-mails
+	require.Contains(t, mail, "Subject: large alert\r\n")
+	require.Contains(t, mail, "large: txPool 0x01")
+	data, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"rule":"large"`)
+	require.Equal(t, []string{
+		"failing webhook: endpoint returned status:401 Unauthorized",
+		"failing exec: broken: exit status 3",
+	}, failed)
+}
+
+func TestHeader(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"large alert", "large alert"},
+		{"large\nBcc: c@example.com", "large Bcc: c@example.com"},
+		{"large\rBcc: c@example.com", "large Bcc: c@example.com"},
+		{"large\r\nBcc: c@example.com", "large Bcc: c@example.com"},
+	} {
+		require.Equal(t, tt.want, header(tt.in), tt.in)
+	}
+}
+
+func TestActionQueue(t *testing.T) {
+	spec, err := Parse([]byte(`
+rules:
+  - name: slow
+    when: value > 0
+    actions:
+      - type: exec
+        command: [sleep, "0.3"]
+`))
+	require.NoError(t, err)
+	var dropped int
+	e, err := New(spec, Opts{Workers: 1, QueueSize: 1, OnError: func(a *Alert, action string, err error) {
+		require.Equal(t, "queue", action)
+		dropped++
+	}})
+	require.NoError(t, err)
+	// slow actions neither hold up writes nor pile up beyond the queue
+	start := time.Now()
+	for _, hash := range []string{"0x01", "0x02", "0x03"} {
+		require.NoError(t, e.Write(context.Background(), clienttest.TxEvent(t, start, outgoing(hash, "", "1"))))
+	}
+	require.Less(t, int64(time.Since(start)), int64(time.Millisecond*250))
+	require.NoError(t, e.Close())
+	require.GreaterOrEqual(t, dropped, 1)
+	require.Error(t, e.Write(context.Background(), clienttest.TxEvent(t, start, outgoing("0x04", "", "1"))))
+}
+
+func TestGroupTTL(t *testing.T) {
+	spec, err := Parse([]byte(`
+rules:
+  - name: repeated
+    when: value > 0
+    groupBy: [hash]
+    threshold: 2
+`))
+	require.NoError(t, err)
+	e, err := New(spec, Opts{})
+	require.NoError(t, err)
+	defer e.Close()
+	start := time.Date(2021, 9, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		_, err := e.Evaluate(clienttest.TxEvent(t, start.Add(time.Duration(i)*time.Second), outgoing(fmt.Sprintf("0x%02x", i), "", "1")))
+		require.NoError(t, err)
+	}
+	require.Len(t, e.rules[0].groups, 10)
+	// groups of rules without a window or debounce are forgotten once idle
+	_, err = e.Evaluate(clienttest.TxEvent(t, start.Add(DefaultGroupTTL*2), outgoing("0xff", "", "1")))
+	require.NoError(t, err)
+	require.Len(t, e.rules[0].groups, 1)
+}
+
+// smtpRelay accepts a single mail and sends its data to mails
+func smtpRelay(t *testing.T, mails chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ready")
+		var data strings.Builder
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				mails <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestApprovals(t *testing.T) {
+	spec, err := Parse([]byte(`
+lists:
+  treasury: ["` + sender + `"]
+  spenders: ["` + router + `"]
+rules:
+  - name: treasury-approvals
+    approvals:
+      owners: [treasury]
+      allowlist: [spenders]
+`))
+	require.NoError(t, err)
+	e, err := New(spec, Opts{})
+	require.NoError(t, err)
+	approve := func(spender, amount string) *client.Event {
+		input := "0x095ea7b3" + strings.Repeat("0", 24) + spender[2:] + fmt.Sprintf("%064s", amount)
+		ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"eventCode":"txPool","transaction":{"hash":"0x01","from":"`+
+			sender+`","to":"0x6b175474e89094c44da98b954eedeac495271d0f","input":"`+input+`"}}}`))
+		require.NoError(t, err)
+		return ev
+	}
+
+	alerts, err := e.Evaluate(approve(router, strings.Repeat("f", 64)))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, "high", alerts[0].Severity)
+	require.Equal(t, "treasury-approvals: approve by "+sender+" lets "+router+
+		" spend an unlimited amount of 0x6b175474e89094c44da98b954eedeac495271d0f (unlimited allowance) in 0x01", alerts[0].Message)
+	finding := alerts[0].Details.(approval.Finding)
+	require.Equal(t, router, finding.Spender)
+	require.True(t, finding.Unlimited)
+
+	alerts, err = e.Evaluate(approve("0x00000000000000000000000000000000000000aa", "64"))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Contains(t, alerts[0].Message, " spend 100 of ")
+	require.Contains(t, alerts[0].Message, "(spender not allowlisted)")
+
+	// limited approvals to allowlisted spenders are expected
+	alerts, err = e.Evaluate(approve(router, "64"))
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	_, err = Parse([]byte(`rules: [{name: a, approvals: {owners: [nobody]}}]`))
+	require.Error(t, err)
+}
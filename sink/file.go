@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+)
+
+// RotatingFile writes events as NDJSON to a file, rotating it once it
+// exceeds a maximum size. Rotated files are named path.1, path.2, etc...
+// with path.1 being the most recent.
+type RotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mx   sync.Mutex
+	fh   *os.File
+	size int64
+}
+
+// NewRotatingFile opens path for appending. Once a write would grow the file
+// beyond maxBytes it is rotated, keeping at most maxFiles rotated files.
+// A maxBytes of zero disables rotation.
+func NewRotatingFile(path string, maxBytes int64, maxFiles int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends the raw frame followed by a newline
+func (rf *RotatingFile) Write(ctx context.Context, ev *client.Event) error {
+	rf.mx.Lock()
+	defer rf.mx.Unlock()
+	line := append(append([]byte(nil), ev.Raw...), '\n')
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(line)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.fh.Write(line)
+	rf.size += int64(n)
+	return err
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mx.Lock()
+	defer rf.mx.Unlock()
+	return rf.fh.Close()
+}
+
+func (rf *RotatingFile) open() error {
+	fh, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "opening file")
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	rf.fh, rf.size = fh, info.Size()
+	return nil
+}
+
+// rotate shifts every rotated file up by one, discarding the oldest, and starts a new file
+func (rf *RotatingFile) rotate() error {
+	if err := rf.fh.Close(); err != nil {
+		return err
+	}
+	if rf.maxFiles > 0 {
+		os.Remove(rf.rotated(rf.maxFiles))
+		for i := rf.maxFiles - 1; i > 0; i-- {
+			if err := os.Rename(rf.rotated(i), rf.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "rotating file")
+			}
+		}
+		if err := os.Rename(rf.path, rf.rotated(1)); err != nil {
+			return errors.Wrap(err, "rotating file")
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return errors.Wrap(err, "rotating file")
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/bonedaddy/go-blocknative/client"
+)
+
+// NDJSON writes the raw frame of each event as a single line
+type NDJSON struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+// NewNDJSON returns a sink writing to w, which is closed on Close if it is closable
+func NewNDJSON(w io.Writer) *NDJSON {
+	return &NDJSON{w: w}
+}
+
+// Stdout returns a sink writing to standard output
+func Stdout() *NDJSON {
+	return NewNDJSON(nopCloser{os.Stdout})
+}
+
+// Write writes the raw frame followed by a newline
+func (n *NDJSON) Write(ctx context.Context, ev *client.Event) error {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	_, err := n.w.Write(append(append([]byte(nil), ev.Raw...), '\n'))
+	return err
+}
+
+// Close closes the underlying writer if it is closable
+func (n *NDJSON) Close() error {
+	if c, ok := n.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// nopCloser prevents closing stdout along with the sink
+type nopCloser struct {
+	io.Writer
+}
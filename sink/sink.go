@@ -0,0 +1,262 @@
+// Package sink delivers blocknative events to one or more destinations
+package sink
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+)
+
+// DefaultDrainTimeout is how long closing a sink waits for its buffered events when no timeout is given
+const DefaultDrainTimeout = time.Second * 5
+
+// Sink is a destination for events
+type Sink interface {
+	// Write delivers a single event, returning an error if it was not delivered
+	Write(ctx context.Context, ev *client.Event) error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// Options controls how the fan-out delivers events to a single sink
+type Options struct {
+	// number of times a failed write is retried before the event is given up on
+	Retries int
+	// delay before the first retry, doubled for each subsequent retry. defaults to 1 second
+	Backoff time.Duration
+	// maximum duration of a single write, zero means no timeout
+	Timeout time.Duration
+	// how long Close and Remove wait for buffered events before abandoning
+	// in-flight writes and retries, defaults to DefaultDrainTimeout
+	DrainTimeout time.Duration
+	// buffering between the fan-out and the sink
+	Buffer client.BufferOpts
+}
+
+// Stats reports delivery counters for a single sink
+type Stats struct {
+	Written uint64 // events written successfully
+	Retried uint64 // write attempts that were retried
+	Failed  uint64 // events given up on after exhausting retries
+	Buffer  client.BufferStats
+}
+
+// ErrorHandler is called whenever a sink gives up on an event
+type ErrorHandler func(name string, ev *client.Event, err error)
+
+// FanOut delivers each event to multiple sinks concurrently. Every sink has
+// its own buffer and goroutine, so a slow or failing sink does not hold up
+// the others unless its buffer uses the Block policy. FanOut is itself a Sink.
+type FanOut struct {
+	onError ErrorHandler
+	mx      sync.RWMutex
+	outs    map[string]*output
+	wg      sync.WaitGroup
+}
+
+type output struct {
+	sink Sink
+	opts Options
+	buf  *client.EventBuffer
+	// cancelled when draining times out, aborting writes and retry waits
+	ctx    context.Context
+	cancel context.CancelFunc
+	// closed once the buffer is drained and the sink closed, after closeErr is set
+	done     chan struct{}
+	closeErr error
+
+	mx    sync.Mutex
+	stats Stats
+}
+
+// NewFanOut returns a fan-out with no sinks, onError may be nil
+func NewFanOut(onError ErrorHandler) *FanOut {
+	return &FanOut{onError: onError, outs: make(map[string]*output)}
+}
+
+// Add registers a sink under name and starts delivering events to it
+func (f *FanOut) Add(name string, s Sink, opts Options) error {
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultDrainTimeout
+	}
+	buf, err := client.NewEventBuffer(opts.Buffer)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &output{sink: s, opts: opts, buf: buf, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	f.mx.Lock()
+	if _, ok := f.outs[name]; ok {
+		f.mx.Unlock()
+		cancel()
+		buf.Close()
+		return errors.Errorf("sink already registered:%v", name)
+	}
+	f.outs[name] = out
+	f.mx.Unlock()
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.deliver(name, out)
+	}()
+	return nil
+}
+
+// Remove stops delivering to the named sink, waits up to its DrainTimeout for
+// its buffered events and closes it, returning the error of closing the sink
+func (f *FanOut) Remove(name string) error {
+	f.mx.Lock()
+	out, ok := f.outs[name]
+	delete(f.outs, name)
+	f.mx.Unlock()
+	if !ok {
+		return errors.Errorf("sink not registered:%v", name)
+	}
+	return out.close()
+}
+
+// Write hands ev to every sink's buffer. Delivery happens asynchronously so
+// an error is only returned if a buffer could not accept the event.
+func (f *FanOut) Write(ctx context.Context, ev *client.Event) error {
+	f.mx.RLock()
+	outs := make([]*output, 0, len(f.outs))
+	for _, out := range f.outs {
+		outs = append(outs, out)
+	}
+	f.mx.RUnlock()
+	for _, out := range outs {
+		if err := out.buf.Push(ctx, ev); err != nil && err != client.ErrClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the counters of every registered sink keyed by name
+func (f *FanOut) Stats() map[string]Stats {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	stats := make(map[string]Stats, len(f.outs))
+	for name, out := range f.outs {
+		out.mx.Lock()
+		st := out.stats
+		out.mx.Unlock()
+		st.Buffer = out.buf.Stats()
+		stats[name] = st
+	}
+	return stats
+}
+
+// Close stops accepting events, waits up to their DrainTimeout for every sink
+// to drain its buffer and closes the sinks, returning the first error of closing one
+func (f *FanOut) Close() error {
+	f.mx.Lock()
+	outs := make(map[string]*output, len(f.outs))
+	for name, out := range f.outs {
+		out.buf.Close()
+		outs[name] = out
+		delete(f.outs, name)
+	}
+	f.mx.Unlock()
+	var first error
+	for name, out := range outs {
+		if err := out.close(); err != nil && first == nil {
+			first = errors.Wrapf(err, "closing sink %s", name)
+		}
+	}
+	f.wg.Wait()
+	return first
+}
+
+// close stops accepting events and waits for deliver to drain the buffer and
+// close the sink, cancelling the remaining writes once DrainTimeout passes
+func (out *output) close() error {
+	err := out.buf.Close()
+	select {
+	case <-out.done:
+	case <-time.After(out.opts.DrainTimeout):
+		out.cancel()
+		<-out.done
+	}
+	out.cancel()
+	if out.closeErr != nil {
+		return out.closeErr
+	}
+	return err
+}
+
+// deliver writes buffered events to the sink until the buffer is closed and drained, then closes the sink
+func (f *FanOut) deliver(name string, out *output) {
+	for {
+		// the buffer is drained even once writes are cancelled, failing the rest
+		ev, err := out.buf.Next(context.Background())
+		if err != nil {
+			break
+		}
+		if err := f.write(out, ev); err != nil {
+			out.mx.Lock()
+			out.stats.Failed++
+			out.mx.Unlock()
+			if f.onError != nil {
+				f.onError(name, ev, err)
+			}
+			continue
+		}
+		out.mx.Lock()
+		out.stats.Written++
+		out.mx.Unlock()
+	}
+	out.closeErr = out.sink.Close()
+	close(out.done)
+}
+
+// write attempts a single delivery with retries and exponential backoff until out is cancelled
+func (f *FanOut) write(out *output, ev *client.Event) error {
+	backoff := out.opts.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = writeTimeout(out.ctx, out.sink, out.opts.Timeout, ev); err == nil {
+			return nil
+		}
+		if attempt >= out.opts.Retries || out.ctx.Err() != nil {
+			return err
+		}
+		out.mx.Lock()
+		out.stats.Retried++
+		out.mx.Unlock()
+		select {
+		case <-out.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func writeTimeout(ctx context.Context, s Sink, timeout time.Duration, ev *client.Event) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return s.Write(ctx, ev)
+}
+
+// Consume writes every event read from buf to s until reading or writing fails
+func Consume(ctx context.Context, buf *client.EventBuffer, s Sink) error {
+	for {
+		ev, err := buf.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if err := s.Write(ctx, ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(t *testing.T, hash string) *client.Event {
+	ev, err := client.NewEvent(time.Now(), []byte(fmt.Sprintf(`{"event":{"transaction":{"hash":%q}}}`, hash)))
+	require.NoError(t, err)
+	return ev
+}
+
+// flakySink fails the first failures writes
+type flakySink struct {
+	mx       sync.Mutex
+	failures int
+	written  []string
+	closed   bool
+	closeErr error
+}
+
+func (fs *flakySink) Write(ctx context.Context, ev *client.Event) error {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	if fs.failures > 0 {
+		fs.failures--
+		return errors.New("flaky")
+	}
+	fs.written = append(fs.written, ev.Payload.Event.Transaction.Hash)
+	return nil
+}
+
+func (fs *flakySink) Close() error {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	fs.closed = true
+	return fs.closeErr
+}
+
+// blockingSink never completes a write before its context expires
+type blockingSink struct{}
+
+func (blockingSink) Write(ctx context.Context, ev *client.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingSink) Close() error { return nil }
+
+func TestFanOut(t *testing.T) {
+	ctx := context.Background()
+	var failed []string
+	var mx sync.Mutex
+	fan := NewFanOut(func(name string, ev *client.Event, err error) {
+		mx.Lock()
+		failed = append(failed, name)
+		mx.Unlock()
+	})
+	good := &flakySink{failures: 1}
+	require.NoError(t, fan.Add("good", good, Options{Retries: 2, Backoff: time.Millisecond}))
+	require.NoError(t, fan.Add("stuck", blockingSink{}, Options{Timeout: time.Millisecond}))
+	require.Error(t, fan.Add("good", good, Options{}))
+	for _, hash := range []string{"0x1", "0x2", "0x3"} {
+		require.NoError(t, fan.Write(ctx, testEvent(t, hash)))
+	}
+	require.Eventually(t, func() bool {
+		stats := fan.Stats()
+		return stats["good"].Written == 3 && stats["stuck"].Failed == 3
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, uint64(1), fan.Stats()["good"].Retried)
+	require.NoError(t, fan.Close())
+	// a failing sink does not affect the delivery or ordering of the others
+	require.Equal(t, []string{"0x1", "0x2", "0x3"}, good.written)
+	require.True(t, good.closed)
+	require.Equal(t, []string{"stuck", "stuck", "stuck"}, failed)
+
+	// removing a sink waits for its buffered events and returns the error of closing it
+	fan = NewFanOut(nil)
+	removed := &flakySink{failures: 2, closeErr: errors.New("closing")}
+	require.NoError(t, fan.Add("removed", removed, Options{Retries: 2, Backoff: time.Millisecond * 20}))
+	require.NoError(t, fan.Write(ctx, testEvent(t, "0x4")))
+	require.EqualError(t, fan.Remove("removed"), "closing")
+	require.Equal(t, []string{"0x4"}, removed.written)
+	require.True(t, removed.closed)
+	require.Error(t, fan.Remove("removed"))
+
+	// a failing sink is given up on once draining times out instead of retrying for the whole backoff
+	var gaveUp []string
+	fan = NewFanOut(func(name string, ev *client.Event, err error) {
+		mx.Lock()
+		gaveUp = append(gaveUp, ev.Payload.Event.Transaction.Hash)
+		mx.Unlock()
+	})
+	failing := &flakySink{failures: 100}
+	require.NoError(t, fan.Add("failing", failing, Options{Retries: 10, Backoff: time.Hour, DrainTimeout: time.Millisecond * 20}))
+	require.NoError(t, fan.Add("stuck", blockingSink{}, Options{DrainTimeout: time.Millisecond * 20}))
+	require.NoError(t, fan.Write(ctx, testEvent(t, "0x5")))
+	require.NoError(t, fan.Write(ctx, testEvent(t, "0x6")))
+	start := time.Now()
+	require.NoError(t, fan.Remove("failing"))
+	require.NoError(t, fan.Close())
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	require.Empty(t, failing.written)
+	require.True(t, failing.closed)
+	require.ElementsMatch(t, []string{"0x5", "0x6", "0x5", "0x6"}, gaveUp)
+}
+
+func TestRotatingFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line := len(testEvent(t, "0x1").Raw) + 1
+	rf, err := NewRotatingFile(path, int64(line*2), 2)
+	require.NoError(t, err)
+	for i := 1; i <= 7; i++ {
+		require.NoError(t, rf.Write(ctx, testEvent(t, fmt.Sprintf("0x%d", i))))
+	}
+	require.NoError(t, rf.Close())
+	lines := func(path string) int {
+		fh, err := os.Open(path)
+		require.NoError(t, err)
+		defer fh.Close()
+		var n int
+		for scanner := bufio.NewScanner(fh); scanner.Scan(); n++ {
+		}
+		return n
+	}
+	require.Equal(t, 1, lines(path))
+	require.Equal(t, 2, lines(path+".1"))
+	require.Equal(t, 2, lines(path+".2"))
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestWebhook(t *testing.T) {
+	var received [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, body)
+	}))
+	defer srv.Close()
+	ev := testEvent(t, "0x1")
+	require.Error(t, NewWebhook(srv.URL, nil, nil).Write(context.Background(), ev))
+	wh := NewWebhook(srv.URL, http.Header{"X-Token": []string{"secret"}}, nil)
+	require.NoError(t, wh.Write(context.Background(), ev))
+	require.Equal(t, [][]byte{ev.Raw}, received)
+}
+
+func TestNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	n := NewNDJSON(&out)
+	require.NoError(t, n.Write(context.Background(), testEvent(t, "0x1")))
+	require.NoError(t, n.Write(context.Background(), testEvent(t, "0x2")))
+	require.Equal(t, 2, bytes.Count(out.Bytes(), []byte("\n")))
+}
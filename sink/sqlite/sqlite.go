@@ -0,0 +1,219 @@
+// Package sqlite persists blocknative events to a local sqlite database
+// using a normalized schema so that historic mempool behaviour can be queried.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+	"github.com/pkg/errors"
+)
+
+// migrations are applied in order, the number applied is tracked with PRAGMA user_version
+var migrations = []string{
+	`CREATE TABLE transactions (
+		hash                     TEXT PRIMARY KEY,
+		system                   TEXT NOT NULL,
+		network                  TEXT NOT NULL,
+		type                     INTEGER NOT NULL,
+		from_address             TEXT NOT NULL,
+		to_address               TEXT NOT NULL,
+		value                    TEXT NOT NULL,
+		nonce                    INTEGER NOT NULL,
+		gas                      INTEGER NOT NULL,
+		gas_price                TEXT NOT NULL,
+		max_fee_per_gas          TEXT NOT NULL,
+		max_priority_fee_per_gas TEXT NOT NULL,
+		input                    TEXT NOT NULL,
+		status                   TEXT NOT NULL,
+		block_hash               TEXT NOT NULL,
+		block_number             INTEGER NOT NULL,
+		gas_used                 TEXT NOT NULL,
+		first_seen               TIMESTAMP NOT NULL,
+		last_seen                TIMESTAMP NOT NULL
+	);
+	CREATE INDEX transactions_from ON transactions (from_address);
+	CREATE INDEX transactions_to ON transactions (to_address);
+
+	CREATE TABLE events (
+		id              INTEGER PRIMARY KEY AUTOINCREMENT,
+		hash            TEXT NOT NULL REFERENCES transactions (hash),
+		event_code      TEXT NOT NULL,
+		status          TEXT NOT NULL,
+		watched_address TEXT NOT NULL,
+		direction       TEXT NOT NULL,
+		counterparty    TEXT NOT NULL,
+		replace_hash    TEXT NOT NULL,
+		blocks_pending  INTEGER NOT NULL,
+		time_pending    TEXT NOT NULL,
+		timestamp       TIMESTAMP NOT NULL,
+		received_at     TIMESTAMP NOT NULL,
+		raw             TEXT NOT NULL
+	);
+	CREATE INDEX events_hash ON events (hash);
+	CREATE INDEX events_watched_address ON events (watched_address);
+
+	CREATE TABLE balance_changes (
+		id             INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id       INTEGER NOT NULL REFERENCES events (id),
+		address        TEXT NOT NULL,
+		asset_type     TEXT NOT NULL,
+		asset_symbol   TEXT NOT NULL,
+		asset_contract TEXT NOT NULL,
+		delta          TEXT NOT NULL
+	);
+	CREATE INDEX balance_changes_address ON balance_changes (address);
+
+	CREATE TABLE contract_calls (
+		id               INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id         INTEGER NOT NULL REFERENCES events (id),
+		contract_address TEXT NOT NULL,
+		contract_type    TEXT NOT NULL,
+		contract_name    TEXT NOT NULL,
+		method_name      TEXT NOT NULL,
+		params           TEXT NOT NULL
+	);
+	CREATE INDEX contract_calls_address ON contract_calls (contract_address);`,
+}
+
+// Sink writes events to a sqlite database
+type Sink struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path and applies any pending migrations
+func Open(path string) (*Sink, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+	// sqlite only supports a single writer
+	db.SetMaxOpenConns(1)
+	if err := Migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Sink{db: db}, nil
+}
+
+// Migrate applies any migrations that have not yet been applied to db
+func Migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "reading schema version")
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "applying migration:%v", version+1)
+		}
+		// pragmas do not support placeholders
+		if _, err := tx.ExecContext(ctx, "PRAGMA user_version = "+strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "updating schema version")
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DB returns the underlying database handle for querying
+func (s *Sink) DB() *sql.DB {
+	return s.db
+}
+
+// Write stores an event. Frames which do not refer to a transaction, such
+// as subscription acknowledgements, are ignored.
+func (s *Sink) Write(ctx context.Context, ev *client.Event) error {
+	event := ev.Payload.Event
+	txn := event.Transaction
+	if txn.Hash == "" {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO transactions (
+			hash, system, network, type, from_address, to_address, value, nonce, gas, gas_price,
+			max_fee_per_gas, max_priority_fee_per_gas, input, status, block_hash, block_number,
+			gas_used, first_seen, last_seen
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET
+			status = excluded.status,
+			block_hash = CASE WHEN excluded.block_hash != '' THEN excluded.block_hash ELSE block_hash END,
+			block_number = CASE WHEN excluded.block_number != 0 THEN excluded.block_number ELSE block_number END,
+			gas_used = CASE WHEN excluded.gas_used != '' THEN excluded.gas_used ELSE gas_used END,
+			last_seen = excluded.last_seen`,
+		txn.Hash, event.System, event.Network, txn.Type, lower(txn.From), lower(txn.To), txn.Value,
+		txn.Nonce, txn.Gas, txn.GasPrice, txn.MaxFeePerGas, txn.MaxPriorityFeePerGas, txn.Input,
+		txn.Status, txn.BlockHash, txn.BlockNumber, txn.GasUsed, ev.ReceivedAt, ev.ReceivedAt,
+	); err != nil {
+		return errors.Wrap(err, "storing transaction")
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO events (
+			hash, event_code, status, watched_address, direction, counterparty, replace_hash,
+			blocks_pending, time_pending, timestamp, received_at, raw
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		txn.Hash, event.EventCode, txn.Status, lower(txn.WatchedAddress), txn.Direction,
+		lower(txn.Counterparty), txn.ReplaceHash, txn.BlocksPending, txn.TimePending,
+		event.Timestamp, ev.ReceivedAt, string(ev.Raw),
+	)
+	if err != nil {
+		return errors.Wrap(err, "storing event")
+	}
+	eventID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, change := range txn.NetBalanceChanges {
+		for _, asset := range change.BalanceChanges {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO balance_changes (event_id, address, asset_type, asset_symbol, asset_contract, delta)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				eventID, lower(change.Address), asset.Asset.Type, asset.Asset.Symbol,
+				lower(asset.Asset.ContractAddress), asset.Delta,
+			); err != nil {
+				return errors.Wrap(err, "storing balance change")
+			}
+		}
+	}
+	if call := event.ContractCall; call != nil {
+		params, err := json.Marshal(call.Params)
+		if err != nil {
+			return errors.Wrap(err, "encoding contract call params")
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO contract_calls (event_id, contract_address, contract_type, contract_name, method_name, params)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			eventID, lower(call.ContractAddress), call.ContractType, call.ContractName, call.MethodName, string(params),
+		); err != nil {
+			return errors.Wrap(err, "storing contract call")
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (s *Sink) Close() error {
+	return s.db.Close()
+}
+
+// addresses are stored lowercase so they can be compared with watched addresses
+func lower(s string) string {
+	return strings.ToLower(s)
+}
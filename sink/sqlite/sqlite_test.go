@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/sink"
+	"github.com/stretchr/testify/require"
+)
+
+var _ sink.Sink = (*Sink)(nil)
+
+func TestSink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "events.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	rp, err := client.OpenReplay("../../client/testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer rp.Close()
+	d := client.NewDispatcher()
+	buf, err := d.Subscribe(client.BufferOpts{})
+	require.NoError(t, err)
+	require.Equal(t, io.EOF, d.Run(ctx, rp))
+	require.NoError(t, buf.Close())
+	require.Equal(t, client.ErrClosed, sink.Consume(ctx, buf, db))
+
+	count := func(query string, args ...interface{}) int {
+		var n int
+		require.NoError(t, db.DB().QueryRow(query, args...).Scan(&n))
+		return n
+	}
+	require.Equal(t, 2, count("SELECT COUNT(*) FROM transactions"))
+	require.Equal(t, 3, count("SELECT COUNT(*) FROM events"))
+	require.Equal(t, 3, count("SELECT COUNT(*) FROM events WHERE watched_address = ?", "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"))
+	require.Equal(t, 2, count("SELECT COUNT(*) FROM balance_changes"))
+	require.Equal(t, 1, count("SELECT COUNT(*) FROM contract_calls WHERE method_name = ?", "swapExactETHForTokens"))
+
+	// the confirmation updates the transaction in place
+	var status string
+	var block int
+	require.NoError(t, db.DB().QueryRow(
+		"SELECT status, block_number FROM transactions WHERE nonce = 42",
+	).Scan(&status, &block))
+	require.Equal(t, "confirmed", status)
+	require.Equal(t, 13218001, block)
+	require.NoError(t, db.Close())
+
+	// reopening an existing database does not reapply migrations
+	db, err = Open(path)
+	require.NoError(t, err)
+	require.Equal(t, 3, count("SELECT COUNT(*) FROM events"))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/pkg/errors"
+)
+
+// Webhook posts the raw frame of each event to an http endpoint
+type Webhook struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+}
+
+// NewWebhook returns a sink posting to url with the given extra headers.
+// If client is nil http.DefaultClient is used.
+func NewWebhook(url string, headers http.Header, client *http.Client) *Webhook {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Webhook{url: url, headers: headers, client: client}
+}
+
+// Write posts the event, treating any non 2xx response as a failure
+func (wh *Webhook) Write(ctx context.Context, ev *client.Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(ev.Raw))
+	if err != nil {
+		return err
+	}
+	for key, values := range wh.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("webhook returned status:%v", resp.Status)
+	}
+	return nil
+}
+
+// Close is a no-op
+func (wh *Webhook) Close() error {
+	return nil
+}
@@ -0,0 +1,293 @@
+// Package wallet watches the pending transactions of owned wallets for
+// problems that need an operator, combining the blocknative event stream with
+// the last confirmed nonce and base fee. A Monitor alerts when a nonce gap
+// blocks later transactions, when a transaction has been pending for too many
+// blocks or too long, and when a transaction's fee cap falls below the
+// current base fee.
+package wallet
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/mempool"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// Kind is the type of problem an alert reports
+type Kind string
+
+const (
+	// NonceGap alerts when nonces are missing before pending transactions, blocking them
+	NonceGap Kind = "nonceGap"
+	// StuckBlocks alerts when a transaction has been pending for Opts.BlocksPending blocks
+	StuckBlocks Kind = "stuckBlocks"
+	// StuckTime alerts when a transaction has been pending for Opts.TimePending
+	StuckTime Kind = "stuckTime"
+	// Underpriced alerts when a transaction's fee cap is below the current base fee
+	Underpriced Kind = "underpriced"
+)
+
+// Alert reports a problem with the pending transactions of an address
+type Alert struct {
+	Kind    Kind      `json:"kind"`
+	Address string    `json:"address"`
+	Time    time.Time `json:"time"`
+	// Nonces are the offending nonces, the missing ones for gaps
+	Nonces []int `json:"nonces"`
+	// Blocked are the pending nonces that cannot be mined until a gap is filled
+	Blocked []int `json:"blocked,omitempty"`
+	// Hash is the offending transaction, unset for gaps
+	Hash          string        `json:"hash,omitempty"`
+	BlocksPending int           `json:"blocksPending,omitempty"`
+	TimePending   time.Duration `json:"timePending,omitempty"`
+	// FeeCap and BaseFee are set for underpriced alerts, in wei
+	FeeCap  *big.Int `json:"feeCap,omitempty"`
+	BaseFee *big.Int `json:"baseFee,omitempty"`
+}
+
+// String describes the alert
+func (a Alert) String() string {
+	switch a.Kind {
+	case NonceGap:
+		return fmt.Sprintf("%s: nonces %v missing, blocking %v", a.Address, a.Nonces, a.Blocked)
+	case StuckBlocks:
+		return fmt.Sprintf("%s: nonce %v (%s) pending for %d blocks", a.Address, a.Nonces, a.Hash, a.BlocksPending)
+	case StuckTime:
+		return fmt.Sprintf("%s: nonce %v (%s) pending for %s", a.Address, a.Nonces, a.Hash, a.TimePending)
+	case Underpriced:
+		return fmt.Sprintf("%s: nonce %v (%s) fee cap %s below base fee %s", a.Address, a.Nonces, a.Hash, a.FeeCap, a.BaseFee)
+	}
+	return fmt.Sprintf("%s: %s", a.Address, a.Kind)
+}
+
+// key identifies an alert so the same problem is only emitted once
+func (a Alert) key() string {
+	return fmt.Sprintf("%s/%s/%s/%v", a.Kind, a.Address, a.Hash, a.Nonces)
+}
+
+// Backend provides the chain state, an *ethclient.Client satisfies it
+type Backend interface {
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Opts configures a monitor, zero thresholds disable their checks
+type Opts struct {
+	// Addresses are the wallets to check, every sender in the mempool when empty
+	Addresses []string
+	// BlocksPending is how many blocks a transaction may be pending before alerting
+	BlocksPending int
+	// TimePending is how long a transaction may be pending before alerting
+	TimePending time.Duration
+	// Backend is queried for confirmed nonces and the latest block, when nil
+	// they are taken from confirmed transactions in the event stream
+	Backend Backend
+}
+
+// Monitor checks the pending transactions of a mempool
+type Monitor struct {
+	pool *mempool.Mempool
+	opts Opts
+
+	mx        sync.Mutex
+	confirmed map[string]int // next nonce expected to be mined for each address
+	block     int
+	baseFee   *big.Int
+	active    map[string]bool
+}
+
+// New returns a monitor checking the transactions in pool
+func New(pool *mempool.Mempool, opts Opts) *Monitor {
+	return &Monitor{
+		pool:      pool,
+		opts:      opts,
+		confirmed: make(map[string]int),
+		active:    make(map[string]bool),
+	}
+}
+
+// Apply tracks confirmed nonces, the latest block and its base fee from an
+// event, it does not update the mempool
+func (m *Monitor) Apply(ev *client.Event) {
+	tx := ev.Payload.Event.Transaction
+	if ev.Payload.Event.EventCode != "txConfirmed" || tx.From == "" {
+		return
+	}
+	from := strings.ToLower(tx.From)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if tx.Nonce+1 > m.confirmed[from] {
+		m.confirmed[from] = tx.Nonce + 1
+	}
+	if tx.BlockNumber >= m.block {
+		m.block = tx.BlockNumber
+		if fee, ok := new(big.Int).SetString(tx.BaseFeePerGas, 10); ok {
+			m.baseFee = fee
+		}
+	}
+}
+
+// refresh queries the backend for confirmed nonces and the latest header
+func (m *Monitor) refresh(ctx context.Context, addresses []string) error {
+	header, err := m.opts.Backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "fetching latest header")
+	}
+	confirmed := make(map[string]int, len(addresses))
+	for _, addr := range addresses {
+		nonce, err := m.opts.Backend.NonceAt(ctx, common.HexToAddress(addr), nil)
+		if err != nil {
+			return errors.Wrapf(err, "fetching nonce of %s", addr)
+		}
+		confirmed[addr] = int(nonce)
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for addr, nonce := range confirmed {
+		m.confirmed[addr] = nonce
+	}
+	m.block = int(header.Number.Int64())
+	m.baseFee = header.BaseFee
+	return nil
+}
+
+// Check returns every current problem with the monitored addresses
+func (m *Monitor) Check(ctx context.Context) ([]Alert, error) {
+	addresses := m.pool.Senders()
+	if len(m.opts.Addresses) > 0 {
+		addresses = make([]string, len(m.opts.Addresses))
+		for i, addr := range m.opts.Addresses {
+			addresses[i] = strings.ToLower(addr)
+		}
+	}
+	if m.opts.Backend != nil {
+		if err := m.refresh(ctx, addresses); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	var alerts []Alert
+	for _, addr := range addresses {
+		pending := m.pool.Pending(addr)
+		if len(pending) == 0 {
+			continue
+		}
+		m.mx.Lock()
+		next, known := m.confirmed[addr]
+		block, baseFee := m.block, m.baseFee
+		m.mx.Unlock()
+		if gap := gapAlert(addr, next, known, pending); gap != nil {
+			gap.Time = now
+			alerts = append(alerts, *gap)
+		}
+		for _, tx := range pending {
+			alert := Alert{Address: addr, Time: now, Nonces: []int{tx.Nonce}, Hash: tx.Hash}
+			if blocks := blocksPending(tx, block); m.opts.BlocksPending > 0 && blocks >= m.opts.BlocksPending {
+				alert.Kind, alert.BlocksPending = StuckBlocks, blocks
+				alerts = append(alerts, alert)
+			}
+			since := tx.PendingTimeStamp
+			if since.IsZero() {
+				since = tx.FirstSeen
+			}
+			if d := now.Sub(since); m.opts.TimePending > 0 && d >= m.opts.TimePending {
+				alert := alert
+				alert.Kind, alert.TimePending, alert.BlocksPending = StuckTime, d, 0
+				alerts = append(alerts, alert)
+			}
+			if feeCap := feeCap(tx); baseFee != nil && feeCap != nil && feeCap.Cmp(baseFee) < 0 {
+				alert := alert
+				alert.Kind, alert.FeeCap, alert.BaseFee, alert.BlocksPending = Underpriced, feeCap, baseFee, 0
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// Watch checks every interval until ctx is done, calling emit once for each
+// new problem. A problem is emitted again if it clears and comes back.
+func (m *Monitor) Watch(ctx context.Context, interval time.Duration, emit func(Alert)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		alerts, err := m.Check(ctx)
+		if err != nil {
+			return err
+		}
+		m.emitNew(alerts, emit)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// emitNew calls emit for alerts that were not active at the previous check
+func (m *Monitor) emitNew(alerts []Alert, emit func(Alert)) {
+	active := make(map[string]bool, len(alerts))
+	for _, alert := range alerts {
+		key := alert.key()
+		active[key] = true
+		if !m.active[key] {
+			emit(alert)
+		}
+	}
+	m.active = active
+}
+
+// gapAlert returns an alert if nonces are missing before pending transactions,
+// starting at the next confirmed nonce if known or the lowest pending nonce otherwise
+func gapAlert(addr string, next int, known bool, pending []mempool.Tx) *Alert {
+	nonces := make(map[int]bool, len(pending))
+	highest := 0
+	for _, tx := range pending {
+		nonces[tx.Nonce] = true
+		if tx.Nonce > highest {
+			highest = tx.Nonce
+		}
+	}
+	if !known {
+		next = pending[0].Nonce
+	}
+	var missing, blocked []int
+	for n := next; n <= highest; n++ {
+		switch {
+		case !nonces[n]:
+			missing = append(missing, n)
+		case len(missing) > 0:
+			blocked = append(blocked, n)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &Alert{Kind: NonceGap, Address: addr, Nonces: missing, Blocked: blocked}
+}
+
+// blocksPending returns how many blocks a transaction has been pending for
+func blocksPending(tx mempool.Tx, block int) int {
+	if tx.PendingBlockNumber > 0 && block > tx.PendingBlockNumber {
+		return block - tx.PendingBlockNumber
+	}
+	return tx.BlocksPending
+}
+
+// feeCap returns the most a transaction pays per gas, nil if it is unknown
+func feeCap(tx mempool.Tx) *big.Int {
+	for _, v := range []string{tx.MaxFeePerGas, tx.GasPrice} {
+		if fee, ok := new(big.Int).SetString(v, 10); ok {
+			return fee
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/client/clienttest"
+	"github.com/bonedaddy/go-blocknative/mempool"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+const owner = "0xfa6de2697d59e88ed7fc4dfe5a33dac43565ea41"
+
+type fakeBackend struct {
+	nonce   uint64
+	block   int64
+	baseFee int64
+}
+
+func (f *fakeBackend) NonceAt(context.Context, common.Address, *big.Int) (uint64, error) {
+	return f.nonce, nil
+}
+
+func (f *fakeBackend) HeaderByNumber(context.Context, *big.Int) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(f.block), BaseFee: big.NewInt(f.baseFee)}, nil
+}
+
+func kinds(alerts []Alert) map[Kind][]Alert {
+	out := make(map[Kind][]Alert)
+	for _, alert := range alerts {
+		out[alert.Kind] = append(out[alert.Kind], alert)
+	}
+	return out
+}
+
+func TestMonitorBackend(t *testing.T) {
+	pool := mempool.New()
+	backend := &fakeBackend{nonce: 5, block: 110, baseFee: 50}
+	mon := New(pool, Opts{Addresses: []string{owner}, BlocksPending: 5, Backend: backend})
+	pool.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x7", From: owner, Nonce: 7, PendingBlockNumber: 100, MaxFeePerGas: "100"}))
+	pool.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x8", From: owner, Nonce: 8, PendingBlockNumber: 108, GasPrice: "40"}))
+
+	alerts, err := mon.Check(context.Background())
+	require.NoError(t, err)
+	byKind := kinds(alerts)
+	require.Len(t, byKind[NonceGap], 1)
+	require.Equal(t, []int{5, 6}, byKind[NonceGap][0].Nonces)
+	require.Equal(t, []int{7, 8}, byKind[NonceGap][0].Blocked)
+	require.Len(t, byKind[StuckBlocks], 1)
+	require.Equal(t, []int{7}, byKind[StuckBlocks][0].Nonces)
+	require.Equal(t, 10, byKind[StuckBlocks][0].BlocksPending)
+	require.Len(t, byKind[Underpriced], 1)
+	require.Equal(t, "0x8", byKind[Underpriced][0].Hash)
+	require.Equal(t, big.NewInt(50), byKind[Underpriced][0].BaseFee)
+	require.Empty(t, byKind[StuckTime])
+
+	// once the missing nonces are mined the gap clears
+	backend.nonce = 7
+	alerts, err = mon.Check(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, kinds(alerts)[NonceGap])
+}
+
+func TestMonitorEvents(t *testing.T) {
+	pool := mempool.New()
+	mon := New(pool, Opts{TimePending: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var emitted []Alert
+	emit := func(alert Alert) { emitted = append(emitted, alert) }
+	check := func() {
+		alerts, err := mon.Check(ctx)
+		require.NoError(t, err)
+		mon.emitNew(alerts, emit)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	pool.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x3", From: owner, Nonce: 3, PendingTimeStamp: old}))
+	pool.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x5", From: owner, Nonce: 5}))
+	// without a confirmed nonce gaps are only found between pending nonces
+	check()
+	byKind := kinds(emitted)
+	require.Equal(t, []int{4}, byKind[NonceGap][0].Nonces)
+	require.Equal(t, []int{3}, byKind[StuckTime][0].Nonces)
+	require.True(t, byKind[StuckTime][0].TimePending >= time.Hour)
+
+	// problems are only emitted once while they last
+	check()
+	require.Len(t, emitted, 2)
+
+	// confirmations from the stream move the expected nonce and base fee
+	confirmed := clienttest.Event(t, time.Now(), "txConfirmed", client.EthTransaction{Hash: "0x1", From: owner, Nonce: 1, BlockNumber: 10, BaseFeePerGas: "100"})
+	mon.Apply(confirmed)
+	pool.Apply(confirmed)
+	pool.Apply(clienttest.Event(t, time.Now(), "txPool", client.EthTransaction{Hash: "0x6", From: owner, Nonce: 6, MaxFeePerGas: "10"}))
+	check()
+	byKind = kinds(emitted[2:])
+	require.Equal(t, []int{2, 4}, byKind[NonceGap][0].Nonces)
+	require.Equal(t, []int{3, 5, 6}, byKind[NonceGap][0].Blocked)
+	require.Equal(t, []int{6}, byKind[Underpriced][0].Nonces)
+	require.NotEmpty(t, byKind[Underpriced][0].String())
+
+	// Watch stops once ctx is done
+	cancel()
+	require.Equal(t, context.Canceled, mon.Watch(ctx, time.Millisecond, func(Alert) {}))
+}
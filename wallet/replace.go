@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/pkg/errors"
+)
+
+// MinBump is the smallest fee increase in percent that nodes accept for a
+// transaction replacing a pending one with the same nonce
+const MinBump = 10
+
+// Broadcaster sends signed transactions, an *ethclient.Client satisfies it
+type Broadcaster interface {
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// Replacer builds transactions replacing stuck pending transactions
+type Replacer struct {
+	// Auth signs replacements and must be for the stuck transaction's sender.
+	// Its GasPrice, GasTipCap and GasFeeCap are used as minimum fees, such as
+	// the currently suggested ones, when they are above the bumped fees.
+	Auth *bind.TransactOpts
+	// Bump is the fee increase in percent, raised to MinBump when lower
+	Bump int
+	// Backend broadcasts replacements unless it is nil or Auth.NoSend is set
+	Backend Broadcaster
+}
+
+// SpeedUp replaces a stuck transaction with the same transaction paying bumped fees
+func (r *Replacer) SpeedUp(ctx context.Context, payload client.EthTxPayload) (*types.Transaction, error) {
+	return r.replace(ctx, payload.Event.Transaction, false)
+}
+
+// Cancel replaces a stuck transaction with a zero value transfer from its sender to itself
+func (r *Replacer) Cancel(ctx context.Context, payload client.EthTxPayload) (*types.Transaction, error) {
+	return r.replace(ctx, payload.Event.Transaction, true)
+}
+
+func (r *Replacer) replace(ctx context.Context, stuck client.EthTransaction, cancel bool) (*types.Transaction, error) {
+	from, err := stuck.FromAddress()
+	if err != nil {
+		return nil, err
+	}
+	if from != r.Auth.From {
+		return nil, errors.Errorf("transaction sent by %s cannot be replaced by %s", from.Hex(), r.Auth.From.Hex())
+	}
+	orig, err := stuck.Unsigned()
+	if err != nil {
+		return nil, err
+	}
+	to, value, gas, input := orig.To(), orig.Value(), orig.Gas(), orig.Data()
+	if cancel {
+		to, value, gas, input = &from, new(big.Int), params.TxGas, nil
+	} else if to == nil {
+		return nil, errors.New("contract creations cannot be sped up")
+	}
+	var inner types.TxData
+	if orig.Type() == types.DynamicFeeTxType {
+		feeCap := r.bump(orig.GasFeeCap(), r.Auth.GasFeeCap)
+		tipCap := r.bump(orig.GasTipCap(), r.Auth.GasTipCap)
+		if tipCap.Cmp(feeCap) > 0 {
+			feeCap = tipCap
+		}
+		inner = &types.DynamicFeeTx{
+			Nonce:     orig.Nonce(),
+			GasTipCap: tipCap,
+			GasFeeCap: feeCap,
+			Gas:       gas,
+			To:        to,
+			Value:     value,
+			Data:      input,
+		}
+	} else {
+		inner = &types.LegacyTx{
+			Nonce:    orig.Nonce(),
+			GasPrice: r.bump(orig.GasPrice(), r.Auth.GasPrice),
+			Gas:      gas,
+			To:       to,
+			Value:    value,
+			Data:     input,
+		}
+	}
+	signed, err := r.Auth.Signer(r.Auth.From, types.NewTx(inner))
+	if err != nil {
+		return nil, errors.Wrap(err, "signing replacement")
+	}
+	if r.Backend != nil && !r.Auth.NoSend {
+		if err := r.Backend.SendTransaction(ctx, signed); err != nil {
+			return signed, errors.Wrap(err, "broadcasting replacement")
+		}
+	}
+	return signed, nil
+}
+
+// bump raises a fee by the replacement's bump rounding up, returning min if it is higher
+func (r *Replacer) bump(fee *big.Int, min *big.Int) *big.Int {
+	bump := r.Bump
+	if bump < MinBump {
+		bump = MinBump
+	}
+	bumped := new(big.Int).Mul(fee, big.NewInt(int64(100+bump)))
+	bumped.Add(bumped, big.NewInt(99))
+	bumped.Div(bumped, big.NewInt(100))
+	if min != nil && min.Cmp(bumped) > 0 {
+		return new(big.Int).Set(min)
+	}
+	return bumped
+}
@@ -0,0 +1,86 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+func stuckPayload(t *testing.T, tx string) client.EthTxPayload {
+	var payload client.EthTxPayload
+	require.NoError(t, json.Unmarshal([]byte(`{"event":{"eventCode":"txStuck","transaction":`+tx+`}}`), &payload))
+	return payload
+}
+
+func TestReplacer(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(t, err)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{
+		auth.From: {Balance: big.NewInt(params.Ether)},
+	}, 8_000_000)
+	defer sim.Close()
+	r := &Replacer{Auth: auth, Backend: sim}
+	recipient := common.HexToAddress("0x88df592f8eb5d7bd38bfef7deb0fbc02cf3778a0")
+
+	// an eip-1559 transfer sped up with both fees bumped by 10%
+	sped, err := r.SpeedUp(ctx, stuckPayload(t, fmt.Sprintf(`{"type":2,"from":"%s","to":"%s","value":"1000","gas":21000,
+		"nonce":0,"maxFeePerGas":"2000000000","maxPriorityFeePerGas":"1000000000","input":"0x"}`, auth.From.Hex(), recipient.Hex())))
+	require.NoError(t, err)
+	require.Equal(t, uint8(types.DynamicFeeTxType), sped.Type())
+	require.Equal(t, big.NewInt(2200000000), sped.GasFeeCap())
+	require.Equal(t, big.NewInt(1100000000), sped.GasTipCap())
+	require.Equal(t, uint64(0), sped.Nonce())
+	sim.Commit()
+	receipt, err := sim.TransactionReceipt(ctx, sped.Hash())
+	require.NoError(t, err)
+	require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	balance, err := sim.BalanceAt(ctx, recipient, nil)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(1000), balance)
+
+	// a legacy transaction cancelled with a zero value transfer to the sender
+	cancelled, err := r.Cancel(ctx, stuckPayload(t, fmt.Sprintf(`{"type":0,"from":"%s","to":"%s","value":"5000","gas":50000,
+		"nonce":1,"gasPrice":"2000000000","input":"0xa9059cbb"}`, auth.From.Hex(), recipient.Hex())))
+	require.NoError(t, err)
+	require.Equal(t, uint8(types.LegacyTxType), cancelled.Type())
+	require.Equal(t, big.NewInt(2200000000), cancelled.GasPrice())
+	require.Equal(t, auth.From, *cancelled.To())
+	require.Equal(t, 0, cancelled.Value().Sign())
+	require.Empty(t, cancelled.Data())
+	sim.Commit()
+	receipt, err = sim.TransactionReceipt(ctx, cancelled.Hash())
+	require.NoError(t, err)
+	require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	nonce, err := sim.NonceAt(ctx, auth.From, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), nonce)
+
+	// only the sender can replace its transactions
+	_, err = r.Cancel(ctx, stuckPayload(t, `{"from":"`+recipient.Hex()+`","nonce":0,"gasPrice":"1"}`))
+	require.Error(t, err)
+}
+
+func TestReplacerBump(t *testing.T) {
+	r := &Replacer{Bump: 5}
+	// bumps below the minimum are raised and rounded up
+	require.Equal(t, big.NewInt(8), r.bump(big.NewInt(7), nil))
+	r.Bump = 50
+	require.Equal(t, big.NewInt(150), r.bump(big.NewInt(100), nil))
+	// suggested fees above the bumped one are used instead
+	require.Equal(t, big.NewInt(200), r.bump(big.NewInt(100), big.NewInt(200)))
+}
@@ -0,0 +1,187 @@
+package wallet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrFailed is returned with the receipt of a transaction that was mined but reverted
+	ErrFailed = errors.New("transaction failed")
+	// ErrDropped is returned when blocknative reports a transaction left the mempool without being mined
+	ErrDropped = errors.New("transaction dropped")
+)
+
+const (
+	// receiptPollInterval is how often a receipt is fetched after confirmation while the node catches up
+	receiptPollInterval = time.Second
+	// updatesBuffer is how many lifecycle events are kept for a handle that is not being read
+	updatesBuffer = 32
+)
+
+// SendBackend broadcasts transactions and fetches their receipts, an *ethclient.Client satisfies it
+type SendBackend interface {
+	bind.ContractBackend
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// Sender broadcasts signed transactions, watching them first so no events are missed
+type Sender struct {
+	gw      *gateway.Gateway
+	backend SendBackend
+}
+
+// NewSender returns a sender watching transactions through gw, which must be
+// running, and broadcasting them through backend
+func NewSender(gw *gateway.Gateway, backend SendBackend) *Sender {
+	return &Sender{gw: gw, backend: backend}
+}
+
+// Send subscribes to the transaction's hash and then broadcasts it, returning
+// a handle following it until it is mined or dropped
+func (s *Sender) Send(ctx context.Context, tx *types.Transaction) (*Pending, error) {
+	sub, err := s.gw.Subscribe(gateway.Request{Hash: tx.Hash().Hex()})
+	if err != nil {
+		return nil, errors.Wrap(err, "watching transaction")
+	}
+	if err := s.backend.SendTransaction(ctx, tx); err != nil {
+		s.gw.Unsubscribe(sub.ID)
+		return nil, errors.Wrap(err, "broadcasting transaction")
+	}
+	followCtx, cancel := context.WithCancel(context.Background())
+	p := &Pending{
+		Tx:      tx,
+		hash:    tx.Hash(),
+		updates: make(chan *client.Event, updatesBuffer),
+		done:    make(chan struct{}),
+		cancel:  cancel,
+	}
+	go p.follow(followCtx, s, sub.ID)
+	return p, nil
+}
+
+// Pending is a broadcast transaction being followed
+type Pending struct {
+	// Tx is the transaction that was sent
+	Tx *types.Transaction
+
+	mx      sync.Mutex
+	hash    common.Hash
+	updates chan *client.Event
+	done    chan struct{}
+	cancel  context.CancelFunc
+	receipt *types.Receipt
+	err     error
+}
+
+// Hash returns the hash being followed, which changes when the transaction is sped up or cancelled
+func (p *Pending) Hash() common.Hash {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.hash
+}
+
+// Updates returns the transaction's lifecycle events, it is closed once the
+// transaction is final. Events are dropped while the channel is full.
+func (p *Pending) Updates() <-chan *client.Event {
+	return p.updates
+}
+
+// Wait blocks until the transaction is mined, returning its receipt, or
+// until it is dropped or ctx is done. Reverted transactions return their
+// receipt with ErrFailed.
+func (p *Pending) Wait(ctx context.Context) (*types.Receipt, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.done:
+		return p.receipt, p.err
+	}
+}
+
+// Close stops following the transaction
+func (p *Pending) Close() error {
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// follow reads events for the subscription until the transaction is final
+func (p *Pending) follow(ctx context.Context, s *Sender, id string) {
+	defer func() {
+		s.gw.Unsubscribe(id)
+		close(p.updates)
+		close(p.done)
+	}()
+	for {
+		ev, err := s.gw.Next(ctx, id)
+		if err != nil {
+			p.err = err
+			return
+		}
+		select {
+		case p.updates <- ev:
+		default:
+		}
+		tx := ev.Payload.Event.Transaction
+		switch ev.Payload.Event.EventCode {
+		case "txConfirmed", "txFailed":
+			hash, err := tx.TxHash()
+			if err != nil {
+				p.err = err
+				return
+			}
+			p.receipt, p.err = s.receipt(ctx, hash)
+			if p.err == nil && p.receipt.Status == types.ReceiptStatusFailed {
+				p.err = ErrFailed
+			}
+			return
+		case "txDropped", "txRejected":
+			p.err = ErrDropped
+			return
+		case "txSpeedUp", "txCancel":
+			// follow the replacement, which may be reported without the original hash
+			hash, err := tx.TxHash()
+			if err != nil || hash == p.Hash() {
+				continue
+			}
+			sub, err := s.gw.Subscribe(gateway.Request{Hash: tx.Hash})
+			if err != nil {
+				p.err = errors.Wrap(err, "watching replacement")
+				return
+			}
+			s.gw.Unsubscribe(id)
+			id = sub.ID
+			p.mx.Lock()
+			p.hash = hash
+			p.mx.Unlock()
+		}
+	}
+}
+
+// receipt fetches a receipt, retrying while the node has not seen it yet
+func (s *Sender) receipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	for {
+		receipt, err := s.backend.TransactionReceipt(ctx, hash)
+		if err == nil {
+			return receipt, nil
+		}
+		if err != ethereum.NotFound {
+			return nil, errors.Wrap(err, "fetching receipt")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(receiptPollInterval):
+		}
+	}
+}
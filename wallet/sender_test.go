@@ -0,0 +1,135 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/gateway"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConn records sent messages and serves frames pushed by the test
+type fakeConn struct {
+	mx     sync.Mutex
+	sent   []string
+	frames chan string
+}
+
+func (f *fakeConn) WriteJSON(out interface{}) error {
+	data, _ := json.Marshal(out)
+	var msg client.BaseMessage
+	json.Unmarshal(data, &msg)
+	f.mx.Lock()
+	f.sent = append(f.sent, msg.EventCode)
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *fakeConn) ReadJSON(out interface{}) error {
+	frame, ok := <-f.frames
+	if !ok {
+		return io.EOF
+	}
+	return json.Unmarshal([]byte(frame), out)
+}
+
+func (f *fakeConn) codes() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+// orderedBackend records what had been sent upstream when a transaction was broadcast
+type orderedBackend struct {
+	*backends.SimulatedBackend
+	conn         *fakeConn
+	sentAtSubmit []string
+	reject       error
+}
+
+func (o *orderedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	o.sentAtSubmit = o.conn.codes()
+	if o.reject != nil {
+		return o.reject
+	}
+	return o.SimulatedBackend.SendTransaction(ctx, tx)
+}
+
+func TestSender(t *testing.T) {
+	ctx := context.Background()
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	auth, err := bind.NewKeyedTransactorWithChainID(key, big.NewInt(1337))
+	require.NoError(t, err)
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{auth.From: {Balance: big.NewInt(params.Ether)}}, 8_000_000)
+	defer sim.Close()
+	conn := &fakeConn{frames: make(chan string)}
+	defer close(conn.frames)
+	gw := gateway.New(conn, func() client.BaseMessage { return client.NewBaseMessageMainnet("key") }, client.BufferOpts{})
+	defer gw.Close()
+	go gw.Run(ctx, conn)
+	backend := &orderedBackend{SimulatedBackend: sim, conn: conn}
+	sender := NewSender(gw, backend)
+
+	sign := func(nonce uint64) *types.Transaction {
+		to := common.HexToAddress("0x88df592f8eb5d7bd38bfef7deb0fbc02cf3778a0")
+		tx, err := auth.Signer(auth.From, types.NewTx(&types.DynamicFeeTx{
+			Nonce: nonce, GasTipCap: big.NewInt(params.GWei), GasFeeCap: big.NewInt(2 * params.GWei), Gas: params.TxGas, To: &to, Value: big.NewInt(1),
+		}))
+		require.NoError(t, err)
+		return tx
+	}
+
+	tx := sign(0)
+	pending, err := sender.Send(ctx, tx)
+	require.NoError(t, err)
+	// the hash was watched before the transaction was broadcast
+	require.Equal(t, []string{"txSent"}, backend.sentAtSubmit)
+	sim.Commit()
+	conn.frames <- `{"event":{"eventCode":"txPool","transaction":{"hash":"` + tx.Hash().Hex() + `"}}}`
+	conn.frames <- `{"event":{"eventCode":"txConfirmed","transaction":{"hash":"` + tx.Hash().Hex() + `"}}}`
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	receipt, err := pending.Wait(waitCtx)
+	require.NoError(t, err)
+	require.Equal(t, tx.Hash(), receipt.TxHash)
+	var codes []string
+	for ev := range pending.Updates() {
+		codes = append(codes, ev.Payload.Event.EventCode)
+	}
+	require.Equal(t, []string{"txPool", "txConfirmed"}, codes)
+	require.Equal(t, []string{"txSent", "unwatch"}, conn.codes())
+
+	// replacements are followed under their new hash
+	tx = sign(1)
+	pending, err = sender.Send(ctx, tx)
+	require.NoError(t, err)
+	replacement := "0x" + common.Bytes2Hex(make([]byte, 31)) + "01"
+	conn.frames <- `{"event":{"eventCode":"txSpeedUp","transaction":{"hash":"` + replacement + `","replaceHash":"` + tx.Hash().Hex() + `"}}}`
+	require.Eventually(t, func() bool { return pending.Hash() == common.HexToHash(replacement) }, time.Second, time.Millisecond*10)
+	conn.frames <- `{"event":{"eventCode":"txDropped","transaction":{"hash":"` + replacement + `"}}}`
+	_, err = pending.Wait(waitCtx)
+	require.Equal(t, ErrDropped, err)
+	require.Equal(t, []string{"txSent", "unwatch", "txSent", "txSent", "unwatch", "unwatch"}, conn.codes())
+	require.Empty(t, gw.Subscriptions())
+
+	// transactions the backend rejects are not left watched
+	backend.reject = errors.New("nonce too low")
+	_, err = sender.Send(ctx, sign(0))
+	require.Error(t, err)
+	require.Empty(t, gw.Subscriptions())
+}
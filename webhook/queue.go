@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is a single queued delivery
+type Message struct {
+	ID          string          `json:"id"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// queue is a durable on-disk queue, each message is stored in its own file
+// so that pending deliveries survive restarts. Messages which exhaust their
+// attempts are moved to the dead letter directory.
+type queue struct {
+	pendingDir string
+	deadDir    string
+	// called with files which are skipped because they cannot be read or decoded
+	onError func(err error)
+
+	mx  sync.Mutex
+	seq uint64
+}
+
+func openQueue(dir string, onError func(err error)) (*queue, error) {
+	q := &queue{pendingDir: filepath.Join(dir, "pending"), deadDir: filepath.Join(dir, "dead"), onError: onError}
+	for _, d := range []string{q.pendingDir, q.deadDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, errors.Wrap(err, "creating queue directory")
+		}
+	}
+	return q, nil
+}
+
+// push stores a new message which is immediately due
+func (q *queue) push(body []byte) (*Message, error) {
+	q.mx.Lock()
+	q.seq++
+	now := time.Now()
+	// zero padded so that lexical order of file names is queue order
+	id := fmt.Sprintf("%020d-%06d", now.UnixNano(), q.seq%1000000)
+	q.mx.Unlock()
+	msg := &Message{ID: id, CreatedAt: now, NextAttempt: now, Body: body}
+	return msg, q.write(q.pendingDir, msg)
+}
+
+// update persists a change to a pending message
+func (q *queue) update(msg *Message) error {
+	return q.write(q.pendingDir, msg)
+}
+
+// remove deletes a delivered message
+func (q *queue) remove(msg *Message) error {
+	return os.Remove(q.path(q.pendingDir, msg.ID))
+}
+
+// bury moves a message to the dead letter directory
+func (q *queue) bury(msg *Message) error {
+	if err := q.write(q.deadDir, msg); err != nil {
+		return err
+	}
+	return q.remove(msg)
+}
+
+// pending returns every pending message in queue order
+func (q *queue) pending() ([]*Message, error) {
+	return q.list(q.pendingDir)
+}
+
+// dead returns every dead letter in queue order
+func (q *queue) dead() ([]*Message, error) {
+	return q.list(q.deadDir)
+}
+
+func (q *queue) list(dir string) ([]*Message, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing queue")
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	msgs := make([]*Message, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// delivered since the directory was listed
+			continue
+		}
+		if err != nil {
+			q.onError(errors.Wrapf(err, "reading queued message:%v", name))
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			err = errors.Wrapf(err, "decoding queued message:%v", name)
+			// undecodable pending messages are set aside so the rest of the queue is delivered
+			if dir == q.pendingDir {
+				if rerr := os.Rename(path, filepath.Join(q.deadDir, name)); rerr != nil {
+					err = errors.Wrapf(rerr, "%v, moving it to the dead letters", err)
+				}
+			}
+			q.onError(err)
+			continue
+		}
+		msgs = append(msgs, &msg)
+	}
+	return msgs, nil
+}
+
+// write atomically replaces the file holding msg
+func (q *queue) write(dir string, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	path := q.path(dir, msg.ID)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "writing queued message")
+	}
+	return os.Rename(tmp, path)
+}
+
+func (q *queue) path(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
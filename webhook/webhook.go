@@ -0,0 +1,341 @@
+// Package webhook forwards blocknative events to http endpoints with signed
+// bodies, retries backed by a durable on-disk queue and dead letter storage.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/bonedaddy/go-blocknative/filter"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSignatureHeader carries the hmac of the request body
+	DefaultSignatureHeader = "X-Blocknative-Signature"
+	// DeliveryHeader carries the id of the queued message, which is stable across retries
+	DeliveryHeader = "X-Blocknative-Delivery"
+	// DefaultMaxAttempts is the number of attempts made before a message is dead lettered
+	DefaultMaxAttempts = 10
+	// how often Drain checks the queues
+	drainInterval = time.Millisecond * 50
+)
+
+// Endpoint is a single destination for forwarded events
+type Endpoint struct {
+	// unique name, also used as the queue directory name
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+	// if set the body is signed with HMAC-SHA256 using this key
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	// extra headers sent with every request
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// only events matching these jsql filters are forwarded, see package filter
+	Filters []map[string]interface{} `json:"filters,omitempty" yaml:"filters,omitempty"`
+	// attempts made before a message is moved to the dead letters, defaults to DefaultMaxAttempts
+	MaxAttempts int `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+}
+
+// Opts configures a forwarder
+type Opts struct {
+	// directory holding the queue of every endpoint
+	Dir string
+	// client used for deliveries, defaults to a client with a 30 second timeout
+	Client *http.Client
+	// delay before the first retry, doubled for every further retry. defaults to 1 second
+	Backoff time.Duration
+	// upper bound of the retry delay, defaults to 10 minutes
+	MaxBackoff time.Duration
+	// how often queues are checked for messages which are due, defaults to 1 second
+	PollInterval time.Duration
+	// header carrying the body signature, defaults to DefaultSignatureHeader
+	SignatureHeader string
+	// called when an endpoint's queue cannot be read or updated, defaults to logging the error
+	OnError func(endpoint string, err error)
+}
+
+// Forwarder delivers events to endpoints. Every event matching an
+// endpoint's filters is first written to that endpoint's queue, so events
+// are not lost if the endpoint is down or the process restarts.
+type Forwarder struct {
+	opts      Opts
+	endpoints []*endpoint
+	// cancelled by Close, aborting deliveries in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type endpoint struct {
+	Endpoint
+	filter *filter.Filter
+	queue  *queue
+	wake   chan struct{}
+}
+
+// New returns a forwarder and starts delivering any messages queued by a previous run
+func New(opts Opts, endpoints ...Endpoint) (*Forwarder, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("queue directory must be set")
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: time.Second * 30}
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute * 10
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.SignatureHeader == "" {
+		opts.SignatureHeader = DefaultSignatureHeader
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(endpoint string, err error) {
+			log.Printf("webhook %s: %v", endpoint, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{opts: opts, ctx: ctx, cancel: cancel}
+	seen := make(map[string]bool)
+	for _, ep := range endpoints {
+		if ep.Name == "" || strings.ContainsAny(ep.Name, `/\`) || ep.Name == "." || ep.Name == ".." {
+			cancel()
+			return nil, errors.Errorf("invalid endpoint name:%q", ep.Name)
+		}
+		if seen[ep.Name] {
+			cancel()
+			return nil, errors.Errorf("duplicate endpoint name:%v", ep.Name)
+		}
+		seen[ep.Name] = true
+		if ep.MaxAttempts <= 0 {
+			ep.MaxAttempts = DefaultMaxAttempts
+		}
+		name := ep.Name
+		q, err := openQueue(filepath.Join(opts.Dir, ep.Name), func(err error) { opts.OnError(name, err) })
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		f.endpoints = append(f.endpoints, &endpoint{
+			Endpoint: ep,
+			filter:   filter.New(ep.Filters),
+			queue:    q,
+			wake:     make(chan struct{}, 1),
+		})
+	}
+	for _, ep := range f.endpoints {
+		f.wg.Add(1)
+		go f.run(ep)
+	}
+	return f, nil
+}
+
+// Write queues ev for every endpoint whose filters it matches
+func (f *Forwarder) Write(ctx context.Context, ev *client.Event) error {
+	var doc map[string]interface{}
+	for _, ep := range f.endpoints {
+		if !ep.filter.Empty() {
+			if doc == nil {
+				var err error
+				if doc, err = filter.Document(ev); err != nil {
+					return err
+				}
+			}
+			if !ep.filter.MatchDocument(doc) {
+				continue
+			}
+		}
+		if _, err := ep.queue.push(ev.Raw); err != nil {
+			return errors.Wrapf(err, "queueing event for endpoint:%v", ep.Name)
+		}
+		select {
+		case ep.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending returns the messages waiting to be delivered to the named endpoint
+func (f *Forwarder) Pending(name string) ([]*Message, error) {
+	ep, err := f.endpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	return ep.queue.pending()
+}
+
+// DeadLetters returns the messages which exhausted their attempts for the named endpoint
+func (f *Forwarder) DeadLetters(name string) ([]*Message, error) {
+	ep, err := f.endpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	return ep.queue.dead()
+}
+
+// Drain waits until no endpoint has messages left to deliver, dead letters
+// do not count, returning early with ctx's error
+func (f *Forwarder) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		var pending int
+		for _, ep := range f.endpoints {
+			msgs, err := ep.queue.pending()
+			if err != nil {
+				return err
+			}
+			pending += len(msgs)
+		}
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "%d messages left to deliver", pending)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops delivering, aborting deliveries in flight. Messages still
+// queued are delivered by the next forwarder using the same directory.
+func (f *Forwarder) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return nil
+}
+
+func (f *Forwarder) endpoint(name string) (*endpoint, error) {
+	for _, ep := range f.endpoints {
+		if ep.Name == name {
+			return ep, nil
+		}
+	}
+	return nil, errors.Errorf("unknown endpoint:%v", name)
+}
+
+// run delivers due messages for an endpoint until the forwarder is closed
+func (f *Forwarder) run(ep *endpoint) {
+	defer f.wg.Done()
+	ticker := time.NewTicker(f.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		f.flush(ep)
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-ep.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush attempts every message which is due, in queue order
+func (f *Forwarder) flush(ep *endpoint) {
+	msgs, err := ep.queue.pending()
+	if err != nil {
+		f.opts.OnError(ep.Name, err)
+		return
+	}
+	for _, msg := range msgs {
+		select {
+		case <-f.ctx.Done():
+			return
+		default:
+		}
+		if time.Now().Before(msg.NextAttempt) {
+			continue
+		}
+		err := f.deliver(ep, msg)
+		if err == nil {
+			// the message is delivered again if it stays queued
+			if err := ep.queue.remove(msg); err != nil {
+				f.opts.OnError(ep.Name, errors.Wrap(err, "removing delivered message"))
+			}
+			continue
+		}
+		if f.ctx.Err() != nil {
+			// aborted by Close, which does not count as an attempt
+			return
+		}
+		msg.Attempts++
+		msg.LastError = err.Error()
+		if msg.Attempts >= ep.MaxAttempts {
+			if err := ep.queue.bury(msg); err != nil {
+				f.opts.OnError(ep.Name, errors.Wrap(err, "dead lettering message"))
+			}
+			continue
+		}
+		msg.NextAttempt = time.Now().Add(f.backoff(msg.Attempts))
+		if err := ep.queue.update(msg); err != nil {
+			f.opts.OnError(ep.Name, errors.Wrap(err, "updating message"))
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt after the given number of failures
+func (f *Forwarder) backoff(attempts int) time.Duration {
+	delay := f.opts.Backoff
+	for i := 1; i < attempts && delay < f.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > f.opts.MaxBackoff {
+		delay = f.opts.MaxBackoff
+	}
+	return delay
+}
+
+func (f *Forwarder) deliver(ep *endpoint, msg *Message) error {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodPost, ep.URL, bytes.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	for key, value := range ep.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, msg.ID)
+	if ep.Secret != "" {
+		req.Header.Set(f.opts.SignatureHeader, Sign([]byte(ep.Secret), msg.Body))
+	}
+	resp, err := f.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("endpoint returned status:%v", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for body, sha256= followed by the hex encoded HMAC-SHA256
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body, for use by receivers
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
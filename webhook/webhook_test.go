@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bonedaddy/go-blocknative/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarder(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("secret")
+	var (
+		mx       sync.Mutex
+		failures = 2
+		received [][]byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify(secret, body, r.Header.Get(DefaultSignatureHeader)) || r.Header.Get("X-Team") != "ops" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, body)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	opts := Opts{Dir: dir, Backoff: time.Millisecond, PollInterval: time.Millisecond * 5}
+	fw, err := New(opts,
+		Endpoint{
+			Name:    "pending",
+			URL:     srv.URL,
+			Secret:  string(secret),
+			Headers: map[string]string{"X-Team": "ops"},
+			Filters: []map[string]interface{}{{"status": "pending"}},
+		},
+		Endpoint{Name: "unsigned", URL: srv.URL, MaxAttempts: 2},
+	)
+	require.NoError(t, err)
+
+	rp, err := client.OpenReplay("../client/testdata/session.ndjson", 0)
+	require.NoError(t, err)
+	defer rp.Close()
+	d := client.NewDispatcher()
+	buf, err := d.Subscribe(client.BufferOpts{})
+	require.NoError(t, err)
+	d.Run(ctx, rp)
+	buf.Close()
+	for {
+		ev, err := buf.Next(ctx)
+		if err != nil {
+			break
+		}
+		if ev.Payload.Event.Transaction.Hash != "" {
+			require.NoError(t, fw.Write(ctx, ev))
+		}
+	}
+
+	// the two pending events are retried past the failures, the confirmation is filtered out
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(received) == 2
+	}, time.Second*5, time.Millisecond*10)
+	// unsigned requests are rejected so every message ends up as a dead letter
+	require.Eventually(t, func() bool {
+		dead, err := fw.DeadLetters("unsigned")
+		require.NoError(t, err)
+		return len(dead) == 3
+	}, time.Second*5, time.Millisecond*10)
+	dead, err := fw.DeadLetters("unsigned")
+	require.NoError(t, err)
+	require.Equal(t, 2, dead[0].Attempts)
+	require.Contains(t, dead[0].LastError, "401")
+	pending, err := fw.Pending("pending")
+	require.NoError(t, err)
+	require.Len(t, pending, 0)
+	require.NoError(t, fw.Close())
+
+	// messages queued while stopped are delivered once a forwarder is started on the same directory
+	q, err := openQueue(dir+"/pending", func(err error) { t.Error(err) })
+	require.NoError(t, err)
+	_, err = q.push([]byte(`{"queued":true}`))
+	require.NoError(t, err)
+	fw, err = New(opts, Endpoint{Name: "pending", URL: srv.URL, Secret: string(secret), Headers: map[string]string{"X-Team": "ops"}})
+	require.NoError(t, err)
+	defer fw.Close()
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(received) == 3
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New(Opts{})
+	require.Error(t, err)
+	_, err = New(Opts{Dir: t.TempDir()}, Endpoint{Name: "../escape"})
+	require.Error(t, err)
+	_, err = New(Opts{Dir: t.TempDir()}, Endpoint{Name: "a"}, Endpoint{Name: "a"})
+	require.Error(t, err)
+}
+
+func TestDrain(t *testing.T) {
+	var (
+		mx   sync.Mutex
+		down = true
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	fw, err := New(Opts{Dir: t.TempDir(), Backoff: time.Millisecond, PollInterval: time.Millisecond * 5}, Endpoint{Name: "a", URL: srv.URL})
+	require.NoError(t, err)
+	defer fw.Close()
+	ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x01"}}}`))
+	require.NoError(t, err)
+	require.NoError(t, fw.Write(context.Background(), ev))
+
+	// messages still retrying are waited for
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	require.EqualError(t, fw.Drain(ctx), "1 messages left to deliver: context deadline exceeded")
+	mx.Lock()
+	down = false
+	mx.Unlock()
+	require.NoError(t, fw.Drain(context.Background()))
+	pending, err := fw.Pending("a")
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestQueueErrors(t *testing.T) {
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- body
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	var (
+		mx     sync.Mutex
+		errors []string
+	)
+	fw, err := New(Opts{Dir: dir, PollInterval: time.Millisecond * 5, OnError: func(endpoint string, err error) {
+		mx.Lock()
+		errors = append(errors, endpoint+": "+err.Error())
+		mx.Unlock()
+	}}, Endpoint{Name: "a", URL: srv.URL})
+	require.NoError(t, err)
+	defer fw.Close()
+
+	// an undecodable message is set aside and reported without holding up the queue
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "pending", "0-corrupt.json"), []byte("{"), 0644))
+	ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x01"}}}`))
+	require.NoError(t, err)
+	require.NoError(t, fw.Write(context.Background(), ev))
+	require.JSONEq(t, string(ev.Raw), string(<-received))
+	_, err = os.Stat(filepath.Join(dir, "a", "dead", "0-corrupt.json"))
+	require.NoError(t, err)
+	mx.Lock()
+	defer mx.Unlock()
+	require.Len(t, errors, 1)
+	require.Contains(t, errors[0], "a: decoding queued message:0-corrupt.json")
+}
+
+func TestCloseAbortsDelivery(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body is read so that the server notices the client going away
+		ioutil.ReadAll(r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	fw, err := New(Opts{Dir: dir}, Endpoint{Name: "a", URL: srv.URL})
+	require.NoError(t, err)
+	ev, err := client.NewEvent(time.Now(), []byte(`{"event":{"transaction":{"hash":"0x01"}}}`))
+	require.NoError(t, err)
+	require.NoError(t, fw.Write(context.Background(), ev))
+	<-started
+
+	// closing does not wait for the client timeout, and the aborted delivery is not counted as an attempt
+	start := time.Now()
+	require.NoError(t, fw.Close())
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	pending, err := fw.Pending("a")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Zero(t, pending[0].Attempts)
+}